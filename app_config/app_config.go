@@ -13,6 +13,14 @@ type AppConfig struct {
 
 	LogLevel string `env:"LOG_LEVEL, default=info"`
 
+	// Games served by the deployment, the first one is the default for requests and actions that don't name a game
+	Games []string `env:"GAMES, default=default"`
+	// GameConfigDir holds a <game id>.json config per game, the embedded config is used for every game when unset
+	GameConfigDir string `env:"GAME_CONFIG_DIR"`
+	// GameApiKeys maps API keys to the game their requests are for, once set requests with other keys are rejected.
+	// Requests with one of them are rate limited per key, others per client IP.
+	GameApiKeys map[string]string `env:"GAME_API_KEYS"`
+
 	KafkaBrokers                             []string      `env:"KAFKA_BROKERS, default=localhost:9092"`
 	KafkaConsumerGroupId                     string        `env:"KAFKA_CONSUMER_GROUP_ID, default=consumer-group-id"`
 	KafkaTopic                               string        `env:"KAFKA_TOPIC, default=game-actions"`
@@ -21,11 +29,82 @@ type AppConfig struct {
 	KafkaLeaderboardTopicConsumerMinBytes    int           `env:"KAFKA_LEADERBOARD_TOPIC_CONSUMER_MIN_BYTES, default=1024"`
 	KafkaLeaderboardTopicConsumerMaxBytes    int           `env:"KAFKA_LEADERBOARD_TOPIC_CONSUMER_MAX_BYTES, default=10485760"`
 	KafkaLeaderboardTopicConsumerMaxWait     time.Duration `env:"KAFKA_LEADERBOARD_TOPIC_CONSUMER_MAX_WAIT, default=100ms"`
+	KafkaLeagueResultsTopic                  string        `env:"KAFKA_LEAGUE_RESULTS_TOPIC, default=league-results"`
+	KafkaTournamentPayoutsTopic              string        `env:"KAFKA_TOURNAMENT_PAYOUTS_TOPIC, default=tournament-payouts"`
+	KafkaAchievementsTopic                   string        `env:"KAFKA_ACHIEVEMENTS_TOPIC, default=achievements"`
+	KafkaOvertakenTopic                      string        `env:"KAFKA_OVERTAKEN_TOPIC, default=overtaken-notifications"`
 
 	ScyllaUrl      string `env:"SCYLLA_URL, default=127.0.0.1:9042"`
 	ScyllaNumConns int    `env:"SCYLLA_NUM_CONNS, default=10"`
 
 	RedisUrl string `env:"REDIS_URL, default=127.0.0.1:6379"`
+
+	JwtAlgorithm   string            `env:"JWT_ALGORITHM, default=HS256"`
+	JwtKeys        map[string]string `env:"JWT_KEYS"`
+	JwtActiveKeyId string            `env:"JWT_ACTIVE_KEY_ID, default=dev"`
+	JwtTtl         time.Duration     `env:"JWT_TTL, default=720h"`
+
+	RateLimitUserRequests   int           `env:"RATE_LIMIT_USER_REQUESTS, default=20"`
+	RateLimitUserWindow     time.Duration `env:"RATE_LIMIT_USER_WINDOW, default=1s"`
+	RateLimitApiKeyRequests int           `env:"RATE_LIMIT_API_KEY_REQUESTS, default=1000"`
+	RateLimitApiKeyWindow   time.Duration `env:"RATE_LIMIT_API_KEY_WINDOW, default=1s"`
+	// RateLimitIp* limit requests without a known API key per client IP, users behind one NAT share it
+	RateLimitIpRequests int           `env:"RATE_LIMIT_IP_REQUESTS, default=200"`
+	RateLimitIpWindow   time.Duration `env:"RATE_LIMIT_IP_WINDOW, default=1s"`
+
+	// PurgeEnabled exposes the purge backoffice endpoints, never enable it in production
+	PurgeEnabled         bool          `env:"PURGE_ENABLED, default=false"`
+	PurgeConfirmationTtl time.Duration `env:"PURGE_CONFIRMATION_TTL, default=1m"`
+
+	// LeagueCycleCheckInterval is how often instances check whether the league cycle has ended
+	LeagueCycleCheckInterval time.Duration `env:"LEAGUE_CYCLE_CHECK_INTERVAL, default=1m"`
+	// LeagueCycleLockTtl is how long a cycle close may go without renewing its claim before another instance takes over
+	LeagueCycleLockTtl time.Duration `env:"LEAGUE_CYCLE_LOCK_TTL, default=30m"`
+
+	// GlobalRankHistogramRefresh is how long approximate global ranks are computed from the same histogram
+	GlobalRankHistogramRefresh time.Duration `env:"GLOBAL_RANK_HISTOGRAM_REFRESH, default=5s"`
+
+	// TournamentCloseGrace is how long after its end a tournament still takes in-flight actions before it's closed
+	TournamentCloseGrace         time.Duration `env:"TOURNAMENT_CLOSE_GRACE, default=1m"`
+	TournamentCloseCheckInterval time.Duration `env:"TOURNAMENT_CLOSE_CHECK_INTERVAL, default=30s"`
+	TournamentCloseLockTtl       time.Duration `env:"TOURNAMENT_CLOSE_LOCK_TTL, default=10m"`
+	// TournamentRegistryRefresh is how long running tournaments are cached for scoring actions
+	TournamentRegistryRefresh time.Duration `env:"TOURNAMENT_REGISTRY_REFRESH, default=5s"`
+
+	WebhookWorkers     int           `env:"WEBHOOK_WORKERS, default=4"`
+	WebhookQueueSize   int           `env:"WEBHOOK_QUEUE_SIZE, default=1000"`
+	WebhookTimeout     time.Duration `env:"WEBHOOK_TIMEOUT, default=5s"`
+	WebhookMaxAttempts int           `env:"WEBHOOK_MAX_ATTEMPTS, default=5"`
+	// WebhookBackoffBase is the wait after the first failed attempt, it doubles with every further one up to WebhookBackoffMax
+	WebhookBackoffBase       time.Duration `env:"WEBHOOK_BACKOFF_BASE, default=1s"`
+	WebhookBackoffMax        time.Duration `env:"WEBHOOK_BACKOFF_MAX, default=1m"`
+	WebhookDeliveryRetention time.Duration `env:"WEBHOOK_DELIVERY_RETENTION, default=168h"`
+	WebhookRegistryRefresh   time.Duration `env:"WEBHOOK_REGISTRY_REFRESH, default=5s"`
+
+	// LeaderboardRegistryRefresh is how long leaderboard metadata is cached for listing and scoring
+	LeaderboardRegistryRefresh time.Duration `env:"LEADERBOARD_REGISTRY_REFRESH, default=5s"`
+
+	// ActionStatsFlushInterval is how often per-user action counters are moved from Redis to Scylla
+	ActionStatsFlushInterval  time.Duration `env:"ACTION_STATS_FLUSH_INTERVAL, default=10s"`
+	ActionStatsFlushBatchSize int           `env:"ACTION_STATS_FLUSH_BATCH_SIZE, default=500"`
+
+	// RankSnapshotInterval is how often every user's score and position are recorded into their rank history
+	RankSnapshotInterval time.Duration `env:"RANK_SNAPSHOT_INTERVAL, default=15m"`
+	RankHistoryRetention time.Duration `env:"RANK_HISTORY_RETENTION, default=2160h"`
+
+	// ActionLogRetention is how long processed actions are kept for dispute resolution
+	ActionLogRetention     time.Duration `env:"ACTION_LOG_RETENTION, default=4320h"`
+	ActionLogBufferSize    int           `env:"ACTION_LOG_BUFFER_SIZE, default=10000"`
+	ActionLogBatchSize     int           `env:"ACTION_LOG_BATCH_SIZE, default=200"`
+	ActionLogFlushInterval time.Duration `env:"ACTION_LOG_FLUSH_INTERVAL, default=1s"`
+
+	// OvertakenTopPositions is how deep into a leaderboard users are told they were overtaken
+	OvertakenTopPositions int `env:"OVERTAKEN_TOP_POSITIONS, default=100"`
+	// OvertakenNotificationLimit caps overtaken notifications a user gets per OvertakenNotificationWindow, the rest are dropped
+	OvertakenNotificationLimit  int           `env:"OVERTAKEN_NOTIFICATION_LIMIT, default=3"`
+	OvertakenNotificationWindow time.Duration `env:"OVERTAKEN_NOTIFICATION_WINDOW, default=10m"`
+	// NotificationStreamHeartbeat keeps idle notification streams alive through proxies
+	NotificationStreamHeartbeat time.Duration `env:"NOTIFICATION_STREAM_HEARTBEAT, default=15s"`
 }
 
 func NewAppConfig() *AppConfig {
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformedToken = errors.New("malformed token")
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrBadSignature   = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token expired")
+	ErrWrongGame      = errors.New("token issued for another game")
+)
+
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+type tokenClaims struct {
+	Sub string `json:"sub"`
+	// Gid is the game the token was issued for, tokens issued before games were introduced have none
+	Gid string `json:"gid,omitempty"`
+	Iat int64  `json:"iat"`
+	Exp int64  `json:"exp"`
+}
+
+type signingKey struct {
+	hmacSecret []byte
+	edPrivate  ed25519.PrivateKey
+}
+
+// TokenService issues and verifies player JWTs. Every configured key stays valid
+// for verification, only the active one is used for signing, so keys can be rotated
+// by adding a new key id, switching the active one and removing the old key after
+// issued tokens have expired. Tokens are only accepted by the game they were issued for.
+type TokenService struct {
+	alg         string
+	activeKeyId string
+	keys        map[string]*signingKey
+	ttl         time.Duration
+	game        *game_config.Game
+}
+
+func NewTokenService(ac *app_config.AppConfig, game *game_config.Game) *TokenService {
+	if ac.JwtAlgorithm != AlgHS256 && ac.JwtAlgorithm != AlgEdDSA {
+		panic(fmt.Sprintf("unsupported jwt algorithm: %s", ac.JwtAlgorithm))
+	}
+	if len(ac.JwtKeys) == 0 {
+		panic("no jwt keys are configured, set JWT_KEYS")
+	}
+	keys := make(map[string]*signingKey, len(ac.JwtKeys))
+	for kid, material := range ac.JwtKeys {
+		if ac.JwtAlgorithm == AlgHS256 {
+			keys[kid] = &signingKey{hmacSecret: []byte(material)}
+			continue
+		}
+		// Ed25519 keys are configured as base64 encoded 32 byte seeds
+		seed, err := base64.StdEncoding.DecodeString(material)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			panic(fmt.Sprintf("invalid ed25519 seed for jwt key %q", kid))
+		}
+		keys[kid] = &signingKey{edPrivate: ed25519.NewKeyFromSeed(seed)}
+	}
+	if _, ok := keys[ac.JwtActiveKeyId]; !ok {
+		panic(fmt.Sprintf("active jwt key %q is not configured", ac.JwtActiveKeyId))
+	}
+	return &TokenService{
+		alg:         ac.JwtAlgorithm,
+		activeKeyId: ac.JwtActiveKeyId,
+		keys:        keys,
+		ttl:         ac.JwtTtl,
+		game:        game,
+	}
+}
+
+func (t *TokenService) Issue(userId string) (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(&tokenHeader{Alg: t.alg, Typ: "JWT", Kid: t.activeKeyId})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(&tokenClaims{Sub: userId, Gid: t.game.Id, Iat: now.Unix(), Exp: now.Add(t.ttl).Unix()})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	signature := t.sign(t.keys[t.activeKeyId], []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Verify checks the token signature and expiry and returns the user id it was issued for.
+func (t *TokenService) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrMalformedToken
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrMalformedToken
+	}
+	header := &tokenHeader{}
+	if err := json.Unmarshal(headerBytes, header); err != nil {
+		return "", ErrMalformedToken
+	}
+	if header.Alg != t.alg {
+		return "", ErrBadSignature
+	}
+	key, ok := t.keys[header.Kid]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformedToken
+	}
+	if !t.verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return "", ErrBadSignature
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformedToken
+	}
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(claimsBytes, claims); err != nil {
+		return "", ErrMalformedToken
+	}
+	if time.Now().Unix() >= claims.Exp {
+		return "", ErrTokenExpired
+	}
+	if claims.Gid != t.game.Id && (claims.Gid != "" || !t.game.Default) {
+		return "", ErrWrongGame
+	}
+	return claims.Sub, nil
+}
+
+func (t *TokenService) sign(key *signingKey, input []byte) []byte {
+	if t.alg == AlgEdDSA {
+		return ed25519.Sign(key.edPrivate, input)
+	}
+	mac := hmac.New(sha256.New, key.hmacSecret)
+	mac.Write(input)
+	return mac.Sum(nil)
+}
+
+func (t *TokenService) verify(key *signingKey, input []byte, signature []byte) bool {
+	if t.alg == AlgEdDSA {
+		return ed25519.Verify(key.edPrivate.Public().(ed25519.PublicKey), input, signature)
+	}
+	return hmac.Equal(t.sign(key, input), signature)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -21,6 +22,12 @@ import (
 // HTTPClient holds the shared HTTP client with connection pooling
 var httpClient *http.Client
 
+// maxSignUpAttempts is how many nicknames are tried for a single user before giving up
+const maxSignUpAttempts = 5
+
+// errNicknameTaken is returned by registerUser when the server already has the nickname
+var errNicknameTaken = errors.New("nickname is taken")
+
 // Configuration loaded from environment variables
 type Config struct {
 	BaseURL     string
@@ -42,7 +49,8 @@ type SignUpRequest struct {
 
 // SignUpResponse represents the user registration response
 type SignUpResponse struct {
-	Id string `json:"id"`
+	Id    string `json:"id"`
+	Token string `json:"token"`
 }
 
 // ActionRequest represents the user action request
@@ -56,6 +64,7 @@ type ActionRequest struct {
 type BotUser struct {
 	ID       string
 	Nickname string
+	Token    string
 }
 
 // NicknameGenerator contains lists of words for generating friendly nicknames
@@ -362,12 +371,21 @@ func registerUsers(baseURL string, userCount int) ([]BotUser, error) {
 		go func(index int) {
 			defer wg.Done()
 
-			// Generate a unique, friendly nickname
-			mu.Lock()
-			nickname := nicknameGen.GenerateUniqueNickname(usedNicknames)
-			mu.Unlock()
-
-			userID, err := registerUser(baseURL, nickname)
+			var nickname string
+			var signUpResp *SignUpResponse
+			var err error
+			// Nicknames are unique server side too, so pick another one if a previous run already took it
+			for attempt := 0; attempt < maxSignUpAttempts; attempt++ {
+				// Generate a unique, friendly nickname
+				mu.Lock()
+				nickname = nicknameGen.GenerateUniqueNickname(usedNicknames)
+				mu.Unlock()
+
+				signUpResp, err = registerUser(baseURL, nickname)
+				if !errors.Is(err, errNicknameTaken) {
+					break
+				}
+			}
 			if err != nil {
 				slog.Error("Failed to register user", "nickname", nickname, "error", err)
 				errChan <- fmt.Errorf("failed to register user %s: %w", nickname, err)
@@ -376,12 +394,13 @@ func registerUsers(baseURL string, userCount int) ([]BotUser, error) {
 
 			mu.Lock()
 			users = append(users, BotUser{
-				ID:       userID,
+				ID:       signUpResp.Id,
 				Nickname: nickname,
+				Token:    signUpResp.Token,
 			})
 			mu.Unlock()
 
-			slog.Info("Successfully registered user", "nickname", nickname, "user_id", userID)
+			slog.Info("Successfully registered user", "nickname", nickname, "user_id", signUpResp.Id)
 		}(i)
 	}
 
@@ -398,14 +417,14 @@ func registerUsers(baseURL string, userCount int) ([]BotUser, error) {
 	return users, nil
 }
 
-func registerUser(baseURL, nickname string) (string, error) {
+func registerUser(baseURL, nickname string) (*SignUpResponse, error) {
 	signUpReq := SignUpRequest{
 		Nickname: nickname,
 	}
 
 	reqBody, err := json.Marshal(signUpReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal signup request: %w", err)
+		return nil, fmt.Errorf("failed to marshal signup request: %w", err)
 	}
 
 	url := fmt.Sprintf("%s/api/v1/users/sign-up", baseURL)
@@ -416,24 +435,28 @@ func registerUser(baseURL, nickname string) (string, error) {
 
 	resp, err := makeHTTPRequest(ctx, "POST", url, bytes.NewBuffer(reqBody), nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to make signup request: %w", err)
+		return nil, fmt.Errorf("failed to make signup request: %w", err)
 	}
 	defer func() {
 		io.Copy(io.Discard, resp.Body)
 		resp.Body.Close()
 	}()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, errNicknameTaken
+	}
+
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("signup failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("signup failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	var signUpResp SignUpResponse
-	if err := json.NewDecoder(resp.Body).Decode(&signUpResp); err != nil {
-		return "", fmt.Errorf("failed to decode signup response: %w", err)
+	signUpResp := &SignUpResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(signUpResp); err != nil {
+		return nil, fmt.Errorf("failed to decode signup response: %w", err)
 	}
 
-	return signUpResp.Id, nil
+	return signUpResp, nil
 }
 
 func runBots(baseURL string, users []BotUser, actions []string, rate time.Duration) {
@@ -444,7 +467,7 @@ func runBots(baseURL string, users []BotUser, actions []string, rate time.Durati
 		wg.Add(1)
 		go func(u BotUser) {
 			defer wg.Done()
-			emitActions(baseURL, u.ID, u.Nickname, actions, rate)
+			emitActions(baseURL, u, actions, rate)
 		}(user)
 	}
 
@@ -452,7 +475,8 @@ func runBots(baseURL string, users []BotUser, actions []string, rate time.Durati
 	wg.Wait()
 }
 
-func emitActions(baseURL, userID, nickname string, actions []string, rate time.Duration) {
+func emitActions(baseURL string, user BotUser, actions []string, rate time.Duration) {
+	userID, nickname := user.ID, user.Nickname
 	ticker := time.NewTicker(rate)
 	defer ticker.Stop()
 
@@ -467,7 +491,7 @@ func emitActions(baseURL, userID, nickname string, actions []string, rate time.D
 		// Select a random action from available actions
 		action := actions[rand.Intn(len(actions))]
 
-		if err := sendAction(baseURL, userID, action); err != nil {
+		if err := sendAction(baseURL, user, action); err != nil {
 			slog.Error("Failed to send action",
 				"nickname", nickname,
 				"user_id", userID,
@@ -484,9 +508,9 @@ func emitActions(baseURL, userID, nickname string, actions []string, rate time.D
 	}
 }
 
-func sendAction(baseURL, userID, action string) error {
+func sendAction(baseURL string, user BotUser, action string) error {
 	actionReq := ActionRequest{
-		UserID:    userID,
+		UserID:    user.ID,
 		Action:    action,
 		Timestamp: time.Now().Unix(),
 	}
@@ -502,7 +526,11 @@ func sendAction(baseURL, userID, action string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	resp, err := makeHTTPRequest(ctx, "POST", url, bytes.NewBuffer(reqBody), nil)
+	headers := map[string]string{
+		"Authorization": "Bearer " + user.Token,
+	}
+
+	resp, err := makeHTTPRequest(ctx, "POST", url, bytes.NewBuffer(reqBody), headers)
 	if err != nil {
 		return fmt.Errorf("failed to make action request: %w", err)
 	}
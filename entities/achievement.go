@@ -0,0 +1,28 @@
+package entities
+
+// UserAchievement is an achievement the user unlocked
+type UserAchievement struct {
+	UserId        string `json:"user_id"`
+	AchievementId string `json:"achievement_id"`
+	UnlockedAt    int64  `json:"unlocked_at"`
+}
+
+// Achievement is a configured achievement as seen by one user, with their progress towards it
+type Achievement struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Goal        int    `json:"goal"`
+	// Progress is how far the user got, it stays 0 for position achievements until they are unlocked
+	Progress   int   `json:"progress"`
+	Unlocked   bool  `json:"unlocked"`
+	UnlockedAt int64 `json:"unlocked_at,omitempty"`
+}
+
+// AchievementUnlockedEvent is published when a user unlocks an achievement
+type AchievementUnlockedEvent struct {
+	UserId        string `json:"user_id"`
+	AchievementId string `json:"achievement_id"`
+	Name          string `json:"name"`
+	UnlockedAt    int64  `json:"unlocked_at"`
+}
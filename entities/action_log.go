@@ -0,0 +1,36 @@
+package entities
+
+const (
+	ActionOutcomeApplied     = "applied"
+	ActionOutcomeShadow      = "shadow"
+	ActionOutcomeBanned      = "banned"
+	ActionOutcomeQuarantined = "quarantined"
+	ActionOutcomeFailed      = "failed"
+	ActionOutcomeUnknownUser = "unknown_user"
+)
+
+// ActionLogEntry records how one consumed game action was processed
+type ActionLogEntry struct {
+	UserId        string  `json:"user_id"`
+	Id            string  `json:"id"`
+	Action        string  `json:"action"`
+	LeaderboardId int     `json:"leaderboard_id"`
+	Timestamp     float64 `json:"timestamp"`
+	ReceivedAt    int64   `json:"received_at"`
+	ProcessedAt   int64   `json:"processed_at"`
+	// Outcome is one of "applied", "shadow", "banned", "quarantined", "failed" or "unknown_user"
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+	Score   int    `json:"score"`
+	XpDelta int    `json:"xp_delta"`
+	// Level is the user's level after the action
+	Level            int   `json:"level"`
+	ProcessingTimeUs int64 `json:"processing_time_us"`
+}
+
+// ActionLogPage is a page of a user's action log, latest first. Next is the cursor of the following page,
+// empty on the last one.
+type ActionLogPage struct {
+	Entries []*ActionLogEntry `json:"entries"`
+	Next    string            `json:"next,omitempty"`
+}
@@ -0,0 +1,14 @@
+package entities
+
+// DailyActionStats counts the actions a user performed on one UTC day
+type DailyActionStats struct {
+	Day     string         `json:"day"`
+	Actions map[string]int `json:"actions"`
+}
+
+type UserActionStats struct {
+	UserId string `json:"user_id"`
+	// Total counts every action performed since the user signed up
+	Total map[string]int      `json:"total"`
+	Daily []*DailyActionStats `json:"daily"`
+}
@@ -0,0 +1,5 @@
+package entities
+
+type ChangeNicknameRequest struct {
+	Nickname string `json:"nickname"`
+}
@@ -0,0 +1,37 @@
+package entities
+
+type Clan struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type ClanMember struct {
+	ClanId       string `json:"clan_id"`
+	UserId       string `json:"user_id"`
+	Nickname     string `json:"nickname"`
+	Contribution int    `json:"contribution"`
+	JoinedAt     int64  `json:"joined_at"`
+}
+
+type ClanScore struct {
+	ClanId   string `json:"clan_id"`
+	Name     string `json:"name"`
+	Score    int    `json:"score"`
+	Position int    `json:"position"`
+}
+
+type ClanDetails struct {
+	Clan    *Clan         `json:"clan"`
+	Score   *ClanScore    `json:"score"`
+	Members []*ClanMember `json:"members"`
+}
+
+type CreateClanRequest struct {
+	Name string `json:"name"`
+}
+
+type JoinClanRequest struct {
+	ClanId string `json:"clan_id"`
+}
@@ -0,0 +1,6 @@
+package entities
+
+type FlaggedUser struct {
+	UserId     string `json:"user_id"`
+	Violations int    `json:"violations"`
+}
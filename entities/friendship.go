@@ -0,0 +1,22 @@
+package entities
+
+type Friendship struct {
+	UserId    string `json:"user_id"`
+	FriendId  string `json:"friend_id"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// FriendRequest is stored for both users, it is pending until the requested user adds the requester back
+type FriendRequest struct {
+	UserId      string `json:"user_id"`
+	OtherUserId string `json:"other_user_id"`
+	// Incoming is set on the requested user's side
+	Incoming  bool  `json:"incoming"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+// FriendsLeaderboard ranks the user and their friends by the score on their own leaderboards
+type FriendsLeaderboard struct {
+	Scores []*LeaderboardScoreFull `json:"scores"`
+	User   *LeaderboardScoreFull   `json:"user"`
+}
@@ -1,8 +1,11 @@
 package entities
 
 type GameAction struct {
+	// GameId is set when the action is produced, consumed actions without it belong to the default game
+	GameId        string  `json:"game_id,omitempty"`
 	UserId        string  `json:"user_id"`
 	LeaderboardId int     `json:"leaderboard_id"`
 	Action        string  `json:"action"`
 	Timestamp     float64 `json:"timestamp"`
+	ReceivedAt    int64   `json:"received_at"`
 }
@@ -0,0 +1,7 @@
+package entities
+
+// GlobalLeaderboard ranks users across all leaderboards, entries have leaderboard 0
+type GlobalLeaderboard struct {
+	Scores []*LeaderboardScoreFull `json:"scores"`
+	User   *LeaderboardScoreFull   `json:"user"`
+}
@@ -0,0 +1,10 @@
+package entities
+
+type GlobalRank struct {
+	UserId      string  `json:"user_id"`
+	Score       int     `json:"score"`
+	Position    int     `json:"position"`
+	Total       int     `json:"total"`
+	TopPercent  float64 `json:"top_percent"`
+	Approximate bool    `json:"approximate"`
+}
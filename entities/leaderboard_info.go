@@ -0,0 +1,39 @@
+package entities
+
+const (
+	LeaderboardVisibilityPublic = "public"
+	LeaderboardVisibilityHidden = "hidden"
+
+	// LeaderboardAggregationSum adds up the scores of all actions, the only aggregation the scoring pipeline implements
+	LeaderboardAggregationSum = "sum"
+)
+
+// LeaderboardInfo is the registry entry of a leaderboard. Leaderboards that were never registered still work,
+// they are reported with defaults and Registered unset.
+type LeaderboardInfo struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Aggregation string `json:"aggregation"`
+	Visibility  string `json:"visibility"`
+	CreatedAt   int64  `json:"created_at,omitempty"`
+	ClosedAt    int64  `json:"closed_at,omitempty"`
+	Registered  bool   `json:"registered"`
+}
+
+func (l *LeaderboardInfo) Closed() bool {
+	return l.ClosedAt > 0
+}
+
+// Listed is whether the leaderboard is shown in leaderboard listings
+func (l *LeaderboardInfo) Listed() bool {
+	return !l.Closed() && l.Visibility == LeaderboardVisibilityPublic
+}
+
+type LeaderboardInfoRequest struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Aggregation string `json:"aggregation"`
+	Visibility  string `json:"visibility"`
+}
@@ -0,0 +1,19 @@
+package entities
+
+// LeaderboardMembership places the user on a leaderboard besides the primary one from their profile
+type LeaderboardMembership struct {
+	UserId      string `json:"user_id"`
+	Leaderboard int    `json:"leaderboard"`
+	JoinedAt    int64  `json:"joined_at"`
+}
+
+type JoinLeaderboardRequest struct {
+	Leaderboard int `json:"leaderboard"`
+}
+
+// UserLeaderboardStanding is the user's score on one of their leaderboards, nil if they are not ranked there
+type UserLeaderboardStanding struct {
+	Leaderboard int               `json:"leaderboard"`
+	Primary     bool              `json:"primary"`
+	Score       *LeaderboardScore `json:"score"`
+}
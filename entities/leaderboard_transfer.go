@@ -0,0 +1,20 @@
+package entities
+
+// LeaderboardExportRow is a line of a leaderboard export, imports only read user_id and score
+type LeaderboardExportRow struct {
+	Position int    `json:"position"`
+	UserId   string `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Level    int    `json:"level"`
+	Score    int    `json:"score"`
+}
+
+type LeaderboardImportResult struct {
+	Leaderboard int `json:"leaderboard"`
+	Imported    int `json:"imported"`
+	// Unknown counts users without a profile, UnknownUserIds lists the first of them
+	Unknown        int      `json:"unknown"`
+	UnknownUserIds []string `json:"unknown_user_ids"`
+	// Skipped counts banned and shadow-banned users, whose visible scores must not be set
+	Skipped int `json:"skipped"`
+}
@@ -0,0 +1,51 @@
+package entities
+
+const (
+	LeagueOutcomePromoted  = "promoted"
+	LeagueOutcomeRelegated = "relegated"
+	LeagueOutcomeStayed    = "stayed"
+)
+
+// LeagueResult is where a user finished a league cycle and where they were moved for the next one
+type LeagueResult struct {
+	UserId          string `json:"user_id"`
+	Cycle           int    `json:"cycle"`
+	ClosedAt        int64  `json:"closed_at"`
+	FromLeaderboard int    `json:"from_leaderboard"`
+	ToLeaderboard   int    `json:"to_leaderboard"`
+	FromTier        string `json:"from_tier"`
+	ToTier          string `json:"to_tier"`
+	Outcome         string `json:"outcome"`
+	Position        int    `json:"position"`
+	Score           int    `json:"score"`
+}
+
+type UserLeague struct {
+	Tier        string          `json:"tier"`
+	Leaderboard int             `json:"leaderboard"`
+	Cycle       int             `json:"cycle"`
+	CycleEndsAt int64           `json:"cycle_ends_at"`
+	Results     []*LeagueResult `json:"results"`
+}
+
+const (
+	LeagueCycleStatusClosing = "closing"
+	LeagueCycleStatusClosed  = "closed"
+)
+
+// LeagueCycleClose is the progress of a cycle's close, so a failed close resumes where it stopped
+type LeagueCycleClose struct {
+	Cycle     int
+	Status    string
+	ClosingAt int64
+	// Planned is set once the moves of all users are saved, leaderboards are only reset after that
+	Planned           bool
+	ResetLeaderboards []int
+	ClosedAt          int64
+}
+
+// LeagueMove is the result planned for a user at the close of a cycle, Applied once the user was moved and the result saved
+type LeagueMove struct {
+	LeagueResult
+	Applied bool
+}
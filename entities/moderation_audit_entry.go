@@ -0,0 +1,9 @@
+package entities
+
+type ModerationAuditEntry struct {
+	UserId    string `json:"user_id"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason"`
+	Details   string `json:"details"`
+	CreatedAt int64  `json:"created_at"`
+}
@@ -0,0 +1,11 @@
+package entities
+
+type ModerationRequest struct {
+	Reason string `json:"reason"`
+}
+
+type AdjustmentRequest struct {
+	Reason string `json:"reason"`
+	Delta  int    `json:"delta"`
+	Reset  bool   `json:"reset"`
+}
@@ -0,0 +1,21 @@
+package entities
+
+const NotificationTypeOvertaken = "overtaken"
+
+// Notification is pushed to the user's notification stream
+type Notification struct {
+	Type      string `json:"type"`
+	CreatedAt int64  `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+// OvertakenEvent tells the user someone passed them on their leaderboard
+type OvertakenEvent struct {
+	UserId              string `json:"user_id"`
+	Leaderboard         int    `json:"leaderboard"`
+	Position            int    `json:"position"`
+	Score               int    `json:"score"`
+	OvertakenBy         string `json:"overtaken_by"`
+	OvertakenByNickname string `json:"overtaken_by_nickname"`
+	OvertakenByScore    int    `json:"overtaken_by_score"`
+}
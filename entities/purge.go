@@ -0,0 +1,23 @@
+package entities
+
+const (
+	PurgeScopeAll         = "all"
+	PurgeScopeLeaderboard = "leaderboard"
+	PurgeScopeUser        = "user"
+)
+
+type PurgeTarget struct {
+	Scope         string `json:"scope"`
+	LeaderboardId int    `json:"leaderboard_id,omitempty"`
+	UserId        string `json:"user_id,omitempty"`
+}
+
+type PurgeConfirmation struct {
+	ConfirmationToken string      `json:"confirmation_token"`
+	ExpiresAt         int64       `json:"expires_at"`
+	Target            PurgeTarget `json:"target"`
+}
+
+type ConfirmPurgeRequest struct {
+	ConfirmationToken string `json:"confirmation_token"`
+}
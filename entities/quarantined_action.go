@@ -0,0 +1,13 @@
+package entities
+
+type QuarantinedAction struct {
+	UserId          string  `json:"user_id"`
+	QuarantinedAt   int64   `json:"quarantined_at"`
+	Action          string  `json:"action"`
+	ActionTimestamp float64 `json:"action_timestamp"`
+	ReceivedAt      int64   `json:"received_at"`
+	LeaderboardId   int     `json:"leaderboard_id"`
+	Stage           string  `json:"stage"`
+	Rule            string  `json:"rule"`
+	Reason          string  `json:"reason"`
+}
@@ -0,0 +1,27 @@
+package entities
+
+// RankSnapshot is the score and position a user had on a leaderboard when the snapshot was taken
+type RankSnapshot struct {
+	UserId      string `json:"user_id"`
+	Leaderboard int    `json:"leaderboard"`
+	TakenAt     int64  `json:"taken_at"`
+	Score       int    `json:"score"`
+	Position    int    `json:"position"`
+}
+
+type RankHistoryPoint struct {
+	TakenAt  int64 `json:"taken_at"`
+	Score    int   `json:"score"`
+	Position int   `json:"position"`
+}
+
+// RankHistorySeries is the timeline of a user on one leaderboard, oldest point first
+type RankHistorySeries struct {
+	Leaderboard int                 `json:"leaderboard"`
+	Points      []*RankHistoryPoint `json:"points"`
+}
+
+type RankHistory struct {
+	UserId string               `json:"user_id"`
+	Series []*RankHistorySeries `json:"series"`
+}
@@ -0,0 +1,6 @@
+package entities
+
+type SignUpResponse struct {
+	UserProfile
+	Token string `json:"token"`
+}
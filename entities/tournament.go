@@ -0,0 +1,59 @@
+package entities
+
+const (
+	TournamentStatusOpen    = "open"
+	TournamentStatusClosing = "closing"
+	TournamentStatusClosed  = "closed"
+)
+
+// TournamentPrize rewards final positions FromRank to ToRank, both inclusive
+type TournamentPrize struct {
+	FromRank int    `json:"from_rank"`
+	ToRank   int    `json:"to_rank"`
+	RewardId string `json:"reward_id"`
+}
+
+// Tournament is a leaderboard of registered users counting only actions timestamped within [StartsAt, EndsAt)
+type Tournament struct {
+	Id        string             `json:"id"`
+	Name      string             `json:"name"`
+	StartsAt  int64              `json:"starts_at"`
+	EndsAt    int64              `json:"ends_at"`
+	Prizes    []*TournamentPrize `json:"prizes"`
+	Status    string             `json:"status"`
+	ClosingAt int64              `json:"-"`
+	ClosedAt  int64              `json:"closed_at,omitempty"`
+	CreatedAt int64              `json:"created_at"`
+}
+
+type TournamentRequest struct {
+	Name     string             `json:"name"`
+	StartsAt int64              `json:"starts_at"`
+	EndsAt   int64              `json:"ends_at"`
+	Prizes   []*TournamentPrize `json:"prizes"`
+}
+
+// TournamentRegistration is the user's entry to a tournament, the final result is filled in at close
+type TournamentRegistration struct {
+	TournamentId string `json:"tournament_id"`
+	UserId       string `json:"user_id"`
+	RegisteredAt int64  `json:"registered_at"`
+	Position     int    `json:"position,omitempty"`
+	Score        int    `json:"score,omitempty"`
+	RewardId     string `json:"reward_id,omitempty"`
+}
+
+// TournamentStanding is a frozen final position, published as a payout event when it comes with a reward
+type TournamentStanding struct {
+	TournamentId string `json:"tournament_id"`
+	Position     int    `json:"position"`
+	UserId       string `json:"user_id"`
+	Score        int    `json:"score"`
+	RewardId     string `json:"reward_id,omitempty"`
+}
+
+type TournamentLeaderboard struct {
+	Tournament *Tournament             `json:"tournament"`
+	Scores     []*LeaderboardScoreFull `json:"scores"`
+	User       *LeaderboardScoreFull   `json:"user"`
+}
@@ -0,0 +1,29 @@
+package entities
+
+type UserDataExport struct {
+	ExportedAt       int64                      `json:"exported_at"`
+	Profile          *UserProfile               `json:"profile"`
+	Xp               int                        `json:"xp"`
+	LeaderboardScore *LeaderboardScore          `json:"leaderboard_score"`
+	Leaderboards     []*UserLeaderboardStanding `json:"leaderboards"`
+	GlobalScore      *LeaderboardScore          `json:"global_score"`
+	LeagueResults    []*LeagueResult            `json:"league_results"`
+	Friendships      []*Friendship              `json:"friendships"`
+	FriendRequests   []*FriendRequest           `json:"friend_requests"`
+	ClanContribution int                        `json:"clan_contribution"`
+	Tournaments      []*TournamentRegistration  `json:"tournaments"`
+	Achievements     []*UserAchievement         `json:"achievements"`
+	ActionStats      map[string]int             `json:"action_stats"`
+	DailyActionStats []*DailyActionStats        `json:"daily_action_stats"`
+	RankHistory      []*RankHistorySeries       `json:"rank_history"`
+	ActionLog        []*ActionLogEntry          `json:"action_log"`
+	// Moderation is only exported through the backoffice, players must not learn they are being moderated
+	Moderation *UserModerationExport `json:"moderation,omitempty"`
+}
+
+type UserModerationExport struct {
+	Status             string                  `json:"status"`
+	Flagged            bool                    `json:"flagged"`
+	QuarantinedActions []*QuarantinedAction    `json:"quarantined_actions"`
+	ModerationAudit    []*ModerationAuditEntry `json:"moderation_audit"`
+}
@@ -0,0 +1,7 @@
+package entities
+
+type UserLeaderboard struct {
+	Leaderboard int                     `json:"leaderboard"`
+	Scores      []*LeaderboardScoreFull `json:"scores"`
+	User        *LeaderboardScoreFull   `json:"user"`
+}
@@ -1,5 +1,11 @@
 package entities
 
+const (
+	UserStatusActive       = ""
+	UserStatusBanned       = "banned"
+	UserStatusShadowBanned = "shadow_banned"
+)
+
 type UserProfile struct {
 	Id          string `json:"id"`
 	Nickname    string `json:"nickname"`
@@ -7,4 +13,10 @@ type UserProfile struct {
 	Level       int    `json:"level"`
 	Leaderboard int    `json:"leaderboard"`
 	CreatedAt   int64  `json:"createdAt"`
+	// NicknameChangedAt is zero until the first rename
+	NicknameChangedAt int64 `json:"nicknameChangedAt"`
+	// ClanId is empty while the user is not in a clan
+	ClanId string `json:"clanId,omitempty"`
+	// Status is never exposed to players, a shadow-banned user must not be able to tell
+	Status string `json:"-"`
 }
@@ -0,0 +1,72 @@
+package entities
+
+const (
+	WebhookEventLevelUp            = "level_up"
+	WebhookEventEnteredTop10       = "entered_top_10"
+	WebhookEventTournamentFinished = "tournament_finished"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is a partner's URL called for every event of its type, payloads are signed with the secret
+type Webhook struct {
+	Id        string `json:"id"`
+	EventType string `json:"event_type"`
+	Url       string `json:"url"`
+	Secret    string `json:"secret"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// WebhookRequest registers a webhook, a secret is generated when none is given
+type WebhookRequest struct {
+	EventType string `json:"event_type"`
+	Url       string `json:"url"`
+	Secret    string `json:"secret"`
+}
+
+// WebhookPayload is the body posted to webhooks
+type WebhookPayload struct {
+	Id        string `json:"id"`
+	EventType string `json:"event_type"`
+	CreatedAt int64  `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+// WebhookDelivery is the log entry of one payload sent to one webhook, with the outcome of the last attempt
+type WebhookDelivery struct {
+	WebhookId      string `json:"webhook_id"`
+	Id             string `json:"id"`
+	EventType      string `json:"event_type"`
+	Payload        string `json:"payload"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	LastStatusCode int    `json:"last_status_code,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	CreatedAt      int64  `json:"created_at"`
+	UpdatedAt      int64  `json:"updated_at"`
+}
+
+type LevelUpEvent struct {
+	UserId        string `json:"user_id"`
+	PreviousLevel int    `json:"previous_level"`
+	Level         int    `json:"level"`
+	Xp            int    `json:"xp"`
+}
+
+type EnteredTopEvent struct {
+	UserId           string `json:"user_id"`
+	Leaderboard      int    `json:"leaderboard"`
+	PreviousPosition int    `json:"previous_position"`
+	Position         int    `json:"position"`
+	Score            int    `json:"score"`
+}
+
+type TournamentFinishedEvent struct {
+	Tournament   *Tournament           `json:"tournament"`
+	Participants int                   `json:"participants"`
+	Payouts      []*TournamentStanding `json:"payouts"`
+}
@@ -0,0 +1,78 @@
+package game_config
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// gameIdPattern keeps game ids usable in Redis keys, Scylla keyspace names and metric labels
+var gameIdPattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// Game is a title served by the deployment, each has its own config and its own data
+type Game struct {
+	Id     string
+	Config *GameConfig
+	// Default is the game of requests and actions that don't name one. Its data isn't namespaced,
+	// so a single-game deployment keeps its keys and tables when more games are added.
+	Default bool
+}
+
+func NewGames(ac *app_config.AppConfig) []*Game {
+	if len(ac.Games) == 0 {
+		panic("at least one game must be configured")
+	}
+	games := make([]*Game, 0, len(ac.Games))
+	seen := make(map[string]bool, len(ac.Games))
+	for i, id := range ac.Games {
+		if !gameIdPattern.MatchString(id) {
+			panic(fmt.Sprintf("invalid game id %q, only lowercase letters, digits and underscores are allowed", id))
+		}
+		if seen[id] {
+			panic(fmt.Sprintf("game %q is configured twice", id))
+		}
+		seen[id] = true
+		games = append(games, &Game{
+			Id:      id,
+			Config:  loadGameConfig(ac.GameConfigDir, id),
+			Default: i == 0,
+		})
+	}
+	return games
+}
+
+func loadGameConfig(dir string, gameId string) *GameConfig {
+	if dir == "" {
+		return NewGameConfig()
+	}
+	bytes, err := os.ReadFile(filepath.Join(dir, gameId+".json"))
+	if err != nil {
+		panic(fmt.Sprintf("failed to read config of game %q: %s", gameId, err))
+	}
+	gameConfig := &GameConfig{}
+	if err := json.Unmarshal(bytes, gameConfig); err != nil {
+		panic(fmt.Sprintf("failed to parse config of game %q: %s", gameId, err))
+	}
+	return gameConfig
+}
+
+// Keyspace namespaces Scylla tables of the game, the default game keeps the keyspace used before games were introduced
+func (g *Game) Keyspace() string {
+	if g.Default {
+		return "leaderboard"
+	}
+	return "leaderboard_" + g.Id
+}
+
+// MetricName adds the game label to a metric name, which may already have labels
+func (g *Game) MetricName(name string) string {
+	label := fmt.Sprintf("game=%q", g.Id)
+	if base, labels, ok := strings.Cut(name, "{"); ok {
+		return base + "{" + label + "," + labels
+	}
+	return name + "{" + label + "}"
+}
@@ -6,9 +6,121 @@ import (
 )
 
 type GameConfig struct {
-	MaxLeaderboards     int            `json:"max_leaderboards"`
-	ActionsScoreMap     map[string]int `json:"actions_score_map"`
-	XpToLevelThresholds []int          `json:"xp_to_level_thresholds"`
+	MaxLeaderboards     int             `json:"max_leaderboards"`
+	ActionsScoreMap     map[string]int  `json:"actions_score_map"`
+	XpToLevelThresholds []int           `json:"xp_to_level_thresholds"`
+	AntiCheat           AntiCheatConfig `json:"anti_cheat"`
+	Nickname            NicknameConfig  `json:"nickname"`
+	// LeaderboardAssignment decides which leaderboard a new user is placed on
+	LeaderboardAssignment LeaderboardAssignmentConfig `json:"leaderboard_assignment"`
+	Leagues               LeaguesConfig               `json:"leagues"`
+	Clans                 ClansConfig                 `json:"clans"`
+	Achievements          []AchievementConfig         `json:"achievements"`
+}
+
+const (
+	AchievementActionCount = "action_count"
+	AchievementLevel       = "level"
+	AchievementTopPosition = "top_position"
+)
+
+// AchievementConfig declares an achievement, Goal is read according to Type: how many times Action has to be
+// performed, the level to reach, or the position to reach on any leaderboard
+type AchievementConfig struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Action      string `json:"action,omitempty"`
+	Goal        int    `json:"goal"`
+}
+
+type ClansConfig struct {
+	MaxMembers    int `json:"max_members"`
+	NameMinLength int `json:"name_min_length"`
+	NameMaxLength int `json:"name_max_length"`
+	// Aggregation is "sum" to rank clans by the contributions of all members, or "top_k" for the best TopK only
+	Aggregation string `json:"aggregation"`
+	TopK        int    `json:"top_k"`
+}
+
+type LeaguesConfig struct {
+	Enabled    bool `json:"enabled"`
+	CycleHours int  `json:"cycle_hours"`
+	// PromotionCount top users of every leaderboard move a tier up at the end of a cycle
+	PromotionCount int `json:"promotion_count"`
+	// RelegationCount bottom users of every leaderboard move a tier down at the end of a cycle
+	RelegationCount int `json:"relegation_count"`
+	// Tiers are ordered from the lowest to the highest
+	Tiers []LeagueTier `json:"tiers"`
+}
+
+type LeagueTier struct {
+	Name         string `json:"name"`
+	Leaderboards []int  `json:"leaderboards"`
+}
+
+// LeagueTierOf returns the index of the tier the leaderboard belongs to, -1 if it isn't part of any
+func (gc *GameConfig) LeagueTierOf(leaderboard int) int {
+	for i, tier := range gc.Leagues.Tiers {
+		for _, tierLeaderboard := range tier.Leaderboards {
+			if tierLeaderboard == leaderboard {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+type LeaderboardAssignmentConfig struct {
+	// Strategy is one of "random", "least_populated", "capacity_capped", "cohort" or "league_entry"
+	Strategy string `json:"strategy"`
+	// Capacity is the amount of users per leaderboard for the capacity_capped strategy
+	Capacity int `json:"capacity"`
+	// CohortPeriodDays groups users signing up within the same period for the cohort strategy
+	CohortPeriodDays int `json:"cohort_period_days"`
+	// BoardsPerCohort is how many leaderboards users of one cohort are spread over
+	BoardsPerCohort int `json:"boards_per_cohort"`
+}
+
+type AntiCheatConfig struct {
+	// MaxTimestampSkewSeconds is how far an action timestamp may drift from the time the server received it
+	MaxTimestampSkewSeconds float64 `json:"max_timestamp_skew_seconds"`
+	// MaxActionFrequency limits how many times a single user may perform an action within a window
+	MaxActionFrequency map[string]ActionFrequencyRule `json:"max_action_frequency"`
+	// RequiredPrecedingActions declares actions that are only possible shortly after another action
+	RequiredPrecedingActions map[string]PrecedingActionRule `json:"required_preceding_actions"`
+}
+
+type NicknameConfig struct {
+	MinLength           int `json:"min_length"`
+	MaxLength           int `json:"max_length"`
+	ChangeCooldownHours int `json:"change_cooldown_hours"`
+	// FilterMode is either "reject", refusing offensive nicknames, or "mask", accepting them but masking on display.
+	// Nicknames on leaderboards are masked in both modes, so words added to the list later are hidden too.
+	FilterMode   string   `json:"filter_mode"`
+	BlockedWords []string `json:"blocked_words"`
+}
+
+type ActionFrequencyRule struct {
+	MaxCount      int     `json:"max_count"`
+	WindowSeconds float64 `json:"window_seconds"`
+}
+
+type PrecedingActionRule struct {
+	Action        string  `json:"action"`
+	WithinSeconds float64 `json:"within_seconds"`
+}
+
+// LevelForXp returns the level reached with the given amount of xp
+func (gc *GameConfig) LevelForXp(xp int) int {
+	level := 0
+	for i, threshold := range gc.XpToLevelThresholds {
+		if xp >= threshold {
+			level = i + 1
+		}
+	}
+	return level
 }
 
 //go:embed game_config.json
@@ -0,0 +1,13 @@
+package game_config
+
+import "testing"
+
+func TestGameKeyspace(t *testing.T) {
+	// the default game keeps the keyspace of the single game deployments, other games get their own
+	if got := (&Game{Id: "alpha", Default: true}).Keyspace(); got != "leaderboard" {
+		t.Fatalf("default game keyspace %q, want %q", got, "leaderboard")
+	}
+	if got := (&Game{Id: "beta"}).Keyspace(); got != "leaderboard_beta" {
+		t.Fatalf("game keyspace %q, want %q", got, "leaderboard_beta")
+	}
+}
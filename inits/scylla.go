@@ -0,0 +1,42 @@
+package inits
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/graceful_shutdown"
+)
+
+// NewScyllaSession opens a session on the game's keyspace, creating it if needed
+func NewScyllaSession(ac *app_config.AppConfig, game *game_config.Game) *gocqlx.Session {
+	// DDL session — minimal config, no keyspace
+	ddlCluster := gocql.NewCluster(ac.ScyllaUrl)
+	ddlSession, err := gocqlx.WrapSession(ddlCluster.CreateSession())
+	if err != nil {
+		panic(err)
+	}
+
+	err = ddlSession.Query("CREATE KEYSPACE IF NOT EXISTS "+game.Keyspace()+" WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}", nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	ddlSession.Close()
+
+	// Main session — tuned for production queries
+	cluster := gocql.NewCluster(ac.ScyllaUrl)
+	cluster.Keyspace = game.Keyspace()
+	cluster.NumConns = ac.ScyllaNumConns
+	cluster.Consistency = gocql.Quorum
+	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(
+		gocql.RoundRobinHostPolicy(),
+	)
+	session, err := gocqlx.WrapSession(cluster.CreateSession())
+	if err != nil {
+		panic(err)
+	}
+	graceful_shutdown.AddOutputShutdownFunc(func() {
+		session.Close()
+	})
+	return &session
+}
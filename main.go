@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/auth"
 	"github.com/skif48/leaderboard-engine/game_config"
 	"github.com/skif48/leaderboard-engine/graceful_shutdown"
 	"github.com/skif48/leaderboard-engine/inits"
@@ -11,27 +12,27 @@ import (
 	"github.com/skif48/leaderboard-engine/servers"
 	"github.com/skif48/leaderboard-engine/services"
 	"go.uber.org/fx"
-	"log/slog"
 )
 
 func main() {
-	var loggerInstance *slog.Logger
+	// config, logger and games are set up before the app, which is assembled from one module per game
+	ac := app_config.NewAppConfig()
+	logger.InitLogger(ac)
+	games := game_config.NewGames(ac)
 
-	app := fx.New(
+	options := []fx.Option{
+		fx.Supply(ac, games),
 		fx.Provide(
-			app_config.NewAppConfig,
-			logger.InitLogger,
 			inits.NewRedisClient,
-			repositories.NewUserProfileRepository,
-			repositories.NewLeaderboardRepo,
-			repositories.NewUserXpRepository,
-			services.NewGameActionsService,
-			services.NewLeaderboardService,
-			game_config.NewGameConfig,
+			servers.NewGameRouter,
 		),
-		fx.Populate(&loggerInstance),
-		fx.Invoke(servers.RunHttpServer, servers.RunKafkaConsumer),
-	)
+	}
+	for _, game := range games {
+		options = append(options, gameModule(game))
+	}
+	options = append(options, fx.Invoke(servers.RunHttpServer, servers.RunKafkaConsumer))
+
+	app := fx.New(options...)
 
 	if err := app.Err(); err != nil {
 		panic(err)
@@ -43,3 +44,69 @@ func main() {
 
 	graceful_shutdown.WaitForSignals()
 }
+
+// gameModule holds the storage, services, routes and jobs of one game, they are private to the module
+// so every game gets its own instances. Only the Redis client and the HTTP server are shared.
+func gameModule(game *game_config.Game) fx.Option {
+	return fx.Module("game_"+game.Id,
+		fx.Supply(fx.Private, game, game.Config),
+		fx.Provide(
+			fx.Private,
+			inits.NewScyllaSession,
+			repositories.NewUserProfileRepository,
+			repositories.NewLeaderboardRepo,
+			repositories.NewUserXpRepository,
+			repositories.NewRateLimiterRepository,
+			repositories.NewNotificationRepository,
+			repositories.NewAntiCheatRepository,
+			repositories.NewQuarantineRepository,
+			repositories.NewModerationAuditRepository,
+			repositories.NewPurgeConfirmationRepository,
+			repositories.NewNicknameRepository,
+			repositories.NewNicknameIndexRepository,
+			repositories.NewLeaderboardAssignmentRepository,
+			repositories.NewLeagueResultRepository,
+			repositories.NewLeagueCycleRepository,
+			repositories.NewLeagueCloseRepository,
+			repositories.NewLeaderboardMembershipRepository,
+			repositories.NewLeaderboardRegistryRepository,
+			repositories.NewFriendshipRepository,
+			repositories.NewClanRepository,
+			repositories.NewClanScoreRepository,
+			repositories.NewTournamentRepository,
+			repositories.NewWebhookRepository,
+			repositories.NewActionCounterRepository,
+			repositories.NewAchievementRepository,
+			repositories.NewActionStatsRepository,
+			repositories.NewRankHistoryRepository,
+			repositories.NewActionLogRepository,
+			services.NewNicknameFilter,
+			services.NewNicknameService,
+			services.NewAntiCheatService,
+			services.NewGameActionsService,
+			services.NewNotificationService,
+			services.NewLeaderboardService,
+			services.NewLeaderboardAssignmentStrategy,
+			services.NewModerationService,
+			services.NewPurgeService,
+			services.NewUserDataService,
+			services.NewEventPublisher,
+			services.NewLeagueService,
+			services.NewLeaderboardMembershipService,
+			services.NewLeaderboardRegistryService,
+			services.NewGlobalRankService,
+			services.NewFriendsService,
+			services.NewClanService,
+			services.NewTournamentService,
+			services.NewWebhookClient,
+			services.NewWebhookService,
+			services.NewAchievementService,
+			services.NewActionStatsService,
+			services.NewRankHistoryService,
+			services.NewActionLogService,
+			services.NewLeaderboardTransferService,
+			auth.NewTokenService,
+		),
+		fx.Invoke(servers.RegisterGameRoutes, servers.RunLeagueScheduler, servers.RunTournamentScheduler, servers.RunActionStatsFlusher, servers.RunRankSnapshotter),
+	)
+}
@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+type AchievementRepository interface {
+	// Unlock stores the achievement unless the user already has it, it reports whether it was stored
+	Unlock(achievement *entities.UserAchievement) (bool, error)
+	GetUserAchievements(userId string) ([]*entities.UserAchievement, error)
+	DeleteUserAchievements(userId string) error
+	Purge() error
+}
+
+type AchievementRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewAchievementRepository(session *gocqlx.Session, game *game_config.Game) AchievementRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS user_achievement (
+    	user_id uuid,
+    	achievement_id text,
+    	unlocked_at timestamp,
+    	PRIMARY KEY (user_id, achievement_id))`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &AchievementRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (a *AchievementRepositoryScylla) Unlock(achievement *entities.UserAchievement) (bool, error) {
+	defer trackScyllaLatency(a.game, "unlock_achievement")()
+	var existingUserId gocql.UUID
+	var existingAchievementId string
+	var existingUnlockedAt time.Time
+	return a.scyllaClient.Query(`INSERT INTO user_achievement (user_id,achievement_id,unlocked_at) VALUES (?,?,?) IF NOT EXISTS`, nil).
+		Bind(achievement.UserId, achievement.AchievementId, time.UnixMilli(achievement.UnlockedAt)).
+		ScanCAS(&existingUserId, &existingAchievementId, &existingUnlockedAt)
+}
+
+func (a *AchievementRepositoryScylla) GetUserAchievements(userId string) ([]*entities.UserAchievement, error) {
+	defer trackScyllaLatency(a.game, "get_user_achievements")()
+	var achievements []*entities.UserAchievement
+	query := a.scyllaClient.Query(`SELECT user_id,achievement_id,unlocked_at FROM user_achievement WHERE user_id = ?`, nil).Bind(userId)
+	if err := query.SelectRelease(&achievements); err != nil {
+		return nil, err
+	}
+	return achievements, nil
+}
+
+func (a *AchievementRepositoryScylla) DeleteUserAchievements(userId string) error {
+	defer trackScyllaLatency(a.game, "delete_user_achievements")()
+	return a.scyllaClient.Query(`DELETE FROM user_achievement WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
+
+func (a *AchievementRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(a.game, "purge_achievements")()
+	return a.scyllaClient.Query(`TRUNCATE user_achievement`, nil).Exec()
+}
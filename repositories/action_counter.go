@@ -0,0 +1,186 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"strings"
+)
+
+// actionCounterClaimScript moves the pending counters (KEYS[1]) of a user to the inflight hash (KEYS[2]) and returns
+// them, so increments racing with a flush land in the next one. Counters still inflight from a flush that
+// wasn't confirmed are returned instead, the pending ones wait for the next flush.
+var actionCounterClaimScript = rueidis.NewLuaScript(`
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	if redis.call('EXISTS', KEYS[1]) == 0 then
+		return {}
+	end
+	redis.call('RENAME', KEYS[1], KEYS[2])
+end
+return redis.call('HGETALL', KEYS[2])
+`)
+
+// ActionCounterRepository counts how many times every user performed every action. All-time counts live in a hash
+// per user, increments are also collected per day in a pending hash until they are flushed to durable storage.
+// A flush claims the pending counters into an inflight hash, which is only deleted once they are stored, so a flush
+// that fails or never finishes leaves them to be flushed again. Pending counters are keyed by day, then action.
+type ActionCounterRepository interface {
+	Increment(userId string, action string, day string) (int, error)
+	GetCounters(userId string) (map[string]int, error)
+	// GetPending returns the counters not stored yet, inflight ones included
+	GetPending(userId string) (map[string]map[string]int, error)
+	// PopDirtyUsers returns up to count users with pending counters, each user is handed out to a single caller
+	PopDirtyUsers(count int) ([]string, error)
+	// MarkDirty hands the users out again, for counters a flush left behind
+	MarkDirty(userIds ...string) error
+	// ClaimPending returns the counters to flush for the user, they stay inflight until confirmed
+	ClaimPending(userId string) (map[string]map[string]int, error)
+	// ConfirmPending deletes the inflight counters once they are stored and tells whether more are pending
+	ConfirmPending(userId string) (bool, error)
+	DeleteCounters(userId string) error
+	Purge() error
+}
+
+type actionCounterRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewActionCounterRepository(c rueidis.Client, game *game_config.Game) ActionCounterRepository {
+	return &actionCounterRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+// dirtyKey holds users with pending counters
+func (a *actionCounterRepositoryRedis) dirtyKey() string {
+	return a.ns + "action_counter:dirty"
+}
+
+func (a *actionCounterRepositoryRedis) key(userId string) string {
+	return a.ns + fmt.Sprintf("user:{%s}:actions", userId)
+}
+
+func (a *actionCounterRepositoryRedis) pendingKey(userId string) string {
+	return a.ns + fmt.Sprintf("user:{%s}:actions_pending", userId)
+}
+
+func (a *actionCounterRepositoryRedis) inflightKey(userId string) string {
+	return a.ns + fmt.Sprintf("user:{%s}:actions_inflight", userId)
+}
+
+func (a *actionCounterRepositoryRedis) pendingField(day string, action string) string {
+	return day + "|" + action
+}
+
+func (a *actionCounterRepositoryRedis) Increment(userId string, action string, day string) (int, error) {
+	res := a.c.DoMulti(
+		context.Background(),
+		a.c.B().Hincrby().Key(a.key(userId)).Field(action).Increment(1).Build(),
+		a.c.B().Hincrby().Key(a.pendingKey(userId)).Field(a.pendingField(day, action)).Increment(1).Build(),
+		a.c.B().Sadd().Key(a.dirtyKey()).Member(userId).Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return 0, r.Error()
+		}
+	}
+	count, err := res[0].AsInt64()
+	return int(count), err
+}
+
+func (a *actionCounterRepositoryRedis) GetCounters(userId string) (map[string]int, error) {
+	counters, err := a.c.Do(context.Background(), a.c.B().Hgetall().Key(a.key(userId)).Build()).AsIntMap()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(counters))
+	for action, count := range counters {
+		result[action] = int(count)
+	}
+	return result, nil
+}
+
+func (a *actionCounterRepositoryRedis) GetPending(userId string) (map[string]map[string]int, error) {
+	res := a.c.DoMulti(
+		context.Background(),
+		a.c.B().Hgetall().Key(a.pendingKey(userId)).Build(),
+		a.c.B().Hgetall().Key(a.inflightKey(userId)).Build(),
+	)
+	result := make(map[string]map[string]int)
+	for _, r := range res {
+		pending, err := r.AsIntMap()
+		if err != nil {
+			return nil, err
+		}
+		a.parsePending(result, pending)
+	}
+	return result, nil
+}
+
+// parsePending adds the counters of a pending hash to the result
+func (a *actionCounterRepositoryRedis) parsePending(result map[string]map[string]int, pending map[string]int64) {
+	for field, count := range pending {
+		day, action, ok := strings.Cut(field, "|")
+		if !ok {
+			continue
+		}
+		if result[day] == nil {
+			result[day] = make(map[string]int)
+		}
+		result[day][action] += int(count)
+	}
+}
+
+func (a *actionCounterRepositoryRedis) PopDirtyUsers(count int) ([]string, error) {
+	return a.c.Do(context.Background(), a.c.B().Spop().Key(a.dirtyKey()).Count(int64(count)).Build()).AsStrSlice()
+}
+
+func (a *actionCounterRepositoryRedis) MarkDirty(userIds ...string) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+	return a.c.Do(context.Background(), a.c.B().Sadd().Key(a.dirtyKey()).Member(userIds...).Build()).Error()
+}
+
+func (a *actionCounterRepositoryRedis) ClaimPending(userId string) (map[string]map[string]int, error) {
+	pending, err := actionCounterClaimScript.Exec(context.Background(), a.c, []string{a.pendingKey(userId), a.inflightKey(userId)}, nil).AsIntMap()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]map[string]int)
+	a.parsePending(result, pending)
+	return result, nil
+}
+
+func (a *actionCounterRepositoryRedis) ConfirmPending(userId string) (bool, error) {
+	res := a.c.DoMulti(
+		context.Background(),
+		a.c.B().Del().Key(a.inflightKey(userId)).Build(),
+		a.c.B().Exists().Key(a.pendingKey(userId)).Build(),
+	)
+	if err := res[0].Error(); err != nil {
+		return false, err
+	}
+	pending, err := res[1].AsInt64()
+	return pending > 0, err
+}
+
+func (a *actionCounterRepositoryRedis) DeleteCounters(userId string) error {
+	if err := deleteKeys(a.c, []string{a.key(userId), a.pendingKey(userId), a.inflightKey(userId)}); err != nil {
+		return err
+	}
+	return a.c.Do(context.Background(), a.c.B().Srem().Key(a.dirtyKey()).Member(userId).Build()).Error()
+}
+
+func (a *actionCounterRepositoryRedis) Purge() error {
+	if err := deleteKeysByPattern(a.c, a.ns+"user:*:actions"); err != nil {
+		return err
+	}
+	if err := deleteKeysByPattern(a.c, a.ns+"user:*:actions_pending"); err != nil {
+		return err
+	}
+	if err := deleteKeysByPattern(a.c, a.ns+"user:*:actions_inflight"); err != nil {
+		return err
+	}
+	return deleteKeys(a.c, []string{a.dirtyKey()})
+}
@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"slices"
+	"time"
+)
+
+// ActionLogRepository keeps the log of processed game actions, partitioned by user and the UTC day ("2006-01-02")
+// the action was processed on. Entry ids are time uuids of the processing time, entries expire after the retention.
+type ActionLogRepository interface {
+	Save(entries []*entities.ActionLogEntry, retention time.Duration) error
+	// GetUserLog returns up to limit entries of the day, latest first, only those older than the entry before
+	// if it is given
+	GetUserLog(userId string, day string, before string, limit int) ([]*entities.ActionLogEntry, error)
+	// DeleteUserLog removes the user's log of the given days, any number of them
+	DeleteUserLog(userId string, days []string) error
+	Purge() error
+}
+
+type ActionLogRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewActionLogRepository(session *gocqlx.Session, game *game_config.Game) ActionLogRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS action_log (
+    	user_id uuid,
+    	day text,
+    	id timeuuid,
+    	action text,
+    	leaderboard_id int,
+    	timestamp double,
+    	received_at timestamp,
+    	processed_at timestamp,
+    	outcome text,
+    	error text,
+    	score int,
+    	xp_delta int,
+    	level int,
+    	processing_time_us bigint,
+    	PRIMARY KEY ((user_id, day), id))
+    	WITH CLUSTERING ORDER BY (id DESC)`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &ActionLogRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func actionLogDay(processedAt int64) string {
+	return time.UnixMilli(processedAt).UTC().Format("2006-01-02")
+}
+
+// Save writes an unlogged batch per partition, entries of different users have nothing to gain from sharing one
+func (a *ActionLogRepositoryScylla) Save(entries []*entities.ActionLogEntry, retention time.Duration) error {
+	defer trackScyllaLatency(a.game, "save_action_log")()
+	type partition struct {
+		userId string
+		day    string
+	}
+	batches := make(map[partition]*gocql.Batch)
+	for _, entry := range entries {
+		key := partition{userId: entry.UserId, day: actionLogDay(entry.ProcessedAt)}
+		batch, ok := batches[key]
+		if !ok {
+			batch = a.scyllaClient.Session.NewBatch(gocql.UnloggedBatch)
+			batches[key] = batch
+		}
+		batch.Query(
+			`INSERT INTO action_log (user_id,day,id,action,leaderboard_id,timestamp,received_at,processed_at,outcome,error,score,xp_delta,level,processing_time_us) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?) USING TTL ?`,
+			entry.UserId,
+			key.day,
+			entry.Id,
+			entry.Action,
+			entry.LeaderboardId,
+			entry.Timestamp,
+			time.UnixMilli(entry.ReceivedAt),
+			time.UnixMilli(entry.ProcessedAt),
+			entry.Outcome,
+			entry.Error,
+			entry.Score,
+			entry.XpDelta,
+			entry.Level,
+			entry.ProcessingTimeUs,
+			int(retention.Seconds()),
+		)
+	}
+	for _, batch := range batches {
+		if err := a.scyllaClient.Session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const actionLogColumns = `user_id,id,action,leaderboard_id,timestamp,received_at,processed_at,outcome,error,score,xp_delta,level,processing_time_us`
+
+func (a *ActionLogRepositoryScylla) GetUserLog(userId string, day string, before string, limit int) ([]*entities.ActionLogEntry, error) {
+	defer trackScyllaLatency(a.game, "get_user_action_log")()
+	var entries []*entities.ActionLogEntry
+	var query *gocqlx.Queryx
+	if before == "" {
+		query = a.scyllaClient.Query(`SELECT `+actionLogColumns+` FROM action_log WHERE user_id = ? AND day = ? LIMIT ?`, nil).
+			Bind(userId, day, limit)
+	} else {
+		query = a.scyllaClient.Query(`SELECT `+actionLogColumns+` FROM action_log WHERE user_id = ? AND day = ? AND id < ? LIMIT ?`, nil).
+			Bind(userId, day, before, limit)
+	}
+	if err := query.SelectRelease(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (a *ActionLogRepositoryScylla) DeleteUserLog(userId string, days []string) error {
+	defer trackScyllaLatency(a.game, "delete_user_action_log")()
+	query := a.scyllaClient.Query(`DELETE FROM action_log WHERE user_id = ? AND day IN ?`, nil)
+	defer query.Release()
+	// every day is a partition, a retention of more than RankHistoryMaxPartitionsPerQuery days takes several deletes
+	for chunk := range slices.Chunk(days, RankHistoryMaxPartitionsPerQuery) {
+		if err := query.Bind(userId, chunk).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *ActionLogRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(a.game, "purge_action_log")()
+	return a.scyllaClient.Query(`TRUNCATE action_log`, nil).Exec()
+}
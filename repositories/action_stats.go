@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/game_config"
+)
+
+// ActionStatsRepository stores the per-user action counters flushed from Redis, days are "2006-01-02" in UTC.
+// Counters are incremented, so a delta must be added exactly once.
+type ActionStatsRepository interface {
+	// Add increments the user's counters by the deltas, keyed by day, then action
+	Add(userId string, deltas map[string]map[string]int) error
+	GetTotals(userId string) (map[string]int, error)
+	// GetDaily returns the counters of the days within [from, to], keyed by day, then action
+	GetDaily(userId string, from string, to string) (map[string]map[string]int, error)
+	// GetAllDaily returns the counters of every day, keyed by day, then action
+	GetAllDaily(userId string) (map[string]map[string]int, error)
+	DeleteUserStats(userId string) error
+	Purge() error
+}
+
+type ActionStatsRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewActionStatsRepository(session *gocqlx.Session, game *game_config.Game) ActionStatsRepository {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS user_action_total (
+    	user_id uuid,
+    	action text,
+    	count counter,
+    	PRIMARY KEY (user_id, action))`,
+		`CREATE TABLE IF NOT EXISTS user_action_daily (
+    	user_id uuid,
+    	day text,
+    	action text,
+    	count counter,
+    	PRIMARY KEY (user_id, day, action))`,
+	}
+	for _, query := range queries {
+		if err := session.Query(query, nil).Exec(); err != nil {
+			panic(err)
+		}
+	}
+	return &ActionStatsRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (a *ActionStatsRepositoryScylla) Add(userId string, deltas map[string]map[string]int) error {
+	defer trackScyllaLatency(a.game, "add_action_stats")()
+	totals := make(map[string]int)
+	batch := a.scyllaClient.Session.NewBatch(gocql.CounterBatch)
+	for day, counters := range deltas {
+		for action, count := range counters {
+			batch.Query(`UPDATE user_action_daily SET count = count + ? WHERE user_id = ? AND day = ? AND action = ?`, int64(count), userId, day, action)
+			totals[action] += count
+		}
+	}
+	for action, count := range totals {
+		batch.Query(`UPDATE user_action_total SET count = count + ? WHERE user_id = ? AND action = ?`, int64(count), userId, action)
+	}
+	if batch.Size() == 0 {
+		return nil
+	}
+	return a.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+type actionStatsRow struct {
+	Day    string
+	Action string
+	Count  int64
+}
+
+func (a *ActionStatsRepositoryScylla) GetTotals(userId string) (map[string]int, error) {
+	defer trackScyllaLatency(a.game, "get_action_totals")()
+	var rows []*actionStatsRow
+	query := a.scyllaClient.Query(`SELECT action,count FROM user_action_total WHERE user_id = ?`, nil).Bind(userId)
+	if err := query.SelectRelease(&rows); err != nil {
+		return nil, err
+	}
+	totals := make(map[string]int, len(rows))
+	for _, row := range rows {
+		totals[row.Action] = int(row.Count)
+	}
+	return totals, nil
+}
+
+func (a *ActionStatsRepositoryScylla) GetDaily(userId string, from string, to string) (map[string]map[string]int, error) {
+	defer trackScyllaLatency(a.game, "get_action_daily")()
+	var rows []*actionStatsRow
+	query := a.scyllaClient.Query(`SELECT day,action,count FROM user_action_daily WHERE user_id = ? AND day >= ? AND day <= ?`, nil).
+		Bind(userId, from, to)
+	if err := query.SelectRelease(&rows); err != nil {
+		return nil, err
+	}
+	return dailyActionStats(rows), nil
+}
+
+func (a *ActionStatsRepositoryScylla) GetAllDaily(userId string) (map[string]map[string]int, error) {
+	defer trackScyllaLatency(a.game, "get_all_action_daily")()
+	var rows []*actionStatsRow
+	query := a.scyllaClient.Query(`SELECT day,action,count FROM user_action_daily WHERE user_id = ?`, nil).Bind(userId)
+	if err := query.SelectRelease(&rows); err != nil {
+		return nil, err
+	}
+	return dailyActionStats(rows), nil
+}
+
+func dailyActionStats(rows []*actionStatsRow) map[string]map[string]int {
+	daily := make(map[string]map[string]int)
+	for _, row := range rows {
+		if daily[row.Day] == nil {
+			daily[row.Day] = make(map[string]int)
+		}
+		daily[row.Day][row.Action] = int(row.Count)
+	}
+	return daily
+}
+
+func (a *ActionStatsRepositoryScylla) DeleteUserStats(userId string) error {
+	defer trackScyllaLatency(a.game, "delete_user_action_stats")()
+	if err := a.scyllaClient.Query(`DELETE FROM user_action_daily WHERE user_id = ?`, nil).Bind(userId).ExecRelease(); err != nil {
+		return err
+	}
+	return a.scyllaClient.Query(`DELETE FROM user_action_total WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
+
+func (a *ActionStatsRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(a.game, "purge_action_stats")()
+	if err := a.scyllaClient.Query(`TRUNCATE user_action_daily`, nil).Exec(); err != nil {
+		return err
+	}
+	return a.scyllaClient.Query(`TRUNCATE user_action_total`, nil).Exec()
+}
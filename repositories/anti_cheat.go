@@ -0,0 +1,149 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+const lastActionsTtl = 24 * time.Hour
+
+type AntiCheatRepository interface {
+	// CountAction records the action and returns how many times the user performed it within the window, including this one.
+	CountAction(stage string, userId string, action string, at time.Time, window time.Duration) (int, error)
+	GetLastActionTimestamp(stage string, userId string, action string) (float64, bool, error)
+	SetLastActionTimestamp(stage string, userId string, action string, timestamp float64) error
+	FlagUser(userId string) error
+	UnflagUser(userId string) error
+	GetFlaggedUsers() ([]*entities.FlaggedUser, error)
+	IsFlagged(userId string) (bool, error)
+	DeleteUserState(userId string, stages []string, actions []string) error
+}
+
+type antiCheatRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewAntiCheatRepository(c rueidis.Client, game *game_config.Game) AntiCheatRepository {
+	return &antiCheatRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (a *antiCheatRepositoryRedis) frequencyKey(stage string, userId string, action string) string {
+	return a.ns + fmt.Sprintf("anti_cheat:{%s}:%s:%s:frequency", userId, stage, action)
+}
+
+func (a *antiCheatRepositoryRedis) lastActionsKey(stage string, userId string) string {
+	return a.ns + fmt.Sprintf("anti_cheat:{%s}:%s:last_actions", userId, stage)
+}
+
+func (a *antiCheatRepositoryRedis) flaggedKey() string {
+	return a.ns + "anti_cheat:flagged"
+}
+
+func (a *antiCheatRepositoryRedis) CountAction(stage string, userId string, action string, at time.Time, window time.Duration) (int, error) {
+	key := a.frequencyKey(stage, userId, action)
+	member := strconv.FormatInt(at.UnixNano(), 10) + "-" + strconv.Itoa(rand.IntN(1_000_000))
+	res := a.c.DoMulti(
+		context.Background(),
+		a.c.B().Multi().Build(),
+		a.c.B().Zremrangebyscore().Key(key).Min("-inf").Max(strconv.FormatInt(at.Add(-window).UnixMilli(), 10)).Build(),
+		a.c.B().Zadd().Key(key).ScoreMember().ScoreMember(float64(at.UnixMilli()), member).Build(),
+		a.c.B().Zcard().Key(key).Build(),
+		a.c.B().Pexpire().Key(key).Milliseconds(window.Milliseconds()).Build(),
+		a.c.B().Exec().Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return 0, r.Error()
+		}
+	}
+	execResults, err := res[5].ToArray()
+	if err != nil {
+		return 0, err
+	}
+	if len(execResults) < 3 {
+		return 0, fmt.Errorf("unexpected number of results from transaction")
+	}
+	count, err := execResults[2].AsInt64()
+	return int(count), err
+}
+
+func (a *antiCheatRepositoryRedis) GetLastActionTimestamp(stage string, userId string, action string) (float64, bool, error) {
+	timestamp, err := a.c.Do(context.Background(), a.c.B().Hget().Key(a.lastActionsKey(stage, userId)).Field(action).Build()).AsFloat64()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return timestamp, true, nil
+}
+
+func (a *antiCheatRepositoryRedis) SetLastActionTimestamp(stage string, userId string, action string, timestamp float64) error {
+	key := a.lastActionsKey(stage, userId)
+	res := a.c.DoMulti(
+		context.Background(),
+		a.c.B().Hset().Key(key).FieldValue().FieldValue(action, strconv.FormatFloat(timestamp, 'f', -1, 64)).Build(),
+		a.c.B().Expire().Key(key).Seconds(int64(lastActionsTtl.Seconds())).Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+	return nil
+}
+
+func (a *antiCheatRepositoryRedis) FlagUser(userId string) error {
+	return a.c.Do(context.Background(), a.c.B().Zincrby().Key(a.flaggedKey()).Increment(1).Member(userId).Build()).Error()
+}
+
+func (a *antiCheatRepositoryRedis) UnflagUser(userId string) error {
+	return a.c.Do(context.Background(), a.c.B().Zrem().Key(a.flaggedKey()).Member(userId).Build()).Error()
+}
+
+func (a *antiCheatRepositoryRedis) GetFlaggedUsers() ([]*entities.FlaggedUser, error) {
+	scores, err := a.c.Do(context.Background(), a.c.B().Zrange().Key(a.flaggedKey()).Min("0").Max("-1").Rev().Withscores().Build()).AsZScores()
+	if err != nil {
+		return nil, err
+	}
+	flaggedUsers := make([]*entities.FlaggedUser, 0, len(scores))
+	for _, score := range scores {
+		flaggedUsers = append(flaggedUsers, &entities.FlaggedUser{
+			UserId:     score.Member,
+			Violations: int(score.Score),
+		})
+	}
+	return flaggedUsers, nil
+}
+
+func (a *antiCheatRepositoryRedis) IsFlagged(userId string) (bool, error) {
+	err := a.c.Do(context.Background(), a.c.B().Zscore().Key(a.flaggedKey()).Member(userId).Build()).Error()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *antiCheatRepositoryRedis) DeleteUserState(userId string, stages []string, actions []string) error {
+	keys := make([]string, 0, len(stages)*(len(actions)+1))
+	for _, stage := range stages {
+		keys = append(keys, a.lastActionsKey(stage, userId))
+		for _, action := range actions {
+			keys = append(keys, a.frequencyKey(stage, userId, action))
+		}
+	}
+	if err := deleteKeys(a.c, keys); err != nil {
+		return err
+	}
+	return a.UnflagUser(userId)
+}
@@ -0,0 +1,154 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/qb"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+type ClanRepository interface {
+	// ClaimName reserves the normalized clan name, false if another clan has it
+	ClaimName(name string, clanId string) (bool, error)
+	ReleaseName(name string, clanId string) error
+	Create(clan *entities.Clan) error
+	Get(clanId string) (*entities.Clan, error)
+	GetMany(clanIds []string) ([]*entities.Clan, error)
+	Delete(clanId string) error
+	AddMember(clanId string, userId string) error
+	RemoveMember(clanId string, userId string) error
+	GetMembers(clanId string) ([]*entities.ClanMember, error)
+	Purge() error
+}
+
+type ClanRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewClanRepository(session *gocqlx.Session, game *game_config.Game) ClanRepository {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS clan (
+    	id uuid,
+    	name text,
+    	created_by uuid,
+    	created_at timestamp,
+    	PRIMARY KEY (id))`,
+		`CREATE TABLE IF NOT EXISTS clan_name (
+    	name text,
+    	clan_id uuid,
+    	PRIMARY KEY (name))`,
+		`CREATE TABLE IF NOT EXISTS clan_member (
+    	clan_id uuid,
+    	user_id uuid,
+    	joined_at timestamp,
+    	PRIMARY KEY (clan_id, user_id))`,
+	}
+	for _, query := range queries {
+		if err := session.Query(query, nil).Exec(); err != nil {
+			panic(err)
+		}
+	}
+	return &ClanRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (c *ClanRepositoryScylla) ClaimName(name string, clanId string) (bool, error) {
+	defer trackScyllaLatency(c.game, "claim_clan_name")()
+	var existingName string
+	var existingClanId gocql.UUID
+	return c.scyllaClient.Query(`INSERT INTO clan_name (name, clan_id) VALUES (?, ?) IF NOT EXISTS`, nil).
+		Bind(name, clanId).
+		ScanCAS(&existingName, &existingClanId)
+}
+
+func (c *ClanRepositoryScylla) ReleaseName(name string, clanId string) error {
+	defer trackScyllaLatency(c.game, "release_clan_name")()
+	var existingClanId gocql.UUID
+	_, err := c.scyllaClient.Query(`DELETE FROM clan_name WHERE name = ? IF clan_id = ?`, nil).
+		Bind(name, clanId).
+		ScanCAS(&existingClanId)
+	return err
+}
+
+func (c *ClanRepositoryScylla) Create(clan *entities.Clan) error {
+	defer trackScyllaLatency(c.game, "create_clan")()
+	return c.scyllaClient.Query(`INSERT INTO clan (id,name,created_by,created_at) VALUES (?,?,?,?)`, nil).
+		Bind(clan.Id, clan.Name, clan.CreatedBy, time.UnixMilli(clan.CreatedAt)).
+		ExecRelease()
+}
+
+func (c *ClanRepositoryScylla) Get(clanId string) (*entities.Clan, error) {
+	defer trackScyllaLatency(c.game, "get_clan")()
+	clan := &entities.Clan{}
+	if err := c.scyllaClient.Query(`SELECT id,name,created_by,created_at FROM clan WHERE id = ?`, nil).Bind(clanId).Get(clan); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return clan, nil
+}
+
+func (c *ClanRepositoryScylla) GetMany(clanIds []string) ([]*entities.Clan, error) {
+	defer trackScyllaLatency(c.game, "get_many_clans")()
+	var clans []*entities.Clan
+	if len(clanIds) == 0 {
+		return clans, nil
+	}
+	uuids := make([]gocql.UUID, 0, len(clanIds))
+	for _, clanId := range clanIds {
+		uuid, err := gocql.ParseUUID(clanId)
+		if err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, uuid)
+	}
+	stmt, names := qb.Select("clan").Columns("id", "name", "created_by", "created_at").Where(qb.In("id")).ToCql()
+	if err := c.scyllaClient.Query(stmt, names).BindMap(qb.M{"id": uuids}).SelectRelease(&clans); err != nil {
+		return nil, err
+	}
+	return clans, nil
+}
+
+func (c *ClanRepositoryScylla) Delete(clanId string) error {
+	defer trackScyllaLatency(c.game, "delete_clan")()
+	if err := c.scyllaClient.Query(`DELETE FROM clan_member WHERE clan_id = ?`, nil).Bind(clanId).ExecRelease(); err != nil {
+		return err
+	}
+	return c.scyllaClient.Query(`DELETE FROM clan WHERE id = ?`, nil).Bind(clanId).ExecRelease()
+}
+
+func (c *ClanRepositoryScylla) AddMember(clanId string, userId string) error {
+	defer trackScyllaLatency(c.game, "add_clan_member")()
+	return c.scyllaClient.Query(`INSERT INTO clan_member (clan_id,user_id,joined_at) VALUES (?,?,?)`, nil).
+		Bind(clanId, userId, time.Now()).
+		ExecRelease()
+}
+
+func (c *ClanRepositoryScylla) RemoveMember(clanId string, userId string) error {
+	defer trackScyllaLatency(c.game, "remove_clan_member")()
+	return c.scyllaClient.Query(`DELETE FROM clan_member WHERE clan_id = ? AND user_id = ?`, nil).
+		Bind(clanId, userId).
+		ExecRelease()
+}
+
+func (c *ClanRepositoryScylla) GetMembers(clanId string) ([]*entities.ClanMember, error) {
+	defer trackScyllaLatency(c.game, "get_clan_members")()
+	var members []*entities.ClanMember
+	if err := c.scyllaClient.Query(`SELECT clan_id,user_id,joined_at FROM clan_member WHERE clan_id = ?`, nil).Bind(clanId).SelectRelease(&members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (c *ClanRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(c.game, "purge_clans")()
+	for _, table := range []string{"clan", "clan_name", "clan_member"} {
+		if err := c.scyllaClient.Query(`TRUNCATE `+table, nil).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"strconv"
+)
+
+// clanAggregate sums the best ARGV[1] contributions of KEYS[1], all of them if it's 0.
+// Clans are small, so recomputing it on every change is cheap.
+const clanAggregate = `
+local function aggregate(key, k)
+	local stop = -1
+	if k > 0 then
+		stop = k - 1
+	end
+	local entries = redis.call('ZRANGE', key, 0, stop, 'REV', 'WITHSCORES')
+	local sum = 0
+	for i = 2, #entries, 2 do
+		sum = sum + tonumber(entries[i])
+	end
+	return tostring(sum)
+end
+`
+
+// Clan scripts bump the clan's version (KEYS[2]) along with every change of its contributions (KEYS[1]) and
+// return the new aggregate with the version, which orders the updates of the ranking.
+
+// clanContributeScript adds to the member's contribution, unless they are no longer in the clan
+var clanContributeScript = rueidis.NewLuaScript(clanAggregate + `
+if not redis.call('ZADD', KEYS[1], 'XX', 'INCR', ARGV[3], ARGV[2]) then
+	return false
+end
+return {aggregate(KEYS[1], tonumber(ARGV[1])), tostring(redis.call('INCR', KEYS[2]))}
+`)
+
+// clanAddMemberScript adds the member with no contribution if the clan has room for them (ARGV[3], 0 for no limit)
+var clanAddMemberScript = rueidis.NewLuaScript(clanAggregate + `
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	return {aggregate(KEYS[1], tonumber(ARGV[1])), tostring(redis.call('INCR', KEYS[2]))}
+end
+local maxMembers = tonumber(ARGV[3])
+if maxMembers > 0 and redis.call('ZCARD', KEYS[1]) >= maxMembers then
+	return false
+end
+redis.call('ZADD', KEYS[1], 0, ARGV[2])
+return {aggregate(KEYS[1], tonumber(ARGV[1])), tostring(redis.call('INCR', KEYS[2]))}
+`)
+
+// clanRemoveMemberScript also returns the member's contribution and the number of members left
+var clanRemoveMemberScript = rueidis.NewLuaScript(clanAggregate + `
+local contribution = redis.call('ZSCORE', KEYS[1], ARGV[2]) or '0'
+redis.call('ZREM', KEYS[1], ARGV[2])
+return {aggregate(KEYS[1], tonumber(ARGV[1])), tostring(redis.call('INCR', KEYS[2])), contribution, tostring(redis.call('ZCARD', KEYS[1]))}
+`)
+
+// clanRankScript applies a clan's aggregate to the ranking (KEYS[1]) unless a later version of it was applied already,
+// as recorded in KEYS[2]. ARGV[4] set to 1 takes the clan out of the ranking.
+var clanRankScript = rueidis.NewLuaScript(`
+local applied = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0')
+if tonumber(ARGV[2]) <= applied then
+	return 0
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+if ARGV[4] == '1' then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+else
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// ClanScoreRepository keeps every clan's member contributions and the ranking of clans by their aggregate.
+// topK is how many of the best contributions make up the aggregate, 0 for all of them. The ranking is in another
+// hash slot than the contributions, so it can't be updated by the same script. Instead every change of a clan's
+// contributions gets a version and the ranking only takes aggregates newer than the one it has.
+type ClanScoreRepository interface {
+	AddContribution(clanId string, userId string, delta int, topK int) error
+	// AddMember adds the member with no contribution, false if the clan already has maxMembers (0 for no limit)
+	AddMember(clanId string, userId string, maxMembers int, topK int) (bool, error)
+	// RemoveMember returns the contribution the member had made and how many members are left
+	RemoveMember(clanId string, userId string, topK int) (int, int, error)
+	GetContributions(clanId string) (map[string]int, error)
+	GetTopClans(count int) ([]*entities.ClanScore, error)
+	GetClanScore(clanId string) (*entities.ClanScore, error)
+	// SaveCarriedContribution keeps the contribution of a user who left their clan for the next one they join
+	SaveCarriedContribution(userId string, contribution int) error
+	GetCarriedContribution(userId string) (int, error)
+	TakeCarriedContribution(userId string) (int, error)
+	Purge() error
+}
+
+type clanScoreRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewClanScoreRepository(c rueidis.Client, game *game_config.Game) ClanScoreRepository {
+	return &clanScoreRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (c *clanScoreRepositoryRedis) rankingKey() string {
+	return c.ns + "clans:ranking"
+}
+
+// rankingVersionsKey holds the version of every clan's aggregate in the ranking, in the ranking's hash slot
+func (c *clanScoreRepositoryRedis) rankingVersionsKey() string {
+	return "{" + c.rankingKey() + "}:versions"
+}
+
+func (c *clanScoreRepositoryRedis) key(clanId string) string {
+	return c.ns + fmt.Sprintf("clan:{%s}:contributions", clanId)
+}
+
+func (c *clanScoreRepositoryRedis) versionKey(clanId string) string {
+	return c.ns + fmt.Sprintf("clan:{%s}:version", clanId)
+}
+
+func (c *clanScoreRepositoryRedis) carriedKey(userId string) string {
+	return c.ns + fmt.Sprintf("clan_contribution:{%s}", userId)
+}
+
+func (c *clanScoreRepositoryRedis) AddContribution(clanId string, userId string, delta int, topK int) error {
+	res, err := clanContributeScript.Exec(context.Background(), c.c, []string{c.key(clanId), c.versionKey(clanId)}, []string{strconv.Itoa(topK), userId, strconv.Itoa(delta)}).ToArray()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return nil
+		}
+		return err
+	}
+	values, err := floatValues(res)
+	if err != nil {
+		return err
+	}
+	return c.rank(clanId, values[0], values[1], false)
+}
+
+func (c *clanScoreRepositoryRedis) AddMember(clanId string, userId string, maxMembers int, topK int) (bool, error) {
+	res, err := clanAddMemberScript.Exec(context.Background(), c.c, []string{c.key(clanId), c.versionKey(clanId)}, []string{strconv.Itoa(topK), userId, strconv.Itoa(maxMembers)}).ToArray()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	values, err := floatValues(res)
+	if err != nil {
+		return false, err
+	}
+	return true, c.rank(clanId, values[0], values[1], false)
+}
+
+func (c *clanScoreRepositoryRedis) RemoveMember(clanId string, userId string, topK int) (int, int, error) {
+	res, err := clanRemoveMemberScript.Exec(context.Background(), c.c, []string{c.key(clanId), c.versionKey(clanId)}, []string{strconv.Itoa(topK), userId}).ToArray()
+	if err != nil {
+		return 0, 0, err
+	}
+	values, err := floatValues(res)
+	if err != nil {
+		return 0, 0, err
+	}
+	aggregate, version, contribution, members := values[0], values[1], int(values[2]), int(values[3])
+	return contribution, members, c.rank(clanId, aggregate, version, members == 0)
+}
+
+// rank applies the clan's aggregate of the given version to the ranking, clans without members are taken out
+func (c *clanScoreRepositoryRedis) rank(clanId string, aggregate float64, version float64, remove bool) error {
+	removeArg := "0"
+	if remove {
+		removeArg = "1"
+	}
+	return clanRankScript.Exec(
+		context.Background(),
+		c.c,
+		[]string{c.rankingKey(), c.rankingVersionsKey()},
+		[]string{clanId, strconv.FormatFloat(version, 'f', -1, 64), strconv.FormatFloat(aggregate, 'f', -1, 64), removeArg},
+	).Error()
+}
+
+func floatValues(res []rueidis.RedisMessage) ([]float64, error) {
+	values := make([]float64, 0, len(res))
+	for _, r := range res {
+		value, err := r.AsFloat64()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (c *clanScoreRepositoryRedis) GetContributions(clanId string) (map[string]int, error) {
+	zScores, err := c.c.Do(context.Background(), c.c.B().Zrange().Key(c.key(clanId)).Min("0").Max("-1").Withscores().Build()).AsZScores()
+	if err != nil {
+		return nil, err
+	}
+	contributions := make(map[string]int, len(zScores))
+	for _, zScore := range zScores {
+		contributions[zScore.Member] = int(zScore.Score)
+	}
+	return contributions, nil
+}
+
+func (c *clanScoreRepositoryRedis) GetTopClans(count int) ([]*entities.ClanScore, error) {
+	zScores, err := c.c.Do(context.Background(), c.c.B().Zrange().Key(c.rankingKey()).Min("0").Max(strconv.Itoa(count-1)).Rev().Withscores().Build()).AsZScores()
+	if err != nil {
+		return nil, err
+	}
+	clanScores := make([]*entities.ClanScore, 0, len(zScores))
+	for i, zScore := range zScores {
+		clanScores = append(clanScores, &entities.ClanScore{
+			ClanId:   zScore.Member,
+			Score:    int(zScore.Score),
+			Position: i + 1,
+		})
+	}
+	return clanScores, nil
+}
+
+// GetClanScore returns nil if the clan has no members
+func (c *clanScoreRepositoryRedis) GetClanScore(clanId string) (*entities.ClanScore, error) {
+	res := c.c.DoMulti(
+		context.Background(),
+		c.c.B().Zscore().Key(c.rankingKey()).Member(clanId).Build(),
+		c.c.B().Zrevrank().Key(c.rankingKey()).Member(clanId).Build(),
+	)
+	score, err := res[0].AsFloat64()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rank, err := res[1].AsInt64()
+	if err != nil {
+		return nil, err
+	}
+	return &entities.ClanScore{
+		ClanId:   clanId,
+		Score:    int(score),
+		Position: int(rank) + 1,
+	}, nil
+}
+
+func (c *clanScoreRepositoryRedis) SaveCarriedContribution(userId string, contribution int) error {
+	if contribution == 0 {
+		return c.c.Do(context.Background(), c.c.B().Del().Key(c.carriedKey(userId)).Build()).Error()
+	}
+	return c.c.Do(context.Background(), c.c.B().Set().Key(c.carriedKey(userId)).Value(strconv.Itoa(contribution)).Build()).Error()
+}
+
+func (c *clanScoreRepositoryRedis) GetCarriedContribution(userId string) (int, error) {
+	contribution, err := c.c.Do(context.Background(), c.c.B().Get().Key(c.carriedKey(userId)).Build()).AsInt64()
+	if rueidis.IsRedisNil(err) {
+		return 0, nil
+	}
+	return int(contribution), err
+}
+
+func (c *clanScoreRepositoryRedis) TakeCarriedContribution(userId string) (int, error) {
+	contribution, err := c.c.Do(context.Background(), c.c.B().Getdel().Key(c.carriedKey(userId)).Build()).AsInt64()
+	if rueidis.IsRedisNil(err) {
+		return 0, nil
+	}
+	return int(contribution), err
+}
+
+func (c *clanScoreRepositoryRedis) Purge() error {
+	if err := deleteKeysByPattern(c.c, c.ns+"clan*"); err != nil {
+		return err
+	}
+	return c.c.Do(context.Background(), c.c.B().Del().Key(c.rankingVersionsKey()).Build()).Error()
+}
@@ -0,0 +1,145 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+// FriendshipRepository keeps friendships symmetric, every friendship and friend request is stored in both directions
+type FriendshipRepository interface {
+	Add(userId string, friendId string) error
+	Remove(userId string, friendId string) error
+	GetFriends(userId string) ([]*entities.Friendship, error)
+	AddRequest(userId string, friendId string) error
+	// RemoveRequest removes a request between the two users, whoever sent it
+	RemoveRequest(userId string, otherUserId string) error
+	GetRequests(userId string) ([]*entities.FriendRequest, error)
+	// DeleteUserFriendships removes the user's friendships and friend requests
+	DeleteUserFriendships(userId string) error
+	Purge() error
+}
+
+type FriendshipRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewFriendshipRepository(session *gocqlx.Session, game *game_config.Game) FriendshipRepository {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS friendship (
+    	user_id uuid,
+    	friend_id uuid,
+    	created_at timestamp,
+    	PRIMARY KEY (user_id, friend_id))`,
+		`CREATE TABLE IF NOT EXISTS friend_request (
+    	user_id uuid,
+    	other_user_id uuid,
+    	incoming boolean,
+    	created_at timestamp,
+    	PRIMARY KEY (user_id, other_user_id))`,
+	}
+	for _, query := range queries {
+		if err := session.Query(query, nil).Exec(); err != nil {
+			panic(err)
+		}
+	}
+	return &FriendshipRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (f *FriendshipRepositoryScylla) Add(userId string, friendId string) error {
+	defer trackScyllaLatency(f.game, "add_friendship")()
+	createdAt := time.Now()
+	batch := f.scyllaClient.Session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO friendship (user_id,friend_id,created_at) VALUES (?,?,?)`, userId, friendId, createdAt)
+	batch.Query(`INSERT INTO friendship (user_id,friend_id,created_at) VALUES (?,?,?)`, friendId, userId, createdAt)
+	return f.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+func (f *FriendshipRepositoryScylla) Remove(userId string, friendId string) error {
+	defer trackScyllaLatency(f.game, "remove_friendship")()
+	batch := f.scyllaClient.Session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM friendship WHERE user_id = ? AND friend_id = ?`, userId, friendId)
+	batch.Query(`DELETE FROM friendship WHERE user_id = ? AND friend_id = ?`, friendId, userId)
+	return f.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+func (f *FriendshipRepositoryScylla) GetFriends(userId string) ([]*entities.Friendship, error) {
+	defer trackScyllaLatency(f.game, "get_friends")()
+	var friendships []*entities.Friendship
+	query := f.scyllaClient.Query(`SELECT user_id,friend_id,created_at FROM friendship WHERE user_id = ?`, nil).Bind(userId)
+	if err := query.SelectRelease(&friendships); err != nil {
+		return nil, err
+	}
+	return friendships, nil
+}
+
+func (f *FriendshipRepositoryScylla) AddRequest(userId string, friendId string) error {
+	defer trackScyllaLatency(f.game, "add_friend_request")()
+	createdAt := time.Now()
+	batch := f.scyllaClient.Session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO friend_request (user_id,other_user_id,incoming,created_at) VALUES (?,?,?,?)`, userId, friendId, false, createdAt)
+	batch.Query(`INSERT INTO friend_request (user_id,other_user_id,incoming,created_at) VALUES (?,?,?,?)`, friendId, userId, true, createdAt)
+	return f.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+func (f *FriendshipRepositoryScylla) RemoveRequest(userId string, otherUserId string) error {
+	defer trackScyllaLatency(f.game, "remove_friend_request")()
+	batch := f.scyllaClient.Session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM friend_request WHERE user_id = ? AND other_user_id = ?`, userId, otherUserId)
+	batch.Query(`DELETE FROM friend_request WHERE user_id = ? AND other_user_id = ?`, otherUserId, userId)
+	return f.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+func (f *FriendshipRepositoryScylla) GetRequests(userId string) ([]*entities.FriendRequest, error) {
+	defer trackScyllaLatency(f.game, "get_friend_requests")()
+	var requests []*entities.FriendRequest
+	query := f.scyllaClient.Query(`SELECT user_id,other_user_id,incoming,created_at FROM friend_request WHERE user_id = ?`, nil).Bind(userId)
+	if err := query.SelectRelease(&requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// DeleteUserFriendships removes the user from their friends' lists and requests as well
+func (f *FriendshipRepositoryScylla) DeleteUserFriendships(userId string) error {
+	friendships, err := f.GetFriends(userId)
+	if err != nil {
+		return err
+	}
+	requests, err := f.GetRequests(userId)
+	if err != nil {
+		return err
+	}
+	defer trackScyllaLatency(f.game, "delete_user_friendships")()
+	for _, friendship := range friendships {
+		err := f.scyllaClient.Query(`DELETE FROM friendship WHERE user_id = ? AND friend_id = ?`, nil).
+			Bind(friendship.FriendId, userId).
+			ExecRelease()
+		if err != nil {
+			return err
+		}
+	}
+	for _, request := range requests {
+		err := f.scyllaClient.Query(`DELETE FROM friend_request WHERE user_id = ? AND other_user_id = ?`, nil).
+			Bind(request.OtherUserId, userId).
+			ExecRelease()
+		if err != nil {
+			return err
+		}
+	}
+	if err := f.scyllaClient.Query(`DELETE FROM friend_request WHERE user_id = ?`, nil).Bind(userId).ExecRelease(); err != nil {
+		return err
+	}
+	return f.scyllaClient.Query(`DELETE FROM friendship WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
+
+func (f *FriendshipRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(f.game, "purge_friendships")()
+	if err := f.scyllaClient.Query(`TRUNCATE friend_request`, nil).Exec(); err != nil {
+		return err
+	}
+	return f.scyllaClient.Query(`TRUNCATE friendship`, nil).Exec()
+}
@@ -0,0 +1,344 @@
+package repositories
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/entities"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// GlobalLeaderboardShards is how many sorted sets the global leaderboard is spread over, so that it doesn't
+// end up on a single cluster node. Every user lives in exactly one shard, changing it requires moving the data.
+const GlobalLeaderboardShards = 16
+
+// GlobalRankBucketsPerDoubling is how many histogram buckets every doubling of the score is split into, so buckets
+// are about 9% of the score wide at any score and the histogram stays small however high scores grow. Negative
+// scores mirror positive ones. Changing it requires RebuildGlobalHistogram.
+const GlobalRankBucketsPerDoubling = 8
+
+// Every shard keeps a histogram of its visible users' scores in the same hash slot, maintained by the scripts
+// below together with the sorted set. Shadow-banned users are not counted.
+
+// globalBucketFunction is GlobalRankBucket for the scripts, they all start with it
+const globalBucketFunction = `
+local function bucket(score, perDoubling)
+	score = tonumber(score)
+	if score < 0 then
+		return -1 - math.floor(math.log(-score) / math.log(2) * perDoubling)
+	end
+	return math.floor(math.log(score + 1) / math.log(2) * perDoubling)
+end
+`
+
+// globalIncrScript increments the score, moving the user between buckets. With ARGV[4] == '1' users who are
+// not on the leaderboard are left out.
+const globalIncrScript = globalBucketFunction + `
+local old = redis.call('ZSCORE', KEYS[1], ARGV[2])
+if not old and ARGV[4] == '1' then
+	return false
+end
+local new = redis.call('ZINCRBY', KEYS[1], ARGV[1], ARGV[2])
+local perDoubling = tonumber(ARGV[3])
+if old then
+	redis.call('HINCRBY', KEYS[2], bucket(old, perDoubling), -1)
+end
+redis.call('HINCRBY', KEYS[2], bucket(new, perDoubling), 1)
+return new
+`
+
+// globalSetScript sets the score of a user already on the leaderboard, moving them between buckets
+const globalSetScript = globalBucketFunction + `
+local old = redis.call('ZSCORE', KEYS[1], ARGV[2])
+if not old then
+	return false
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+local perDoubling = tonumber(ARGV[3])
+redis.call('HINCRBY', KEYS[2], bucket(old, perDoubling), -1)
+redis.call('HINCRBY', KEYS[2], bucket(ARGV[1], perDoubling), 1)
+return old
+`
+
+// globalRemoveScript removes the user from KEYS[1] and their bucket, moving the score to KEYS[3] if given
+var globalRemoveScript = rueidis.NewLuaScript(globalBucketFunction + `
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	redis.call('HINCRBY', KEYS[2], bucket(score, tonumber(ARGV[2])), -1)
+	if KEYS[3] then
+		redis.call('ZINCRBY', KEYS[3], score, ARGV[1])
+	end
+end
+return score
+`)
+
+// globalRestoreScript moves the user's score from KEYS[3] back to KEYS[1], merging with a score already there
+var globalRestoreScript = rueidis.NewLuaScript(globalBucketFunction + `
+local score = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if not score then
+	return false
+end
+redis.call('ZREM', KEYS[3], ARGV[1])
+local old = redis.call('ZSCORE', KEYS[1], ARGV[1])
+local new = redis.call('ZINCRBY', KEYS[1], score, ARGV[1])
+local perDoubling = tonumber(ARGV[2])
+if old then
+	redis.call('HINCRBY', KEYS[2], bucket(old, perDoubling), -1)
+end
+redis.call('HINCRBY', KEYS[2], bucket(new, perDoubling), 1)
+return new
+`)
+
+// globalHistogramRebuildScript recounts the histogram from the sorted set
+var globalHistogramRebuildScript = rueidis.NewLuaScript(globalBucketFunction + `
+redis.call('DEL', KEYS[2])
+local perDoubling = tonumber(ARGV[1])
+local counts = {}
+local entries = redis.call('ZRANGE', KEYS[1], 0, -1, 'WITHSCORES')
+for i = 2, #entries, 2 do
+	local b = bucket(entries[i], perDoubling)
+	counts[b] = (counts[b] or 0) + 1
+end
+for b, count in pairs(counts) do
+	redis.call('HSET', KEYS[2], b, count)
+end
+return #entries / 2
+`)
+
+var globalRankBucketsArg = strconv.Itoa(GlobalRankBucketsPerDoubling)
+
+// GlobalRankBucket returns the histogram bucket of the score. Bucket b >= 0 holds the scores s with
+// 2^(b/GlobalRankBucketsPerDoubling) <= s+1 < 2^((b+1)/GlobalRankBucketsPerDoubling), bucket -1-b holds -1-s
+// for each of them.
+func GlobalRankBucket(score int) int {
+	if score < 0 {
+		return -1 - GlobalRankBucket(-score-1)
+	}
+	return int(math.Floor(math.Log(float64(score+1)) / math.Log(2) * GlobalRankBucketsPerDoubling))
+}
+
+// GlobalRankBucketBounds returns the lowest and the highest score of the bucket, the highest is below the lowest
+// for the narrow low buckets no integer score falls into
+func GlobalRankBucketBounds(bucket int) (int, int) {
+	if bucket < 0 {
+		lowest, highest := GlobalRankBucketBounds(-1 - bucket)
+		return -1 - highest, -1 - lowest
+	}
+	boundary := func(b int) int {
+		return int(math.Ceil(math.Pow(2, float64(b)/GlobalRankBucketsPerDoubling))) - 1
+	}
+	return boundary(bucket), boundary(bucket+1) - 1
+}
+
+func (l *LeaderboardRedisRepo) globalShard(userId string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userId))
+	return int(h.Sum32() % GlobalLeaderboardShards)
+}
+
+func (l *LeaderboardRedisRepo) globalShardKey(shard int) string {
+	return l.ns + fmt.Sprintf("leaderboard:{global:%d}:data", shard)
+}
+
+func (l *LeaderboardRedisRepo) globalShadowShardKey(shard int) string {
+	return l.ns + fmt.Sprintf("leaderboard:{global:%d}:shadow", shard)
+}
+
+func (l *LeaderboardRedisRepo) globalHistogramShardKey(shard int) string {
+	return l.ns + fmt.Sprintf("leaderboard:{global:%d}:histogram", shard)
+}
+
+func (l *LeaderboardRedisRepo) globalHistogramKey(userId string) string {
+	return l.globalHistogramShardKey(l.globalShard(userId))
+}
+
+// globalIncrCmd is built as a plain EVAL so it can be pipelined with the leaderboards' commands
+func (l *LeaderboardRedisRepo) globalIncrCmd(userId string, increment int, onlyExisting bool) rueidis.Completed {
+	existing := "0"
+	if onlyExisting {
+		existing = "1"
+	}
+	return l.c.B().Eval().Script(globalIncrScript).Numkeys(2).
+		Key(l.globalKey(userId), l.globalHistogramKey(userId)).
+		Arg(strconv.Itoa(increment), userId, globalRankBucketsArg, existing).
+		Build()
+}
+
+// globalSetCmd is built as a plain EVAL so it can be pipelined with the leaderboards' commands
+func (l *LeaderboardRedisRepo) globalSetCmd(userId string, score int) rueidis.Completed {
+	return l.c.B().Eval().Script(globalSetScript).Numkeys(2).
+		Key(l.globalKey(userId), l.globalHistogramKey(userId)).
+		Arg(strconv.Itoa(score), userId, globalRankBucketsArg).
+		Build()
+}
+
+func (l *LeaderboardRedisRepo) globalKey(userId string) string {
+	return l.globalShardKey(l.globalShard(userId))
+}
+
+func (l *LeaderboardRedisRepo) globalShadowKey(userId string) string {
+	return l.globalShadowShardKey(l.globalShard(userId))
+}
+
+func (l *LeaderboardRedisRepo) RemoveFromGlobal(userId string) error {
+	err := globalRemoveScript.Exec(context.Background(), l.c, []string{l.globalKey(userId), l.globalHistogramKey(userId)}, []string{userId, globalRankBucketsArg}).Error()
+	if err != nil && !rueidis.IsRedisNil(err) {
+		return err
+	}
+	return l.c.Do(context.Background(), l.c.B().Zrem().Key(l.globalShadowKey(userId)).Member(userId).Build()).Error()
+}
+
+func (l *LeaderboardRedisRepo) MoveToGlobalShadow(userId string) error {
+	err := globalRemoveScript.Exec(context.Background(), l.c, []string{l.globalKey(userId), l.globalHistogramKey(userId), l.globalShadowKey(userId)}, []string{userId, globalRankBucketsArg}).Error()
+	if rueidis.IsRedisNil(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *LeaderboardRedisRepo) MoveFromGlobalShadow(userId string) error {
+	err := globalRestoreScript.Exec(context.Background(), l.c, []string{l.globalKey(userId), l.globalHistogramKey(userId), l.globalShadowKey(userId)}, []string{userId, globalRankBucketsArg}).Error()
+	if rueidis.IsRedisNil(err) {
+		return nil
+	}
+	return err
+}
+
+// GetGlobalHistogram returns the number of visible users per score bucket summed over all shards, see
+// GlobalRankBucket
+func (l *LeaderboardRedisRepo) GetGlobalHistogram() (map[int]int, error) {
+	cmds := make(rueidis.Commands, 0, GlobalLeaderboardShards)
+	for shard := 0; shard < GlobalLeaderboardShards; shard++ {
+		cmds = append(cmds, l.c.B().Hgetall().Key(l.globalHistogramShardKey(shard)).Build())
+	}
+	histogram := make(map[int]int)
+	for _, r := range l.c.DoMulti(context.Background(), cmds...) {
+		shardHistogram, err := r.AsIntMap()
+		if err != nil {
+			return nil, err
+		}
+		for bucket, count := range shardHistogram {
+			b, err := strconv.Atoi(bucket)
+			if err != nil {
+				return nil, err
+			}
+			histogram[b] += int(count)
+		}
+	}
+	return histogram, nil
+}
+
+// RebuildGlobalHistogram recounts every shard's histogram, each shard atomically
+func (l *LeaderboardRedisRepo) RebuildGlobalHistogram() (int, error) {
+	total := 0
+	for shard := 0; shard < GlobalLeaderboardShards; shard++ {
+		count, err := globalHistogramRebuildScript.Exec(context.Background(), l.c, []string{l.globalShardKey(shard), l.globalHistogramShardKey(shard)}, []string{globalRankBucketsArg}).AsInt64()
+		if err != nil {
+			return 0, err
+		}
+		total += int(count)
+	}
+	return total, nil
+}
+
+// GetGlobalSize returns the number of visible users on the global leaderboard
+func (l *LeaderboardRedisRepo) GetGlobalSize() (int, error) {
+	cmds := make(rueidis.Commands, 0, GlobalLeaderboardShards)
+	for shard := 0; shard < GlobalLeaderboardShards; shard++ {
+		cmds = append(cmds, l.c.B().Zcard().Key(l.globalShardKey(shard)).Build())
+	}
+	total := 0
+	for _, r := range l.c.DoMulti(context.Background(), cmds...) {
+		size, err := r.AsInt64()
+		if err != nil {
+			return 0, err
+		}
+		total += int(size)
+	}
+	return total, nil
+}
+
+// GetGlobalScore returns the user's global score from whichever of the visible or shadow shards they are in
+func (l *LeaderboardRedisRepo) GetGlobalScore(userId string) (int, bool, error) {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zscore().Key(l.globalKey(userId)).Member(userId).Build(),
+		l.c.B().Zscore().Key(l.globalShadowKey(userId)).Member(userId).Build(),
+	)
+	for _, r := range res {
+		score, err := r.AsFloat64()
+		if err == nil {
+			return int(score), true, nil
+		}
+		if !rueidis.IsRedisNil(err) {
+			return 0, false, err
+		}
+	}
+	return 0, false, nil
+}
+
+// GetGlobalLeaderboard merges the top of every shard, which is exact since users don't span shards
+func (l *LeaderboardRedisRepo) GetGlobalLeaderboard(count int) ([]*entities.LeaderboardScore, error) {
+	cmds := make(rueidis.Commands, 0, GlobalLeaderboardShards)
+	for shard := 0; shard < GlobalLeaderboardShards; shard++ {
+		cmds = append(cmds, l.c.B().Zrange().Key(l.globalShardKey(shard)).Min("0").Max(strconv.Itoa(count-1)).Rev().Withscores().Build())
+	}
+	merged := make([]rueidis.ZScore, 0, count)
+	for _, r := range l.c.DoMulti(context.Background(), cmds...) {
+		zScores, err := r.AsZScores()
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, zScores...)
+	}
+	// same order a single sorted set would give: score descending, then member descending
+	slices.SortFunc(merged, func(a, b rueidis.ZScore) int {
+		if a.Score != b.Score {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		return cmp.Compare(b.Member, a.Member)
+	})
+	if len(merged) > count {
+		merged = merged[:count]
+	}
+	scores := make([]*entities.LeaderboardScore, 0, len(merged))
+	for i, zScore := range merged {
+		scores = append(scores, &entities.LeaderboardScore{
+			UserId:   zScore.Member,
+			Score:    int(zScore.Score),
+			Position: i + 1,
+		})
+	}
+	return scores, nil
+}
+
+// GetGlobalUserScore returns the user's global score and position among visible users, counting users
+// with a higher score in every shard. Returns nil if the user is not on the global leaderboard.
+func (l *LeaderboardRedisRepo) GetGlobalUserScore(userId string) (*entities.LeaderboardScore, error) {
+	score, found, err := l.GetGlobalScore(userId)
+	if err != nil || !found {
+		return nil, err
+	}
+	cmds := make(rueidis.Commands, 0, GlobalLeaderboardShards)
+	for shard := 0; shard < GlobalLeaderboardShards; shard++ {
+		cmds = append(cmds, l.c.B().Zcount().Key(l.globalShardKey(shard)).Min("("+strconv.Itoa(score)).Max("+inf").Build())
+	}
+	higher := 0
+	for _, r := range l.c.DoMulti(context.Background(), cmds...) {
+		count, err := r.AsInt64()
+		if err != nil {
+			return nil, err
+		}
+		higher += int(count)
+	}
+	return &entities.LeaderboardScore{
+		UserId:   userId,
+		Score:    score,
+		Position: higher + 1,
+	}, nil
+}
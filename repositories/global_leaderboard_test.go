@@ -0,0 +1,22 @@
+package repositories
+
+import "testing"
+
+func TestGlobalRankBucketBounds(t *testing.T) {
+	previous := GlobalRankBucket(-10000)
+	for score := -10000; score <= 10000; score++ {
+		bucket := GlobalRankBucket(score)
+		lowest, highest := GlobalRankBucketBounds(bucket)
+		if score < lowest || score > highest {
+			t.Fatalf("score %d in bucket %d of scores [%d, %d]", score, bucket, lowest, highest)
+		}
+		if bucket < previous {
+			t.Fatalf("score %d in bucket %d, below bucket %d of a lower score", score, bucket, previous)
+		}
+		previous = bucket
+	}
+	// the histogram stays small even for huge scores
+	if bucket := GlobalRankBucket(1 << 30); bucket > 30*GlobalRankBucketsPerDoubling {
+		t.Fatalf("score 2^30 in bucket %d", bucket)
+	}
+}
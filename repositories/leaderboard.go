@@ -5,36 +5,106 @@ import (
 	"fmt"
 	"github.com/redis/rueidis"
 	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
 	"strconv"
 )
 
+// LeaderboardTopSize is how many top positions of a leaderboard are served
+const LeaderboardTopSize = 11
+
+// setScoresScript sets the scores of ARGV as pairs of member and score, returning the previous ones, 0 for new members
+var setScoresScript = rueidis.NewLuaScript(`
+local previous = {}
+for i = 1, #ARGV, 2 do
+	previous[#previous + 1] = redis.call('ZSCORE', KEYS[1], ARGV[i]) or '0'
+	redis.call('ZADD', KEYS[1], ARGV[i + 1], ARGV[i])
+end
+return previous
+`)
+
+// moveMemberScript moves a member with its score from one sorted set to another, merging with a score already there
+var moveMemberScript = rueidis.NewLuaScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	redis.call('ZINCRBY', KEYS[2], score, ARGV[1])
+end
+return score
+`)
+
 type LeaderboardRepo interface {
 	AddUser(leaderboard int, userId string) error
+	RemoveUser(leaderboard int, userId string) error
 	UpdateScore(leaderboard int, userId string, score int) (int, error)
+	UpdateShadowScore(leaderboard int, userId string, score int) (int, error)
+	UpdateScores(leaderboards []int, userId string, score int) (map[int]int, error)
+	UpdateShadowScores(leaderboards []int, userId string, score int) (map[int]int, error)
+	AdjustScores(leaderboards []int, userId string, delta int) error
+	SetUserScores(leaderboards []int, userId string, score int) error
+	MoveToShadow(leaderboard int, userId string) error
+	MoveFromShadow(leaderboard int, userId string) error
+	GetUserScore(leaderboard int, userId string) (*entities.LeaderboardScore, error)
+	GetScores(userLeaderboards map[string]int) (map[string]int, error)
+	GetPositionChange(leaderboard int, userId string, previousScore int) (int, int, error)
+	SetScores(leaderboard int, scores []*entities.LeaderboardScore) error
+	GetOvertaken(leaderboard int, previousScore int, score int, count int) ([]*entities.LeaderboardScore, error)
 	GetLeaderboard(leaderboard int) ([]*entities.LeaderboardScore, error)
+	GetStandings(leaderboard int, offset int, count int) ([]*entities.LeaderboardScore, error)
+	ResetScores(leaderboard int) error
 	GetAllLeaderboards() (map[int][]*entities.LeaderboardScore, error)
+	// GetAllLeaderboardsIds returns every leaderboard that ever had a user, whether it's listed is up to the registry
 	GetAllLeaderboardsIds() ([]int, error)
+	GetLeaderboardsSizes(leaderboards []int) (map[int]int, error)
+	PurgeLeaderboard(leaderboard int) error
+	RemoveFromGlobal(userId string) error
+	MoveToGlobalShadow(userId string) error
+	MoveFromGlobalShadow(userId string) error
+	GetGlobalLeaderboard(count int) ([]*entities.LeaderboardScore, error)
+	GetGlobalUserScore(userId string) (*entities.LeaderboardScore, error)
+	GetGlobalScore(userId string) (int, bool, error)
+	GetGlobalSize() (int, error)
+	GetGlobalHistogram() (map[int]int, error)
+	RebuildGlobalHistogram() (int, error)
+	AddTournamentUser(tournamentId string, userId string, shadow bool) error
+	RemoveTournamentUser(tournamentId string, userId string) error
+	UpdateTournamentScore(tournamentId string, userId string, score int, shadow bool) (bool, error)
+	MoveToTournamentShadow(tournamentId string, userId string) error
+	MoveFromTournamentShadow(tournamentId string, userId string) error
+	GetTournamentStandings(tournamentId string, offset int, count int) ([]*entities.LeaderboardScore, error)
+	GetTournamentUserScore(tournamentId string, userId string) (*entities.LeaderboardScore, error)
+	DeleteTournamentLeaderboard(tournamentId string) error
 	Purge() error
 }
 
 type LeaderboardRedisRepo struct {
-	c rueidis.Client
+	c  rueidis.Client
+	ns string
 }
 
-func NewLeaderboardRepo(c rueidis.Client) LeaderboardRepo {
-	return &LeaderboardRedisRepo{c: c}
+func NewLeaderboardRepo(c rueidis.Client, game *game_config.Game) LeaderboardRepo {
+	return &LeaderboardRedisRepo{c: c, ns: keyPrefix(game)}
 }
 
 func (l *LeaderboardRedisRepo) key(leaderboard int) string {
-	return fmt.Sprintf("leaderboard:{%d}:data", leaderboard)
+	return l.ns + fmt.Sprintf("leaderboard:{%d}:data", leaderboard)
+}
+
+// shadowKey holds shadow-banned users of the leaderboard, they keep scoring but are only visible to themselves
+func (l *LeaderboardRedisRepo) shadowKey(leaderboard int) string {
+	return l.ns + fmt.Sprintf("leaderboard:{%d}:shadow", leaderboard)
+}
+
+// activeLeaderboardsKey holds ids of leaderboards that ever had a user
+func (l *LeaderboardRedisRepo) activeLeaderboardsKey() string {
+	return l.ns + "leaderboards"
 }
 
 func (l *LeaderboardRedisRepo) updateActiveLeaderboards(leaderboard int) error {
-	return l.c.Do(context.Background(), l.c.B().Sadd().Key("leaderboards").Member(strconv.Itoa(leaderboard)).Build()).Error()
+	return l.c.Do(context.Background(), l.c.B().Sadd().Key(l.activeLeaderboardsKey()).Member(strconv.Itoa(leaderboard)).Build()).Error()
 }
 
 func (l *LeaderboardRedisRepo) GetAllLeaderboardsIds() ([]int, error) {
-	leaderBoards64, err := l.c.Do(context.Background(), l.c.B().Smembers().Key("leaderboards").Build()).AsIntSlice()
+	leaderBoards64, err := l.c.Do(context.Background(), l.c.B().Smembers().Key(l.activeLeaderboardsKey()).Build()).AsIntSlice()
 	if err != nil {
 		return nil, err
 	}
@@ -45,8 +115,24 @@ func (l *LeaderboardRedisRepo) GetAllLeaderboardsIds() ([]int, error) {
 	return leaderBoards, nil
 }
 
+func (l *LeaderboardRedisRepo) GetLeaderboardsSizes(leaderboards []int) (map[int]int, error) {
+	cmds := make(rueidis.Commands, 0, len(leaderboards))
+	for _, leaderboard := range leaderboards {
+		cmds = append(cmds, l.c.B().Zcard().Key(l.key(leaderboard)).Build())
+	}
+	sizes := make(map[int]int, len(leaderboards))
+	for i, r := range l.c.DoMulti(context.Background(), cmds...) {
+		size, err := r.AsInt64()
+		if err != nil {
+			return nil, err
+		}
+		sizes[leaderboards[i]] = int(size)
+	}
+	return sizes, nil
+}
+
 func (l *LeaderboardRedisRepo) GetAllLeaderboards() (map[int][]*entities.LeaderboardScore, error) {
-	leaderBoards, err := l.c.Do(context.Background(), l.c.B().Smembers().Key("leaderboards").Build()).AsIntSlice()
+	leaderBoards, err := l.c.Do(context.Background(), l.c.B().Smembers().Key(l.activeLeaderboardsKey()).Build()).AsIntSlice()
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +151,51 @@ func (l *LeaderboardRedisRepo) AddUser(leaderboard int, userId string) error {
 	if err := l.updateActiveLeaderboards(leaderboard); err != nil {
 		return err
 	}
-	return l.c.Do(context.Background(), l.c.B().Zadd().Key(l.key(leaderboard)).ScoreMember().ScoreMember(0, userId).Build()).Error()
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zadd().Key(l.key(leaderboard)).ScoreMember().ScoreMember(0, userId).Build(),
+		// the global score is kept when the user joins another leaderboard
+		l.globalIncrCmd(userId, 0, false),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+	return nil
+}
+
+// SetScores overwrites the visible scores of the given users and applies the differences to their global scores,
+// the way AdjustScores does, so the global leaderboard and its histogram stay in line with the leaderboard
+func (l *LeaderboardRedisRepo) SetScores(leaderboard int, scores []*entities.LeaderboardScore) error {
+	if len(scores) == 0 {
+		return nil
+	}
+	if err := l.updateActiveLeaderboards(leaderboard); err != nil {
+		return err
+	}
+	args := make([]string, 0, len(scores)*2)
+	for _, score := range scores {
+		args = append(args, score.UserId, strconv.Itoa(score.Score))
+	}
+	previous, err := setScoresScript.Exec(context.Background(), l.c, []string{l.key(leaderboard)}, args).ToArray()
+	if err != nil {
+		return err
+	}
+	cmds := make(rueidis.Commands, 0, len(scores))
+	for i, score := range scores {
+		previousScore, err := previous[i].AsFloat64()
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, l.globalIncrCmd(score.UserId, score.Score-int(previousScore), false))
+	}
+	for _, r := range l.c.DoMulti(context.Background(), cmds...) {
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+	return nil
 }
 
 func (l *LeaderboardRedisRepo) UpdateScore(leaderboard int, userId string, score int) (int, error) {
@@ -92,11 +222,263 @@ func (l *LeaderboardRedisRepo) UpdateScore(leaderboard int, userId string, score
 		return 0, fmt.Errorf("unexpected number of results from transaction")
 	}
 
+	// the global shard is in another hash slot, so it can't be part of the transaction
+	if err := l.c.Do(context.Background(), l.globalIncrCmd(userId, score, false)).Error(); err != nil {
+		return 0, err
+	}
+
 	return int(execResults[1]), nil
 }
 
+func (l *LeaderboardRedisRepo) UpdateShadowScore(leaderboard int, userId string, score int) (int, error) {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zincrby().Key(l.shadowKey(leaderboard)).Increment(float64(score)).Member(userId).Build(),
+		l.c.B().Zincrby().Key(l.globalShadowKey(userId)).Increment(float64(score)).Member(userId).Build(),
+	)
+	if err := res[1].Error(); err != nil {
+		return 0, err
+	}
+	finalScore, err := res[0].AsFloat64()
+	return int(finalScore), err
+}
+
+// UpdateScores increments the user's score on every leaderboard and once on the global one in a single pipeline,
+// returning the new leaderboard scores. Leaderboards live in different hash slots, so unlike UpdateScore this is not transactional.
+func (l *LeaderboardRedisRepo) UpdateScores(leaderboards []int, userId string, score int) (map[int]int, error) {
+	return l.incrementScores(l.key, l.globalIncrCmd(userId, score, false), leaderboards, userId, score)
+}
+
+func (l *LeaderboardRedisRepo) UpdateShadowScores(leaderboards []int, userId string, score int) (map[int]int, error) {
+	return l.incrementScores(l.shadowKey, l.c.B().Zincrby().Key(l.globalShadowKey(userId)).Increment(float64(score)).Member(userId).Build(), leaderboards, userId, score)
+}
+
+func (l *LeaderboardRedisRepo) incrementScores(key func(int) string, globalCmd rueidis.Completed, leaderboards []int, userId string, score int) (map[int]int, error) {
+	cmds := make(rueidis.Commands, 0, len(leaderboards)+1)
+	for _, leaderboard := range leaderboards {
+		cmds = append(cmds, l.c.B().Zincrby().Key(key(leaderboard)).Increment(float64(score)).Member(userId).Build())
+	}
+	cmds = append(cmds, globalCmd)
+	res := l.c.DoMulti(context.Background(), cmds...)
+	if err := res[len(leaderboards)].Error(); err != nil {
+		return nil, err
+	}
+	finalScores := make(map[int]int, len(leaderboards))
+	for i, leaderboard := range leaderboards {
+		finalScore, err := res[i].AsFloat64()
+		if err != nil {
+			return nil, err
+		}
+		finalScores[leaderboard] = int(finalScore)
+	}
+	return finalScores, nil
+}
+
+func (l *LeaderboardRedisRepo) RemoveUser(leaderboard int, userId string) error {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zrem().Key(l.key(leaderboard)).Member(userId).Build(),
+		l.c.B().Zrem().Key(l.shadowKey(leaderboard)).Member(userId).Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+	return nil
+}
+
+// AdjustScores and SetUserScores only touch the user in whichever of the visible or shadow sets they are in,
+// so removed users are not brought back by moderation. The global score changes once, like with UpdateScores:
+// AdjustScores applies the delta to it, SetUserScores sets it to the score, moving the user between histogram buckets.
+func (l *LeaderboardRedisRepo) AdjustScores(leaderboards []int, userId string, delta int) error {
+	cmds := make(rueidis.Commands, 0, len(leaderboards)*2+2)
+	for _, leaderboard := range leaderboards {
+		cmds = append(cmds,
+			l.c.B().Zadd().Key(l.key(leaderboard)).Xx().Incr().ScoreMember().ScoreMember(float64(delta), userId).Build(),
+			l.c.B().Zadd().Key(l.shadowKey(leaderboard)).Xx().Incr().ScoreMember().ScoreMember(float64(delta), userId).Build(),
+		)
+	}
+	cmds = append(cmds,
+		l.globalIncrCmd(userId, delta, true),
+		l.c.B().Zadd().Key(l.globalShadowKey(userId)).Xx().Incr().ScoreMember().ScoreMember(float64(delta), userId).Build(),
+	)
+	for _, r := range l.c.DoMulti(context.Background(), cmds...) {
+		if r.Error() != nil && !rueidis.IsRedisNil(r.Error()) {
+			return r.Error()
+		}
+	}
+	return nil
+}
+
+func (l *LeaderboardRedisRepo) SetUserScores(leaderboards []int, userId string, score int) error {
+	cmds := make(rueidis.Commands, 0, len(leaderboards)*2+2)
+	for _, leaderboard := range leaderboards {
+		cmds = append(cmds,
+			l.c.B().Zadd().Key(l.key(leaderboard)).Xx().ScoreMember().ScoreMember(float64(score), userId).Build(),
+			l.c.B().Zadd().Key(l.shadowKey(leaderboard)).Xx().ScoreMember().ScoreMember(float64(score), userId).Build(),
+		)
+	}
+	cmds = append(cmds,
+		l.globalSetCmd(userId, score),
+		l.c.B().Zadd().Key(l.globalShadowKey(userId)).Xx().ScoreMember().ScoreMember(float64(score), userId).Build(),
+	)
+	for _, r := range l.c.DoMulti(context.Background(), cmds...) {
+		if r.Error() != nil && !rueidis.IsRedisNil(r.Error()) {
+			return r.Error()
+		}
+	}
+	return nil
+}
+
+func (l *LeaderboardRedisRepo) MoveToShadow(leaderboard int, userId string) error {
+	err := moveMemberScript.Exec(context.Background(), l.c, []string{l.key(leaderboard), l.shadowKey(leaderboard)}, []string{userId}).Error()
+	if rueidis.IsRedisNil(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *LeaderboardRedisRepo) MoveFromShadow(leaderboard int, userId string) error {
+	err := moveMemberScript.Exec(context.Background(), l.c, []string{l.shadowKey(leaderboard), l.key(leaderboard)}, []string{userId}).Error()
+	if rueidis.IsRedisNil(err) {
+		return nil
+	}
+	return err
+}
+
+// GetUserScore returns the user's score and position, for shadow-banned users the position they would have
+// among visible users. Returns nil if the user is not on the leaderboard.
+func (l *LeaderboardRedisRepo) GetUserScore(leaderboard int, userId string) (*entities.LeaderboardScore, error) {
+	return l.userScore(l.key(leaderboard), l.shadowKey(leaderboard), leaderboard, userId)
+}
+
+func (l *LeaderboardRedisRepo) userScore(key string, shadowKey string, leaderboard int, userId string) (*entities.LeaderboardScore, error) {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zscore().Key(key).Member(userId).Build(),
+		l.c.B().Zrevrank().Key(key).Member(userId).Build(),
+		l.c.B().Zscore().Key(shadowKey).Member(userId).Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil && !rueidis.IsRedisNil(r.Error()) {
+			return nil, r.Error()
+		}
+	}
+	if score, err := res[0].AsFloat64(); err == nil {
+		rank, err := res[1].AsInt64()
+		if err != nil {
+			return nil, err
+		}
+		return &entities.LeaderboardScore{
+			Leaderboard: leaderboard,
+			UserId:      userId,
+			Score:       int(score),
+			Position:    int(rank) + 1,
+		}, nil
+	}
+	score, err := res[2].AsFloat64()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	higher, err := l.c.Do(context.Background(), l.c.B().Zcount().Key(key).Min("("+strconv.Itoa(int(score))).Max("+inf").Build()).AsInt64()
+	if err != nil {
+		return nil, err
+	}
+	return &entities.LeaderboardScore{
+		Leaderboard: leaderboard,
+		UserId:      userId,
+		Score:       int(score),
+		Position:    int(higher) + 1,
+	}, nil
+}
+
+// GetPositionChange returns the user's visible position the previous score had and the one the current score has.
+// Ties are counted in the user's favor for the previous position. Both are 0 if the user is not on the leaderboard.
+func (l *LeaderboardRedisRepo) GetPositionChange(leaderboard int, userId string, previousScore int) (int, int, error) {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zscore().Key(l.key(leaderboard)).Member(userId).Build(),
+		l.c.B().Zrevrank().Key(l.key(leaderboard)).Member(userId).Build(),
+		l.c.B().Zcount().Key(l.key(leaderboard)).Min("("+strconv.Itoa(previousScore)).Max("+inf").Build(),
+	)
+	score, err := res[0].AsFloat64()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	rank, err := res[1].AsInt64()
+	if err != nil {
+		return 0, 0, err
+	}
+	higher, err := res[2].AsInt64()
+	if err != nil {
+		return 0, 0, err
+	}
+	if int(score) > previousScore {
+		higher--
+	}
+	return int(higher) + 1, int(rank) + 1, nil
+}
+
+// GetOvertaken returns up to count users, best first, whose score lies strictly between the previous and the
+// current score of a user, i.e. those the user was behind and is now ahead of
+func (l *LeaderboardRedisRepo) GetOvertaken(leaderboard int, previousScore int, score int, count int) ([]*entities.LeaderboardScore, error) {
+	if count <= 0 || score-previousScore < 2 {
+		return nil, nil
+	}
+	zScores, err := l.c.Do(
+		context.Background(),
+		l.c.B().Zrange().Key(l.key(leaderboard)).Min("("+strconv.Itoa(score)).Max("("+strconv.Itoa(previousScore)).
+			Byscore().Rev().Limit(0, int64(count)).Withscores().Build(),
+	).AsZScores()
+	if err != nil {
+		return nil, err
+	}
+	overtaken := make([]*entities.LeaderboardScore, 0, len(zScores))
+	for _, zScore := range zScores {
+		overtaken = append(overtaken, &entities.LeaderboardScore{
+			Leaderboard: leaderboard,
+			UserId:      zScore.Member,
+			Score:       int(zScore.Score),
+		})
+	}
+	return overtaken, nil
+}
+
+// GetScores reads the visible score of every user on the given leaderboard in a single pipeline,
+// users who are not there are left out of the result
+func (l *LeaderboardRedisRepo) GetScores(userLeaderboards map[string]int) (map[string]int, error) {
+	userIds := make([]string, 0, len(userLeaderboards))
+	cmds := make(rueidis.Commands, 0, len(userLeaderboards))
+	for userId, leaderboard := range userLeaderboards {
+		userIds = append(userIds, userId)
+		cmds = append(cmds, l.c.B().Zscore().Key(l.key(leaderboard)).Member(userId).Build())
+	}
+	scores := make(map[string]int, len(userLeaderboards))
+	if len(cmds) == 0 {
+		return scores, nil
+	}
+	for i, r := range l.c.DoMulti(context.Background(), cmds...) {
+		score, err := r.AsFloat64()
+		if err != nil {
+			if rueidis.IsRedisNil(err) {
+				continue
+			}
+			return nil, err
+		}
+		scores[userIds[i]] = int(score)
+	}
+	return scores, nil
+}
+
 func (l *LeaderboardRedisRepo) GetLeaderboard(leaderboard int) ([]*entities.LeaderboardScore, error) {
-	userIds, err := l.c.Do(context.Background(), l.c.B().Zrange().Key(l.key(leaderboard)).Min("0").Max("10").Rev().Build()).AsStrSlice()
+	userIds, err := l.c.Do(context.Background(), l.c.B().Zrange().Key(l.key(leaderboard)).Min("0").Max(strconv.Itoa(LeaderboardTopSize-1)).Rev().Build()).AsStrSlice()
 	if err != nil {
 		return nil, err
 	}
@@ -118,9 +500,65 @@ func (l *LeaderboardRedisRepo) GetLeaderboard(leaderboard int) ([]*entities.Lead
 	return scores, nil
 }
 
-func (l *LeaderboardRedisRepo) Purge() error {
-	for _, node := range l.c.Nodes() {
-		node.Do(context.Background(), l.c.B().Flushall().Build())
+// GetStandings returns count visible users starting at offset, all of them from offset on if count is negative
+func (l *LeaderboardRedisRepo) GetStandings(leaderboard int, offset int, count int) ([]*entities.LeaderboardScore, error) {
+	return l.standings(l.key(leaderboard), leaderboard, offset, count)
+}
+
+func (l *LeaderboardRedisRepo) standings(key string, leaderboard int, offset int, count int) ([]*entities.LeaderboardScore, error) {
+	stop := -1
+	if count >= 0 {
+		stop = offset + count - 1
+	}
+	zScores, err := l.c.Do(context.Background(), l.c.B().Zrange().Key(key).Min(strconv.Itoa(offset)).Max(strconv.Itoa(stop)).Rev().Withscores().Build()).AsZScores()
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]*entities.LeaderboardScore, 0, len(zScores))
+	for i, zScore := range zScores {
+		scores = append(scores, &entities.LeaderboardScore{
+			Leaderboard: leaderboard,
+			UserId:      zScore.Member,
+			Score:       int(zScore.Score),
+			Position:    offset + i + 1,
+		})
+	}
+	return scores, nil
+}
+
+// ResetScores zeroes the score of every visible and shadow-banned user of the leaderboard, keeping them on it
+func (l *LeaderboardRedisRepo) ResetScores(leaderboard int) error {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zunionstore().Destination(l.key(leaderboard)).Numkeys(1).Key(l.key(leaderboard)).Weights(0).Build(),
+		l.c.B().Zunionstore().Destination(l.shadowKey(leaderboard)).Numkeys(1).Key(l.shadowKey(leaderboard)).Weights(0).Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+	return nil
+}
+
+func (l *LeaderboardRedisRepo) PurgeLeaderboard(leaderboard int) error {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Del().Key(l.key(leaderboard)).Build(),
+		l.c.B().Del().Key(l.shadowKey(leaderboard)).Build(),
+		l.c.B().Srem().Key(l.activeLeaderboardsKey()).Member(strconv.Itoa(leaderboard)).Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return r.Error()
+		}
 	}
 	return nil
 }
+
+func (l *LeaderboardRedisRepo) Purge() error {
+	if err := deleteKeysByPattern(l.c, l.ns+"leaderboard:*"); err != nil {
+		return err
+	}
+	return l.c.Do(context.Background(), l.c.B().Del().Key(l.activeLeaderboardsKey()).Build()).Error()
+}
@@ -0,0 +1,36 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+)
+
+type LeaderboardAssignmentRepository interface {
+	// NextSeat atomically hands out consecutive seat numbers starting from 1 for the given counter
+	NextSeat(counter string) (int, error)
+	Purge() error
+}
+
+type leaderboardAssignmentRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewLeaderboardAssignmentRepository(c rueidis.Client, game *game_config.Game) LeaderboardAssignmentRepository {
+	return &leaderboardAssignmentRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (l *leaderboardAssignmentRepositoryRedis) key(counter string) string {
+	return l.ns + fmt.Sprintf("leaderboard_assignment:{%s}:seats", counter)
+}
+
+func (l *leaderboardAssignmentRepositoryRedis) NextSeat(counter string) (int, error) {
+	seat, err := l.c.Do(context.Background(), l.c.B().Incr().Key(l.key(counter)).Build()).AsInt64()
+	return int(seat), err
+}
+
+func (l *leaderboardAssignmentRepositoryRedis) Purge() error {
+	return deleteKeysByPattern(l.c, l.ns+"leaderboard_assignment:*")
+}
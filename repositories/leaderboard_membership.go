@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+type LeaderboardMembershipRepository interface {
+	Add(membership *entities.LeaderboardMembership) error
+	Remove(userId string, leaderboard int) error
+	GetUserMemberships(userId string) ([]*entities.LeaderboardMembership, error)
+	GetLeaderboardMemberIds(leaderboard int) ([]string, error)
+	DeleteUserMemberships(userId string) error
+	Purge() error
+}
+
+type LeaderboardMembershipRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewLeaderboardMembershipRepository(session *gocqlx.Session, game *game_config.Game) LeaderboardMembershipRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS leaderboard_membership (
+    	user_id uuid,
+    	leaderboard int,
+    	joined_at timestamp,
+    	PRIMARY KEY (user_id, leaderboard))`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &LeaderboardMembershipRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (l *LeaderboardMembershipRepositoryScylla) Add(membership *entities.LeaderboardMembership) error {
+	defer trackScyllaLatency(l.game, "add_leaderboard_membership")()
+	return l.scyllaClient.Query(`INSERT INTO leaderboard_membership (user_id,leaderboard,joined_at) VALUES (?,?,?)`, nil).
+		Bind(membership.UserId, membership.Leaderboard, time.UnixMilli(membership.JoinedAt)).
+		ExecRelease()
+}
+
+func (l *LeaderboardMembershipRepositoryScylla) Remove(userId string, leaderboard int) error {
+	defer trackScyllaLatency(l.game, "remove_leaderboard_membership")()
+	return l.scyllaClient.Query(`DELETE FROM leaderboard_membership WHERE user_id = ? AND leaderboard = ?`, nil).
+		Bind(userId, leaderboard).
+		ExecRelease()
+}
+
+func (l *LeaderboardMembershipRepositoryScylla) GetUserMemberships(userId string) ([]*entities.LeaderboardMembership, error) {
+	defer trackScyllaLatency(l.game, "get_user_leaderboard_memberships")()
+	var memberships []*entities.LeaderboardMembership
+	query := l.scyllaClient.Query(`SELECT user_id,leaderboard,joined_at FROM leaderboard_membership WHERE user_id = ?`, nil).Bind(userId)
+	if err := query.SelectRelease(&memberships); err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+// GetLeaderboardMemberIds scans the whole table, it's meant for rare backoffice operations only
+func (l *LeaderboardMembershipRepositoryScylla) GetLeaderboardMemberIds(leaderboard int) ([]string, error) {
+	defer trackScyllaLatency(l.game, "get_leaderboard_member_ids")()
+	var userIds []string
+	query := l.scyllaClient.Query(`SELECT user_id FROM leaderboard_membership WHERE leaderboard = ? ALLOW FILTERING`, nil).Bind(leaderboard)
+	if err := query.SelectRelease(&userIds); err != nil {
+		return nil, err
+	}
+	return userIds, nil
+}
+
+func (l *LeaderboardMembershipRepositoryScylla) DeleteUserMemberships(userId string) error {
+	defer trackScyllaLatency(l.game, "delete_user_leaderboard_memberships")()
+	return l.scyllaClient.Query(`DELETE FROM leaderboard_membership WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
+
+func (l *LeaderboardMembershipRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(l.game, "purge_leaderboard_memberships")()
+	return l.scyllaClient.Query(`TRUNCATE leaderboard_membership`, nil).Exec()
+}
@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+// LeaderboardRegistryRepository keeps leaderboard metadata, the scores themselves stay in LeaderboardRepo
+type LeaderboardRegistryRepository interface {
+	// Create registers the leaderboard, false if it's already registered
+	Create(info *entities.LeaderboardInfo) (bool, error)
+	// Update changes the name, description, aggregation and visibility, false if the leaderboard isn't registered
+	Update(info *entities.LeaderboardInfo) (bool, error)
+	// Close sets the closing time of an open leaderboard, false if it's already closed.
+	// The leaderboard must be registered, a condition on a missing row would create it.
+	Close(leaderboard int, closedAt int64) (bool, error)
+	Get(leaderboard int) (*entities.LeaderboardInfo, error)
+	// GetAll scans the whole registry, which is small
+	GetAll() ([]*entities.LeaderboardInfo, error)
+	Delete(leaderboard int) error
+	Purge() error
+}
+
+type LeaderboardRegistryRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewLeaderboardRegistryRepository(session *gocqlx.Session, game *game_config.Game) LeaderboardRegistryRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS leaderboard (
+    	id int,
+    	name text,
+    	description text,
+    	aggregation text,
+    	visibility text,
+    	created_at timestamp,
+    	closed_at timestamp,
+    	PRIMARY KEY (id))`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &LeaderboardRegistryRepositoryScylla{scyllaClient: session, game: game}
+}
+
+const leaderboardInfoColumns = `id,name,description,aggregation,visibility,created_at,closed_at`
+
+func (l *LeaderboardRegistryRepositoryScylla) Create(info *entities.LeaderboardInfo) (bool, error) {
+	defer trackScyllaLatency(l.game, "create_leaderboard_info")()
+	existing := &entities.LeaderboardInfo{}
+	return l.scyllaClient.Query(`INSERT INTO leaderboard (`+leaderboardInfoColumns+`) VALUES (?,?,?,?,?,?,null) IF NOT EXISTS`, nil).
+		Bind(info.Id, info.Name, info.Description, info.Aggregation, info.Visibility, time.UnixMilli(info.CreatedAt)).
+		ScanCAS(&existing.Id, &existing.Aggregation, &existing.ClosedAt, &existing.CreatedAt, &existing.Description, &existing.Name, &existing.Visibility)
+}
+
+func (l *LeaderboardRegistryRepositoryScylla) Update(info *entities.LeaderboardInfo) (bool, error) {
+	defer trackScyllaLatency(l.game, "update_leaderboard_info")()
+	return l.scyllaClient.Query(`UPDATE leaderboard SET name = ?, description = ?, aggregation = ?, visibility = ? WHERE id = ? IF EXISTS`, nil).
+		Bind(info.Name, info.Description, info.Aggregation, info.Visibility, info.Id).
+		ScanCAS()
+}
+
+func (l *LeaderboardRegistryRepositoryScylla) Close(leaderboard int, closedAt int64) (bool, error) {
+	defer trackScyllaLatency(l.game, "close_leaderboard")()
+	var existingClosedAt int64
+	return l.scyllaClient.Query(`UPDATE leaderboard SET closed_at = ? WHERE id = ? IF closed_at = null`, nil).
+		Bind(time.UnixMilli(closedAt), leaderboard).
+		ScanCAS(&existingClosedAt)
+}
+
+func (l *LeaderboardRegistryRepositoryScylla) Get(leaderboard int) (*entities.LeaderboardInfo, error) {
+	defer trackScyllaLatency(l.game, "get_leaderboard_info")()
+	info := &entities.LeaderboardInfo{}
+	if err := l.scyllaClient.Query(`SELECT `+leaderboardInfoColumns+` FROM leaderboard WHERE id = ?`, nil).Bind(leaderboard).Get(info); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	info.Registered = true
+	return info, nil
+}
+
+func (l *LeaderboardRegistryRepositoryScylla) GetAll() ([]*entities.LeaderboardInfo, error) {
+	defer trackScyllaLatency(l.game, "get_all_leaderboard_infos")()
+	var infos []*entities.LeaderboardInfo
+	if err := l.scyllaClient.Query(`SELECT `+leaderboardInfoColumns+` FROM leaderboard`, nil).SelectRelease(&infos); err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		info.Registered = true
+	}
+	return infos, nil
+}
+
+func (l *LeaderboardRegistryRepositoryScylla) Delete(leaderboard int) error {
+	defer trackScyllaLatency(l.game, "delete_leaderboard_info")()
+	return l.scyllaClient.Query(`DELETE FROM leaderboard WHERE id = ?`, nil).Bind(leaderboard).ExecRelease()
+}
+
+func (l *LeaderboardRegistryRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(l.game, "purge_leaderboard_infos")()
+	return l.scyllaClient.Query(`TRUNCATE leaderboard`, nil).Exec()
+}
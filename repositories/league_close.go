@@ -0,0 +1,185 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+// LeagueCloseRepository keeps the progress of league cycle closes and the moves planned for every user,
+// moves are partitioned by cycle and the leaderboard the user finished on.
+type LeagueCloseRepository interface {
+	// GetClose returns nil if the close of the cycle hasn't started
+	GetClose(cycle int) (*entities.LeagueCycleClose, error)
+	// StartClose claims the close of the cycle, false if another instance got there first
+	StartClose(cycle int, now int64) (bool, error)
+	// ClaimClose takes over, renews or releases the close attempt started at closingAt, false if it isn't
+	// the current attempt anymore. A released close has closingAt 0.
+	ClaimClose(cycle int, closingAt int64, now int64) (bool, error)
+	MarkPlanned(cycle int) error
+	MarkReset(cycle int, leaderboard int) error
+	MarkClosed(cycle int, closedAt int64) error
+	SaveMoves(moves []*entities.LeagueMove) error
+	GetMoves(cycle int, leaderboard int) ([]*entities.LeagueMove, error)
+	DeleteMoves(cycle int, leaderboard int) error
+	MarkMoveApplied(move *entities.LeagueMove) error
+	// DeleteMove drops a move that can't be applied, so a resumed close doesn't report it
+	DeleteMove(move *entities.LeagueMove) error
+	Purge() error
+}
+
+type LeagueCloseRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewLeagueCloseRepository(session *gocqlx.Session, game *game_config.Game) LeagueCloseRepository {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS league_cycle_close (
+    	cycle int,
+    	status text,
+    	closing_at timestamp,
+    	planned boolean,
+    	reset_leaderboards set<int>,
+    	closed_at timestamp,
+    	PRIMARY KEY (cycle))`,
+		`CREATE TABLE IF NOT EXISTS league_cycle_move (
+    	cycle int,
+    	from_leaderboard int,
+    	user_id uuid,
+    	closed_at timestamp,
+    	to_leaderboard int,
+    	from_tier text,
+    	to_tier text,
+    	outcome text,
+    	position int,
+    	score int,
+    	applied boolean,
+    	PRIMARY KEY ((cycle, from_leaderboard), user_id))`,
+	}
+	for _, query := range queries {
+		if err := session.Query(query, nil).Exec(); err != nil {
+			panic(err)
+		}
+	}
+	return &LeagueCloseRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (l *LeagueCloseRepositoryScylla) GetClose(cycle int) (*entities.LeagueCycleClose, error) {
+	defer trackScyllaLatency(l.game, "get_league_cycle_close")()
+	cycleClose := &entities.LeagueCycleClose{}
+	err := l.scyllaClient.Query(`SELECT cycle,status,closing_at,planned,reset_leaderboards,closed_at FROM league_cycle_close WHERE cycle = ?`, nil).
+		Bind(cycle).
+		Get(cycleClose)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cycleClose, nil
+}
+
+func (l *LeagueCloseRepositoryScylla) StartClose(cycle int, now int64) (bool, error) {
+	defer trackScyllaLatency(l.game, "start_league_cycle_close")()
+	existing := &entities.LeagueCycleClose{}
+	return l.scyllaClient.Query(`INSERT INTO league_cycle_close (cycle,status,closing_at,planned) VALUES (?,?,?,false) IF NOT EXISTS`, nil).
+		Bind(cycle, entities.LeagueCycleStatusClosing, time.UnixMilli(now)).
+		ScanCAS(&existing.Cycle, &existing.ClosedAt, &existing.ClosingAt, &existing.Planned, &existing.ResetLeaderboards, &existing.Status)
+}
+
+func (l *LeagueCloseRepositoryScylla) ClaimClose(cycle int, closingAt int64, now int64) (bool, error) {
+	defer trackScyllaLatency(l.game, "claim_league_cycle_close")()
+	var existingStatus string
+	var existingClosingAt time.Time
+	return l.scyllaClient.Query(`UPDATE league_cycle_close SET closing_at = ? WHERE cycle = ? IF status = ? AND closing_at = ?`, nil).
+		Bind(time.UnixMilli(now), cycle, entities.LeagueCycleStatusClosing, time.UnixMilli(closingAt)).
+		ScanCAS(&existingStatus, &existingClosingAt)
+}
+
+func (l *LeagueCloseRepositoryScylla) MarkPlanned(cycle int) error {
+	defer trackScyllaLatency(l.game, "mark_league_cycle_planned")()
+	return l.scyllaClient.Query(`UPDATE league_cycle_close SET planned = true WHERE cycle = ?`, nil).Bind(cycle).ExecRelease()
+}
+
+func (l *LeagueCloseRepositoryScylla) MarkReset(cycle int, leaderboard int) error {
+	defer trackScyllaLatency(l.game, "mark_league_leaderboard_reset")()
+	return l.scyllaClient.Query(`UPDATE league_cycle_close SET reset_leaderboards = reset_leaderboards + ? WHERE cycle = ?`, nil).
+		Bind([]int{leaderboard}, cycle).
+		ExecRelease()
+}
+
+func (l *LeagueCloseRepositoryScylla) MarkClosed(cycle int, closedAt int64) error {
+	defer trackScyllaLatency(l.game, "mark_league_cycle_closed")()
+	return l.scyllaClient.Query(`UPDATE league_cycle_close SET status = ?, closed_at = ? WHERE cycle = ?`, nil).
+		Bind(entities.LeagueCycleStatusClosed, time.UnixMilli(closedAt), cycle).
+		ExecRelease()
+}
+
+// SaveMoves writes moves one by one, they may span several partitions
+func (l *LeagueCloseRepositoryScylla) SaveMoves(moves []*entities.LeagueMove) error {
+	defer trackScyllaLatency(l.game, "save_league_moves")()
+	query := l.scyllaClient.Query(`INSERT INTO league_cycle_move (cycle,from_leaderboard,user_id,closed_at,to_leaderboard,from_tier,to_tier,outcome,position,score,applied) VALUES (?,?,?,?,?,?,?,?,?,?,?)`, nil)
+	defer query.Release()
+	for _, move := range moves {
+		err := query.Bind(
+			move.Cycle,
+			move.FromLeaderboard,
+			move.UserId,
+			time.UnixMilli(move.ClosedAt),
+			move.ToLeaderboard,
+			move.FromTier,
+			move.ToTier,
+			move.Outcome,
+			move.Position,
+			move.Score,
+			move.Applied,
+		).Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LeagueCloseRepositoryScylla) GetMoves(cycle int, leaderboard int) ([]*entities.LeagueMove, error) {
+	defer trackScyllaLatency(l.game, "get_league_moves")()
+	var moves []*entities.LeagueMove
+	query := l.scyllaClient.Query(`SELECT cycle,from_leaderboard,user_id,closed_at,to_leaderboard,from_tier,to_tier,outcome,position,score,applied FROM league_cycle_move WHERE cycle = ? AND from_leaderboard = ?`, nil).
+		Bind(cycle, leaderboard)
+	if err := query.SelectRelease(&moves); err != nil {
+		return nil, err
+	}
+	return moves, nil
+}
+
+func (l *LeagueCloseRepositoryScylla) DeleteMoves(cycle int, leaderboard int) error {
+	defer trackScyllaLatency(l.game, "delete_league_moves")()
+	return l.scyllaClient.Query(`DELETE FROM league_cycle_move WHERE cycle = ? AND from_leaderboard = ?`, nil).
+		Bind(cycle, leaderboard).
+		ExecRelease()
+}
+
+func (l *LeagueCloseRepositoryScylla) MarkMoveApplied(move *entities.LeagueMove) error {
+	defer trackScyllaLatency(l.game, "mark_league_move_applied")()
+	return l.scyllaClient.Query(`UPDATE league_cycle_move SET applied = true WHERE cycle = ? AND from_leaderboard = ? AND user_id = ?`, nil).
+		Bind(move.Cycle, move.FromLeaderboard, move.UserId).
+		ExecRelease()
+}
+
+func (l *LeagueCloseRepositoryScylla) DeleteMove(move *entities.LeagueMove) error {
+	defer trackScyllaLatency(l.game, "delete_league_move")()
+	return l.scyllaClient.Query(`DELETE FROM league_cycle_move WHERE cycle = ? AND from_leaderboard = ? AND user_id = ?`, nil).
+		Bind(move.Cycle, move.FromLeaderboard, move.UserId).
+		ExecRelease()
+}
+
+func (l *LeagueCloseRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(l.game, "purge_league_closes")()
+	if err := l.scyllaClient.Query(`TRUNCATE league_cycle_close`, nil).Exec(); err != nil {
+		return err
+	}
+	return l.scyllaClient.Query(`TRUNCATE league_cycle_move`, nil).Exec()
+}
@@ -0,0 +1,44 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+)
+
+type LeagueCycleRepository interface {
+	// GetLastClosedCycle returns 0 if no cycle was closed yet
+	GetLastClosedCycle() (int, error)
+	SetLastClosedCycle(cycle int) error
+	Purge() error
+}
+
+type leagueCycleRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewLeagueCycleRepository(c rueidis.Client, game *game_config.Game) LeagueCycleRepository {
+	return &leagueCycleRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (l *leagueCycleRepositoryRedis) lastClosedCycleKey() string {
+	return l.ns + "leagues:last_closed_cycle"
+}
+
+func (l *leagueCycleRepositoryRedis) GetLastClosedCycle() (int, error) {
+	cycle, err := l.c.Do(context.Background(), l.c.B().Get().Key(l.lastClosedCycleKey()).Build()).AsInt64()
+	if rueidis.IsRedisNil(err) {
+		return 0, nil
+	}
+	return int(cycle), err
+}
+
+func (l *leagueCycleRepositoryRedis) SetLastClosedCycle(cycle int) error {
+	return l.c.Do(context.Background(), l.c.B().Set().Key(l.lastClosedCycleKey()).Value(fmt.Sprint(cycle)).Build()).Error()
+}
+
+func (l *leagueCycleRepositoryRedis) Purge() error {
+	return deleteKeysByPattern(l.c, l.ns+"leagues:*")
+}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+type LeagueResultRepository interface {
+	Save(result *entities.LeagueResult) error
+	GetUserResults(userId string, limit int) ([]*entities.LeagueResult, error)
+	DeleteUserResults(userId string) error
+	Purge() error
+}
+
+type LeagueResultRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewLeagueResultRepository(session *gocqlx.Session, game *game_config.Game) LeagueResultRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS league_result (
+    	user_id uuid,
+    	cycle int,
+    	closed_at timestamp,
+    	from_leaderboard int,
+    	to_leaderboard int,
+    	from_tier text,
+    	to_tier text,
+    	outcome text,
+    	position int,
+    	score int,
+    	PRIMARY KEY (user_id, cycle))
+    	WITH CLUSTERING ORDER BY (cycle DESC)`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &LeagueResultRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (l *LeagueResultRepositoryScylla) Save(result *entities.LeagueResult) error {
+	defer trackScyllaLatency(l.game, "save_league_result")()
+	return l.scyllaClient.Query(
+		`INSERT INTO league_result (user_id,cycle,closed_at,from_leaderboard,to_leaderboard,from_tier,to_tier,outcome,position,score) VALUES (?,?,?,?,?,?,?,?,?,?)`, nil).
+		Bind(
+			result.UserId,
+			result.Cycle,
+			time.UnixMilli(result.ClosedAt),
+			result.FromLeaderboard,
+			result.ToLeaderboard,
+			result.FromTier,
+			result.ToTier,
+			result.Outcome,
+			result.Position,
+			result.Score,
+		).
+		ExecRelease()
+}
+
+// GetUserResults returns the user's latest results first
+func (l *LeagueResultRepositoryScylla) GetUserResults(userId string, limit int) ([]*entities.LeagueResult, error) {
+	defer trackScyllaLatency(l.game, "get_user_league_results")()
+	var results []*entities.LeagueResult
+	query := l.scyllaClient.Query(
+		`SELECT user_id,cycle,closed_at,from_leaderboard,to_leaderboard,from_tier,to_tier,outcome,position,score FROM league_result WHERE user_id = ? LIMIT ?`, nil).
+		Bind(userId, limit)
+	if err := query.SelectRelease(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (l *LeagueResultRepositoryScylla) DeleteUserResults(userId string) error {
+	defer trackScyllaLatency(l.game, "delete_user_league_results")()
+	return l.scyllaClient.Query(`DELETE FROM league_result WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
+
+func (l *LeagueResultRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(l.game, "purge_league_results")()
+	return l.scyllaClient.Query(`TRUNCATE league_result`, nil).Exec()
+}
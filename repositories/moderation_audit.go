@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+type ModerationAuditRepository interface {
+	Record(entry *entities.ModerationAuditEntry) error
+	GetUserAudit(userId string) ([]*entities.ModerationAuditEntry, error)
+	DeleteUserAudit(userId string) error
+}
+
+type ModerationAuditRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewModerationAuditRepository(session *gocqlx.Session, game *game_config.Game) ModerationAuditRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS moderation_audit (
+    	user_id uuid,
+    	id timeuuid,
+    	action text,
+    	reason text,
+    	details text,
+    	created_at timestamp,
+    	PRIMARY KEY (user_id, id))
+    	WITH CLUSTERING ORDER BY (id DESC)`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &ModerationAuditRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (m *ModerationAuditRepositoryScylla) Record(entry *entities.ModerationAuditEntry) error {
+	defer trackScyllaLatency(m.game, "record_moderation_audit")()
+	createdAt := time.UnixMilli(entry.CreatedAt)
+	return m.scyllaClient.Query(
+		`INSERT INTO moderation_audit (user_id,id,action,reason,details,created_at) VALUES (?,?,?,?,?,?)`, nil).
+		Bind(entry.UserId, gocql.UUIDFromTime(createdAt), entry.Action, entry.Reason, entry.Details, createdAt).
+		ExecRelease()
+}
+
+func (m *ModerationAuditRepositoryScylla) GetUserAudit(userId string) ([]*entities.ModerationAuditEntry, error) {
+	defer trackScyllaLatency(m.game, "get_user_moderation_audit")()
+	var entries []*entities.ModerationAuditEntry
+	q := m.scyllaClient.Query(`SELECT user_id,action,reason,details,created_at FROM moderation_audit WHERE user_id = ?`, nil).Bind(userId)
+	if err := q.SelectRelease(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (m *ModerationAuditRepositoryScylla) DeleteUserAudit(userId string) error {
+	defer trackScyllaLatency(m.game, "delete_user_moderation_audit")()
+	return m.scyllaClient.Query(`DELETE FROM moderation_audit WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
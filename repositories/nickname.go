@@ -0,0 +1,73 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/game_config"
+)
+
+// NicknameRepository keeps the nickname to user lookup that makes nicknames unique.
+// Nicknames are stored normalized by the caller, so uniqueness is as case-insensitive as the normalization.
+type NicknameRepository interface {
+	// Claim reserves the nickname for the user, false if it belongs to someone else
+	Claim(nickname string, userId string) (bool, error)
+	// Release frees the nickname if it's still held by the user
+	Release(nickname string, userId string) error
+	GetUserId(nickname string) (string, error)
+	Purge() error
+}
+
+type NicknameRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewNicknameRepository(session *gocqlx.Session, game *game_config.Game) NicknameRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS user_nickname (
+    	nickname text,
+    	user_id uuid,
+    	PRIMARY KEY (nickname))`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &NicknameRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (n *NicknameRepositoryScylla) Claim(nickname string, userId string) (bool, error) {
+	defer trackScyllaLatency(n.game, "claim_nickname")()
+	var existingNickname string
+	var existingUserId gocql.UUID
+	applied, err := n.scyllaClient.Query(`INSERT INTO user_nickname (nickname, user_id) VALUES (?, ?) IF NOT EXISTS`, nil).
+		Bind(nickname, userId).
+		ScanCAS(&existingNickname, &existingUserId)
+	if err != nil {
+		return false, err
+	}
+	return applied || existingUserId.String() == userId, nil
+}
+
+func (n *NicknameRepositoryScylla) Release(nickname string, userId string) error {
+	defer trackScyllaLatency(n.game, "release_nickname")()
+	var existingUserId gocql.UUID
+	_, err := n.scyllaClient.Query(`DELETE FROM user_nickname WHERE nickname = ? IF user_id = ?`, nil).
+		Bind(nickname, userId).
+		ScanCAS(&existingUserId)
+	return err
+}
+
+func (n *NicknameRepositoryScylla) GetUserId(nickname string) (string, error) {
+	defer trackScyllaLatency(n.game, "get_nickname_user_id")()
+	var userId gocql.UUID
+	if err := n.scyllaClient.Query(`SELECT user_id FROM user_nickname WHERE nickname = ?`, nil).Bind(nickname).Get(&userId); err != nil {
+		if err == gocql.ErrNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return userId.String(), nil
+}
+
+func (n *NicknameRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(n.game, "purge_nicknames")()
+	return n.scyllaClient.Query(`TRUNCATE user_nickname`, nil).Exec()
+}
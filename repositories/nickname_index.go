@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"context"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"strings"
+)
+
+const nicknameIndexSeparator = "\x00"
+
+// NicknameIndexRepository is a lexicographically sorted set of normalized nicknames used for prefix search.
+// Members are "nickname\x00userId", so equal prefixes stay adjacent and every member maps back to its user.
+type NicknameIndexRepository interface {
+	Add(nickname string, userId string) error
+	Remove(nickname string, userId string) error
+	SearchPrefix(prefix string, offset int, limit int) ([]string, error)
+	Purge() error
+}
+
+type nicknameIndexRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewNicknameIndexRepository(c rueidis.Client, game *game_config.Game) NicknameIndexRepository {
+	return &nicknameIndexRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (n *nicknameIndexRepositoryRedis) key() string {
+	return n.ns + "nicknames:index"
+}
+
+func (n *nicknameIndexRepositoryRedis) Add(nickname string, userId string) error {
+	return n.c.Do(context.Background(), n.c.B().Zadd().Key(n.key()).ScoreMember().ScoreMember(0, nickname+nicknameIndexSeparator+userId).Build()).Error()
+}
+
+func (n *nicknameIndexRepositoryRedis) Remove(nickname string, userId string) error {
+	return n.c.Do(context.Background(), n.c.B().Zrem().Key(n.key()).Member(nickname+nicknameIndexSeparator+userId).Build()).Error()
+}
+
+// SearchPrefix returns ids of users whose nickname starts with the prefix, ordered by nickname
+func (n *nicknameIndexRepositoryRedis) SearchPrefix(prefix string, offset int, limit int) ([]string, error) {
+	members, err := n.c.Do(context.Background(), n.c.B().Zrange().Key(n.key()).Min("["+prefix).Max("["+prefix+"\xff").Bylex().Limit(int64(offset), int64(limit)).Build()).AsStrSlice()
+	if err != nil {
+		return nil, err
+	}
+	userIds := make([]string, 0, len(members))
+	for _, member := range members {
+		if i := strings.LastIndex(member, nicknameIndexSeparator); i >= 0 {
+			userIds = append(userIds, member[i+1:])
+		}
+	}
+	return userIds, nil
+}
+
+func (n *nicknameIndexRepositoryRedis) Purge() error {
+	return n.c.Do(context.Background(), n.c.B().Del().Key(n.key()).Build()).Error()
+}
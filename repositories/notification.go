@@ -0,0 +1,43 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+)
+
+// NotificationRepository fans notifications out to the instance holding the user's stream over Redis pub/sub,
+// nothing is stored so notifications sent while the user is offline are lost
+type NotificationRepository interface {
+	Publish(userId string, payload []byte) error
+	// Subscribe calls fn for every notification published to the user until the context is done
+	Subscribe(ctx context.Context, userId string, fn func(payload []byte)) error
+}
+
+type notificationRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewNotificationRepository(c rueidis.Client, game *game_config.Game) NotificationRepository {
+	return &notificationRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (n *notificationRepositoryRedis) channel(userId string) string {
+	return n.ns + fmt.Sprintf("notifications:{%s}", userId)
+}
+
+func (n *notificationRepositoryRedis) Publish(userId string, payload []byte) error {
+	return n.c.Do(context.Background(), n.c.B().Publish().Channel(n.channel(userId)).Message(rueidis.BinaryString(payload)).Build()).Error()
+}
+
+func (n *notificationRepositoryRedis) Subscribe(ctx context.Context, userId string, fn func(payload []byte)) error {
+	err := n.c.Receive(ctx, n.c.B().Subscribe().Channel(n.channel(userId)).Build(), func(msg rueidis.PubSubMessage) {
+		fn([]byte(msg.Message))
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
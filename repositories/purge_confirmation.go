@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+type PurgeConfirmationRepository interface {
+	Save(token string, target *entities.PurgeTarget, ttl time.Duration) error
+	// Take returns the target the token was issued for and invalidates the token, nil if it's unknown or expired
+	Take(token string) (*entities.PurgeTarget, error)
+}
+
+type purgeConfirmationRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewPurgeConfirmationRepository(c rueidis.Client, game *game_config.Game) PurgeConfirmationRepository {
+	return &purgeConfirmationRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (p *purgeConfirmationRepositoryRedis) key(token string) string {
+	return p.ns + fmt.Sprintf("purge_confirmation:{%s}", token)
+}
+
+func (p *purgeConfirmationRepositoryRedis) Save(token string, target *entities.PurgeTarget, ttl time.Duration) error {
+	bytes, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	return p.c.Do(context.Background(), p.c.B().Set().Key(p.key(token)).Value(string(bytes)).Px(ttl).Build()).Error()
+}
+
+func (p *purgeConfirmationRepositoryRedis) Take(token string) (*entities.PurgeTarget, error) {
+	bytes, err := p.c.Do(context.Background(), p.c.B().Getdel().Key(p.key(token)).Build()).AsBytes()
+	if err != nil {
+		if rueidis.IsRedisNil(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	target := &entities.PurgeTarget{}
+	if err := json.Unmarshal(bytes, target); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
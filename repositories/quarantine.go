@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+type QuarantineRepository interface {
+	Save(action *entities.QuarantinedAction) error
+	GetUserQuarantine(userId string) ([]*entities.QuarantinedAction, error)
+	DeleteUserQuarantine(userId string) error
+}
+
+type QuarantineRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewQuarantineRepository(session *gocqlx.Session, game *game_config.Game) QuarantineRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS quarantined_action (
+    	user_id uuid,
+    	id timeuuid,
+    	quarantined_at timestamp,
+    	action text,
+    	action_timestamp double,
+    	received_at timestamp,
+    	leaderboard_id int,
+    	stage text,
+    	rule text,
+    	reason text,
+    	PRIMARY KEY (user_id, id))
+    	WITH CLUSTERING ORDER BY (id DESC)`, nil).Exec()
+	if err != nil {
+		panic(err)
+	}
+	return &QuarantineRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (q *QuarantineRepositoryScylla) Save(action *entities.QuarantinedAction) error {
+	defer trackScyllaLatency(q.game, "save_quarantined_action")()
+	quarantinedAt := time.UnixMilli(action.QuarantinedAt)
+	return q.scyllaClient.Query(
+		`INSERT INTO quarantined_action (user_id,id,quarantined_at,action,action_timestamp,received_at,leaderboard_id,stage,rule,reason) VALUES (?,?,?,?,?,?,?,?,?,?)`, nil).
+		Bind(
+			action.UserId,
+			gocql.UUIDFromTime(quarantinedAt),
+			quarantinedAt,
+			action.Action,
+			action.ActionTimestamp,
+			time.UnixMilli(action.ReceivedAt),
+			action.LeaderboardId,
+			action.Stage,
+			action.Rule,
+			action.Reason,
+		).
+		ExecRelease()
+}
+
+func (q *QuarantineRepositoryScylla) GetUserQuarantine(userId string) ([]*entities.QuarantinedAction, error) {
+	defer trackScyllaLatency(q.game, "get_user_quarantine")()
+	var actions []*entities.QuarantinedAction
+	query := q.scyllaClient.Query(
+		`SELECT user_id,quarantined_at,action,action_timestamp,received_at,leaderboard_id,stage,rule,reason FROM quarantined_action WHERE user_id = ?`, nil).
+		Bind(userId)
+	if err := query.SelectRelease(&actions); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+func (q *QuarantineRepositoryScylla) DeleteUserQuarantine(userId string) error {
+	defer trackScyllaLatency(q.game, "delete_user_quarantine")()
+	return q.scyllaClient.Query(`DELETE FROM quarantined_action WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
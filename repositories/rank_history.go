@@ -0,0 +1,144 @@
+package repositories
+
+import (
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"slices"
+	"time"
+)
+
+// RankHistoryRepository keeps periodic snapshots of users' scores and positions, partitioned by user and UTC day
+// ("2006-01-02") so a timeline is read from a handful of partitions. Snapshots expire after the given retention.
+type RankHistoryRepository interface {
+	// ClaimSnapshot makes sure a single instance takes the snapshot of the leaderboard at the given time
+	ClaimSnapshot(takenAt int64, leaderboard int, ttl time.Duration) (bool, error)
+	// ReleaseSnapshot gives up the claim of a snapshot that failed, so it can be taken again
+	ReleaseSnapshot(takenAt int64, leaderboard int) error
+	SaveSnapshots(snapshots []*entities.RankSnapshot, retention time.Duration) error
+	// GetUserSnapshots returns the snapshots of the given days taken within [from, to], oldest first
+	GetUserSnapshots(userId string, days []string, from int64, to int64) ([]*entities.RankSnapshot, error)
+	// GetUsersSnapshots returns the snapshots of all the users of the given days taken within [from, to], in no
+	// particular order. Every user and day is a partition, callers keep their product within
+	// RankHistoryMaxPartitionsPerQuery.
+	GetUsersSnapshots(userIds []string, days []string, from int64, to int64) ([]*entities.RankSnapshot, error)
+	// DeleteUserSnapshots removes the user's snapshots of the given days, any number of them
+	DeleteUserSnapshots(userId string, days []string) error
+	Purge() error
+}
+
+// RankHistoryMaxPartitionsPerQuery stays within Scylla's default max_partition_key_restrictions_per_query
+const RankHistoryMaxPartitionsPerQuery = 100
+
+type RankHistoryRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewRankHistoryRepository(session *gocqlx.Session, game *game_config.Game) RankHistoryRepository {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS rank_history (
+    	user_id uuid,
+    	day text,
+    	taken_at timestamp,
+    	leaderboard int,
+    	score int,
+    	position int,
+    	PRIMARY KEY ((user_id, day), taken_at, leaderboard))`,
+		`CREATE TABLE IF NOT EXISTS rank_snapshot_run (
+    	taken_at timestamp,
+    	leaderboard int,
+    	PRIMARY KEY ((taken_at, leaderboard)))`,
+	}
+	for _, query := range queries {
+		if err := session.Query(query, nil).Exec(); err != nil {
+			panic(err)
+		}
+	}
+	return &RankHistoryRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func rankHistoryDay(takenAt int64) string {
+	return time.UnixMilli(takenAt).UTC().Format("2006-01-02")
+}
+
+func (r *RankHistoryRepositoryScylla) ClaimSnapshot(takenAt int64, leaderboard int, ttl time.Duration) (bool, error) {
+	defer trackScyllaLatency(r.game, "claim_rank_snapshot")()
+	var existingTakenAt time.Time
+	var existingLeaderboard int
+	return r.scyllaClient.Query(`INSERT INTO rank_snapshot_run (taken_at,leaderboard) VALUES (?,?) IF NOT EXISTS USING TTL ?`, nil).
+		Bind(time.UnixMilli(takenAt), leaderboard, int(ttl.Seconds())).
+		ScanCAS(&existingTakenAt, &existingLeaderboard)
+}
+
+func (r *RankHistoryRepositoryScylla) ReleaseSnapshot(takenAt int64, leaderboard int) error {
+	defer trackScyllaLatency(r.game, "release_rank_snapshot")()
+	_, err := r.scyllaClient.Query(`DELETE FROM rank_snapshot_run WHERE taken_at = ? AND leaderboard = ? IF EXISTS`, nil).
+		Bind(time.UnixMilli(takenAt), leaderboard).
+		ScanCAS()
+	return err
+}
+
+// SaveSnapshots writes snapshots one by one, every user is a partition of its own so batching wouldn't help
+func (r *RankHistoryRepositoryScylla) SaveSnapshots(snapshots []*entities.RankSnapshot, retention time.Duration) error {
+	defer trackScyllaLatency(r.game, "save_rank_snapshots")()
+	query := r.scyllaClient.Query(`INSERT INTO rank_history (user_id,day,taken_at,leaderboard,score,position) VALUES (?,?,?,?,?,?) USING TTL ?`, nil)
+	defer query.Release()
+	for _, snapshot := range snapshots {
+		err := query.Bind(
+			snapshot.UserId,
+			rankHistoryDay(snapshot.TakenAt),
+			time.UnixMilli(snapshot.TakenAt),
+			snapshot.Leaderboard,
+			snapshot.Score,
+			snapshot.Position,
+			int(retention.Seconds()),
+		).Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RankHistoryRepositoryScylla) GetUserSnapshots(userId string, days []string, from int64, to int64) ([]*entities.RankSnapshot, error) {
+	defer trackScyllaLatency(r.game, "get_user_rank_snapshots")()
+	var snapshots []*entities.RankSnapshot
+	query := r.scyllaClient.Query(`SELECT user_id,leaderboard,taken_at,score,position FROM rank_history WHERE user_id = ? AND day IN ? AND taken_at >= ? AND taken_at <= ?`, nil).
+		Bind(userId, days, time.UnixMilli(from), time.UnixMilli(to))
+	if err := query.SelectRelease(&snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+func (r *RankHistoryRepositoryScylla) GetUsersSnapshots(userIds []string, days []string, from int64, to int64) ([]*entities.RankSnapshot, error) {
+	defer trackScyllaLatency(r.game, "get_users_rank_snapshots")()
+	var snapshots []*entities.RankSnapshot
+	query := r.scyllaClient.Query(`SELECT user_id,leaderboard,taken_at,score,position FROM rank_history WHERE user_id IN ? AND day IN ? AND taken_at >= ? AND taken_at <= ?`, nil).
+		Bind(userIds, days, time.UnixMilli(from), time.UnixMilli(to))
+	if err := query.SelectRelease(&snapshots); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+func (r *RankHistoryRepositoryScylla) DeleteUserSnapshots(userId string, days []string) error {
+	defer trackScyllaLatency(r.game, "delete_user_rank_snapshots")()
+	query := r.scyllaClient.Query(`DELETE FROM rank_history WHERE user_id = ? AND day IN ?`, nil)
+	defer query.Release()
+	for chunk := range slices.Chunk(days, RankHistoryMaxPartitionsPerQuery) {
+		if err := query.Bind(userId, chunk).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RankHistoryRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(r.game, "purge_rank_history")()
+	if err := r.scyllaClient.Query(`TRUNCATE rank_history`, nil).Exec(); err != nil {
+		return err
+	}
+	return r.scyllaClient.Query(`TRUNCATE rank_snapshot_run`, nil).Exec()
+}
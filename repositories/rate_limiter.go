@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+type RateLimiterRepository interface {
+	// Hit registers a request for the key and reports whether it fits into the sliding window.
+	// When it doesn't, the returned duration is how long until the oldest request leaves the window.
+	Hit(key string, limit int, window time.Duration) (bool, time.Duration, error)
+}
+
+type rateLimiterRepositoryRedis struct {
+	c  rueidis.Client
+	ns string
+}
+
+func NewRateLimiterRepository(c rueidis.Client, game *game_config.Game) RateLimiterRepository {
+	return &rateLimiterRepositoryRedis{c: c, ns: keyPrefix(game)}
+}
+
+func (r *rateLimiterRepositoryRedis) key(key string) string {
+	return r.ns + fmt.Sprintf("rate_limit:{%s}", key)
+}
+
+func (r *rateLimiterRepositoryRedis) Hit(key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	now := time.Now()
+	redisKey := r.key(key)
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.Itoa(rand.IntN(1_000_000))
+	windowStart := strconv.FormatInt(now.Add(-window).UnixMilli(), 10)
+
+	res := r.c.DoMulti(
+		context.Background(),
+		r.c.B().Multi().Build(),
+		r.c.B().Zremrangebyscore().Key(redisKey).Min("-inf").Max(windowStart).Build(),
+		r.c.B().Zcard().Key(redisKey).Build(),
+		r.c.B().Zadd().Key(redisKey).ScoreMember().ScoreMember(float64(now.UnixMilli()), member).Build(),
+		r.c.B().Zrange().Key(redisKey).Min("0").Max("0").Withscores().Build(),
+		r.c.B().Pexpire().Key(redisKey).Milliseconds(window.Milliseconds()).Build(),
+		r.c.B().Exec().Build(),
+	)
+	for _, res := range res {
+		if res.Error() != nil {
+			return false, 0, res.Error()
+		}
+	}
+	execResults, err := res[6].ToArray()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(execResults) < 4 {
+		return false, 0, fmt.Errorf("unexpected number of results from transaction")
+	}
+	count, err := execResults[1].AsInt64()
+	if err != nil {
+		return false, 0, err
+	}
+	if int(count) < limit {
+		return true, 0, nil
+	}
+
+	// rejected requests must not occupy the window, otherwise a flooding client would never get through
+	if err := r.c.Do(context.Background(), r.c.B().Zrem().Key(redisKey).Member(member).Build()).Error(); err != nil {
+		return false, 0, err
+	}
+	oldest, err := execResults[3].AsZScores()
+	if err != nil {
+		return false, 0, err
+	}
+	retryAfter := window
+	if len(oldest) > 0 {
+		retryAfter = time.UnixMilli(int64(oldest[0].Score)).Add(window).Sub(now)
+	}
+	return false, retryAfter, nil
+}
@@ -0,0 +1,54 @@
+package repositories
+
+import (
+	"context"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+)
+
+// keyPrefix namespaces Redis keys of the game. Keys of the default game keep the names they had before games
+// were introduced, the prefix of other games starts with a segment none of them use, so patterns don't overlap.
+func keyPrefix(game *game_config.Game) string {
+	if game.Default {
+		return ""
+	}
+	return "game:" + game.Id + ":"
+}
+
+// deleteKeysByPattern scans every node for keys matching the pattern and deletes them one by one,
+// keys of a cluster live in different slots so they can't be deleted with a single command
+func deleteKeysByPattern(c rueidis.Client, pattern string) error {
+	for _, node := range c.Nodes() {
+		var cursor uint64
+		for {
+			entry, err := node.Do(context.Background(), c.B().Scan().Cursor(cursor).Match(pattern).Count(1000).Build()).AsScanEntry()
+			if err != nil {
+				return err
+			}
+			if err := deleteKeys(c, entry.Elements); err != nil {
+				return err
+			}
+			cursor = entry.Cursor
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func deleteKeys(c rueidis.Client, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	cmds := make(rueidis.Commands, 0, len(keys))
+	for _, key := range keys {
+		cmds = append(cmds, c.B().Del().Key(key).Build())
+	}
+	for _, r := range c.DoMulti(context.Background(), cmds...) {
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package repositories
+
+import (
+	"encoding/json"
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+// TournamentRepository is the tournament registry. Registrations are stored per user and per tournament,
+// final standings are frozen per tournament by position.
+type TournamentRepository interface {
+	Create(tournament *entities.Tournament) error
+	// Update changes the name, window and prizes of an open tournament, false if it's no longer open
+	Update(tournament *entities.Tournament) (bool, error)
+	Get(tournamentId string) (*entities.Tournament, error)
+	// GetAll scans the whole registry, which is small
+	GetAll() ([]*entities.Tournament, error)
+	Delete(tournamentId string) error
+	// ClaimClose moves the tournament to closing, from open if closingAt is 0, otherwise from the closing
+	// attempt started at closingAt. False if another instance got there first.
+	ClaimClose(tournamentId string, closingAt int64, now int64) (bool, error)
+	MarkClosed(tournamentId string, closedAt int64) error
+	Register(registration *entities.TournamentRegistration) error
+	Unregister(tournamentId string, userId string) error
+	GetUserRegistrations(userId string) ([]*entities.TournamentRegistration, error)
+	GetParticipantIds(tournamentId string) ([]string, error)
+	SaveStanding(standing *entities.TournamentStanding) error
+	GetStandings(tournamentId string, limit int) ([]*entities.TournamentStanding, error)
+	DeleteUserRegistrations(userId string) error
+	Purge() error
+}
+
+type TournamentRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewTournamentRepository(session *gocqlx.Session, game *game_config.Game) TournamentRepository {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS tournament (
+    	id uuid,
+    	name text,
+    	starts_at timestamp,
+    	ends_at timestamp,
+    	prizes text,
+    	status text,
+    	closing_at timestamp,
+    	closed_at timestamp,
+    	created_at timestamp,
+    	PRIMARY KEY (id))`,
+		`CREATE TABLE IF NOT EXISTS tournament_registration (
+    	user_id uuid,
+    	tournament_id uuid,
+    	registered_at timestamp,
+    	position int,
+    	score int,
+    	reward_id text,
+    	PRIMARY KEY (user_id, tournament_id))`,
+		`CREATE TABLE IF NOT EXISTS tournament_participant (
+    	tournament_id uuid,
+    	user_id uuid,
+    	PRIMARY KEY (tournament_id, user_id))`,
+		`CREATE TABLE IF NOT EXISTS tournament_standing (
+    	tournament_id uuid,
+    	position int,
+    	user_id uuid,
+    	score int,
+    	reward_id text,
+    	PRIMARY KEY (tournament_id, position))`,
+	}
+	for _, query := range queries {
+		if err := session.Query(query, nil).Exec(); err != nil {
+			panic(err)
+		}
+	}
+	return &TournamentRepositoryScylla{scyllaClient: session, game: game}
+}
+
+// tournamentRow is the tournament as stored, with prizes as json
+type tournamentRow struct {
+	Id        string
+	Name      string
+	StartsAt  int64
+	EndsAt    int64
+	Prizes    string
+	Status    string
+	ClosingAt int64
+	ClosedAt  int64
+	CreatedAt int64
+}
+
+func (r *tournamentRow) toEntity() (*entities.Tournament, error) {
+	tournament := &entities.Tournament{
+		Id:        r.Id,
+		Name:      r.Name,
+		StartsAt:  r.StartsAt,
+		EndsAt:    r.EndsAt,
+		Status:    r.Status,
+		ClosingAt: r.ClosingAt,
+		ClosedAt:  r.ClosedAt,
+		CreatedAt: r.CreatedAt,
+	}
+	if err := json.Unmarshal([]byte(r.Prizes), &tournament.Prizes); err != nil {
+		return nil, err
+	}
+	return tournament, nil
+}
+
+const tournamentColumns = `id,name,starts_at,ends_at,prizes,status,closing_at,closed_at,created_at`
+
+func (t *TournamentRepositoryScylla) Create(tournament *entities.Tournament) error {
+	defer trackScyllaLatency(t.game, "create_tournament")()
+	prizes, err := json.Marshal(tournament.Prizes)
+	if err != nil {
+		return err
+	}
+	return t.scyllaClient.Query(`INSERT INTO tournament (id,name,starts_at,ends_at,prizes,status,created_at) VALUES (?,?,?,?,?,?,?)`, nil).
+		Bind(
+			tournament.Id,
+			tournament.Name,
+			time.UnixMilli(tournament.StartsAt),
+			time.UnixMilli(tournament.EndsAt),
+			string(prizes),
+			tournament.Status,
+			time.UnixMilli(tournament.CreatedAt),
+		).
+		ExecRelease()
+}
+
+func (t *TournamentRepositoryScylla) Update(tournament *entities.Tournament) (bool, error) {
+	defer trackScyllaLatency(t.game, "update_tournament")()
+	prizes, err := json.Marshal(tournament.Prizes)
+	if err != nil {
+		return false, err
+	}
+	var existingStatus string
+	return t.scyllaClient.Query(`UPDATE tournament SET name = ?, starts_at = ?, ends_at = ?, prizes = ? WHERE id = ? IF status = ?`, nil).
+		Bind(
+			tournament.Name,
+			time.UnixMilli(tournament.StartsAt),
+			time.UnixMilli(tournament.EndsAt),
+			string(prizes),
+			tournament.Id,
+			entities.TournamentStatusOpen,
+		).
+		ScanCAS(&existingStatus)
+}
+
+func (t *TournamentRepositoryScylla) Get(tournamentId string) (*entities.Tournament, error) {
+	defer trackScyllaLatency(t.game, "get_tournament")()
+	row := &tournamentRow{}
+	if err := t.scyllaClient.Query(`SELECT `+tournamentColumns+` FROM tournament WHERE id = ?`, nil).Bind(tournamentId).Get(row); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return row.toEntity()
+}
+
+func (t *TournamentRepositoryScylla) GetAll() ([]*entities.Tournament, error) {
+	defer trackScyllaLatency(t.game, "get_all_tournaments")()
+	var rows []*tournamentRow
+	if err := t.scyllaClient.Query(`SELECT `+tournamentColumns+` FROM tournament`, nil).SelectRelease(&rows); err != nil {
+		return nil, err
+	}
+	tournaments := make([]*entities.Tournament, 0, len(rows))
+	for _, row := range rows {
+		tournament, err := row.toEntity()
+		if err != nil {
+			return nil, err
+		}
+		tournaments = append(tournaments, tournament)
+	}
+	return tournaments, nil
+}
+
+// Delete removes the tournament with its registrations and standings
+func (t *TournamentRepositoryScylla) Delete(tournamentId string) error {
+	userIds, err := t.GetParticipantIds(tournamentId)
+	if err != nil {
+		return err
+	}
+	defer trackScyllaLatency(t.game, "delete_tournament")()
+	for _, userId := range userIds {
+		err := t.scyllaClient.Query(`DELETE FROM tournament_registration WHERE user_id = ? AND tournament_id = ?`, nil).
+			Bind(userId, tournamentId).
+			ExecRelease()
+		if err != nil {
+			return err
+		}
+	}
+	for _, table := range []string{"tournament_participant", "tournament_standing"} {
+		if err := t.scyllaClient.Query(`DELETE FROM `+table+` WHERE tournament_id = ?`, nil).Bind(tournamentId).ExecRelease(); err != nil {
+			return err
+		}
+	}
+	return t.scyllaClient.Query(`DELETE FROM tournament WHERE id = ?`, nil).Bind(tournamentId).ExecRelease()
+}
+
+func (t *TournamentRepositoryScylla) ClaimClose(tournamentId string, closingAt int64, now int64) (bool, error) {
+	defer trackScyllaLatency(t.game, "claim_tournament_close")()
+	var existingStatus string
+	var existingClosingAt time.Time
+	if closingAt == 0 {
+		return t.scyllaClient.Query(`UPDATE tournament SET status = ?, closing_at = ? WHERE id = ? IF status = ?`, nil).
+			Bind(entities.TournamentStatusClosing, time.UnixMilli(now), tournamentId, entities.TournamentStatusOpen).
+			ScanCAS(&existingStatus)
+	}
+	return t.scyllaClient.Query(`UPDATE tournament SET closing_at = ? WHERE id = ? IF status = ? AND closing_at = ?`, nil).
+		Bind(time.UnixMilli(now), tournamentId, entities.TournamentStatusClosing, time.UnixMilli(closingAt)).
+		ScanCAS(&existingStatus, &existingClosingAt)
+}
+
+func (t *TournamentRepositoryScylla) MarkClosed(tournamentId string, closedAt int64) error {
+	defer trackScyllaLatency(t.game, "mark_tournament_closed")()
+	return t.scyllaClient.Query(`UPDATE tournament SET status = ?, closed_at = ? WHERE id = ?`, nil).
+		Bind(entities.TournamentStatusClosed, time.UnixMilli(closedAt), tournamentId).
+		ExecRelease()
+}
+
+func (t *TournamentRepositoryScylla) Register(registration *entities.TournamentRegistration) error {
+	defer trackScyllaLatency(t.game, "register_tournament")()
+	batch := t.scyllaClient.Session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO tournament_registration (user_id,tournament_id,registered_at) VALUES (?,?,?)`,
+		registration.UserId, registration.TournamentId, time.UnixMilli(registration.RegisteredAt))
+	batch.Query(`INSERT INTO tournament_participant (tournament_id,user_id) VALUES (?,?)`, registration.TournamentId, registration.UserId)
+	return t.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+func (t *TournamentRepositoryScylla) Unregister(tournamentId string, userId string) error {
+	defer trackScyllaLatency(t.game, "unregister_tournament")()
+	batch := t.scyllaClient.Session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM tournament_registration WHERE user_id = ? AND tournament_id = ?`, userId, tournamentId)
+	batch.Query(`DELETE FROM tournament_participant WHERE tournament_id = ? AND user_id = ?`, tournamentId, userId)
+	return t.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+func (t *TournamentRepositoryScylla) GetUserRegistrations(userId string) ([]*entities.TournamentRegistration, error) {
+	defer trackScyllaLatency(t.game, "get_user_tournament_registrations")()
+	var registrations []*entities.TournamentRegistration
+	query := t.scyllaClient.Query(`SELECT user_id,tournament_id,registered_at,position,score,reward_id FROM tournament_registration WHERE user_id = ?`, nil).
+		Bind(userId)
+	if err := query.SelectRelease(&registrations); err != nil {
+		return nil, err
+	}
+	return registrations, nil
+}
+
+func (t *TournamentRepositoryScylla) GetParticipantIds(tournamentId string) ([]string, error) {
+	defer trackScyllaLatency(t.game, "get_tournament_participant_ids")()
+	var userIds []string
+	if err := t.scyllaClient.Query(`SELECT user_id FROM tournament_participant WHERE tournament_id = ?`, nil).Bind(tournamentId).SelectRelease(&userIds); err != nil {
+		return nil, err
+	}
+	return userIds, nil
+}
+
+// SaveStanding freezes the final position and copies the result to the user's registration
+func (t *TournamentRepositoryScylla) SaveStanding(standing *entities.TournamentStanding) error {
+	defer trackScyllaLatency(t.game, "save_tournament_standing")()
+	batch := t.scyllaClient.Session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO tournament_standing (tournament_id,position,user_id,score,reward_id) VALUES (?,?,?,?,?)`,
+		standing.TournamentId, standing.Position, standing.UserId, standing.Score, standing.RewardId)
+	batch.Query(`UPDATE tournament_registration SET position = ?, score = ?, reward_id = ? WHERE user_id = ? AND tournament_id = ?`,
+		standing.Position, standing.Score, standing.RewardId, standing.UserId, standing.TournamentId)
+	return t.scyllaClient.Session.ExecuteBatch(batch)
+}
+
+// GetStandings returns the best limit final positions
+func (t *TournamentRepositoryScylla) GetStandings(tournamentId string, limit int) ([]*entities.TournamentStanding, error) {
+	defer trackScyllaLatency(t.game, "get_tournament_standings")()
+	var standings []*entities.TournamentStanding
+	query := t.scyllaClient.Query(`SELECT tournament_id,position,user_id,score,reward_id FROM tournament_standing WHERE tournament_id = ? LIMIT ?`, nil).
+		Bind(tournamentId, limit)
+	if err := query.SelectRelease(&standings); err != nil {
+		return nil, err
+	}
+	return standings, nil
+}
+
+// DeleteUserRegistrations forgets the user's registrations and removes them from final standings
+func (t *TournamentRepositoryScylla) DeleteUserRegistrations(userId string) error {
+	registrations, err := t.GetUserRegistrations(userId)
+	if err != nil {
+		return err
+	}
+	defer trackScyllaLatency(t.game, "delete_user_tournament_registrations")()
+	for _, registration := range registrations {
+		err := t.scyllaClient.Query(`DELETE FROM tournament_participant WHERE tournament_id = ? AND user_id = ?`, nil).
+			Bind(registration.TournamentId, userId).
+			ExecRelease()
+		if err != nil {
+			return err
+		}
+		if registration.Position == 0 {
+			continue
+		}
+		err = t.scyllaClient.Query(`DELETE FROM tournament_standing WHERE tournament_id = ? AND position = ?`, nil).
+			Bind(registration.TournamentId, registration.Position).
+			ExecRelease()
+		if err != nil {
+			return err
+		}
+	}
+	return t.scyllaClient.Query(`DELETE FROM tournament_registration WHERE user_id = ?`, nil).Bind(userId).ExecRelease()
+}
+
+func (t *TournamentRepositoryScylla) Purge() error {
+	defer trackScyllaLatency(t.game, "purge_tournaments")()
+	for _, table := range []string{"tournament", "tournament_registration", "tournament_participant", "tournament_standing"} {
+		if err := t.scyllaClient.Query(`TRUNCATE `+table, nil).Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
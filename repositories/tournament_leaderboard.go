@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/entities"
+)
+
+// Tournament leaderboards live next to the regular ones but are keyed by tournament id, they only hold
+// registered users and are deleted once the tournament's final standings are frozen.
+
+func (l *LeaderboardRedisRepo) tournamentKey(tournamentId string) string {
+	return l.ns + fmt.Sprintf("leaderboard:{tournament:%s}:data", tournamentId)
+}
+
+func (l *LeaderboardRedisRepo) tournamentShadowKey(tournamentId string) string {
+	return l.ns + fmt.Sprintf("leaderboard:{tournament:%s}:shadow", tournamentId)
+}
+
+func (l *LeaderboardRedisRepo) tournamentKeys(tournamentId string, shadow bool) (string, string) {
+	if shadow {
+		return l.tournamentShadowKey(tournamentId), l.tournamentKey(tournamentId)
+	}
+	return l.tournamentKey(tournamentId), l.tournamentShadowKey(tournamentId)
+}
+
+// AddTournamentUser registers the user with zero score, registering again keeps the score
+func (l *LeaderboardRedisRepo) AddTournamentUser(tournamentId string, userId string, shadow bool) error {
+	key, _ := l.tournamentKeys(tournamentId, shadow)
+	return l.c.Do(context.Background(), l.c.B().Zadd().Key(key).Nx().ScoreMember().ScoreMember(0, userId).Build()).Error()
+}
+
+func (l *LeaderboardRedisRepo) RemoveTournamentUser(tournamentId string, userId string) error {
+	res := l.c.DoMulti(
+		context.Background(),
+		l.c.B().Zrem().Key(l.tournamentKey(tournamentId)).Member(userId).Build(),
+		l.c.B().Zrem().Key(l.tournamentShadowKey(tournamentId)).Member(userId).Build(),
+	)
+	for _, r := range res {
+		if r.Error() != nil {
+			return r.Error()
+		}
+	}
+	return nil
+}
+
+// UpdateTournamentScore adds to the score of a registered user, false if the user is not registered
+func (l *LeaderboardRedisRepo) UpdateTournamentScore(tournamentId string, userId string, score int, shadow bool) (bool, error) {
+	key, _ := l.tournamentKeys(tournamentId, shadow)
+	err := l.c.Do(context.Background(), l.c.B().Zadd().Key(key).Xx().Incr().ScoreMember().ScoreMember(float64(score), userId).Build()).Error()
+	if rueidis.IsRedisNil(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *LeaderboardRedisRepo) MoveToTournamentShadow(tournamentId string, userId string) error {
+	err := moveMemberScript.Exec(context.Background(), l.c, []string{l.tournamentKey(tournamentId), l.tournamentShadowKey(tournamentId)}, []string{userId}).Error()
+	if rueidis.IsRedisNil(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *LeaderboardRedisRepo) MoveFromTournamentShadow(tournamentId string, userId string) error {
+	err := moveMemberScript.Exec(context.Background(), l.c, []string{l.tournamentShadowKey(tournamentId), l.tournamentKey(tournamentId)}, []string{userId}).Error()
+	if rueidis.IsRedisNil(err) {
+		return nil
+	}
+	return err
+}
+
+// GetTournamentStandings is GetStandings for the tournament's leaderboard
+func (l *LeaderboardRedisRepo) GetTournamentStandings(tournamentId string, offset int, count int) ([]*entities.LeaderboardScore, error) {
+	return l.standings(l.tournamentKey(tournamentId), 0, offset, count)
+}
+
+// GetTournamentUserScore is GetUserScore for the tournament's leaderboard
+func (l *LeaderboardRedisRepo) GetTournamentUserScore(tournamentId string, userId string) (*entities.LeaderboardScore, error) {
+	return l.userScore(l.tournamentKey(tournamentId), l.tournamentShadowKey(tournamentId), 0, userId)
+}
+
+func (l *LeaderboardRedisRepo) DeleteTournamentLeaderboard(tournamentId string) error {
+	return l.c.Do(context.Background(), l.c.B().Del().Key(l.tournamentKey(tournamentId), l.tournamentShadowKey(tournamentId)).Build()).Error()
+}
@@ -6,9 +6,8 @@ import (
 	"github.com/gocql/gocql"
 	"github.com/scylladb/gocqlx/qb"
 	"github.com/scylladb/gocqlx/v2"
-	"github.com/skif48/leaderboard-engine/app_config"
 	"github.com/skif48/leaderboard-engine/entities"
-	"github.com/skif48/leaderboard-engine/graceful_shutdown"
+	"github.com/skif48/leaderboard-engine/game_config"
 	"time"
 )
 
@@ -18,33 +17,49 @@ type UserProfileRepository interface {
 	GetUserProfile(userId string) (*entities.UserProfile, error)
 	GetUserProfileEventual(userId string) (*entities.UserProfile, error)
 	UpdateLevel(userId string, oldLevel int, newLevel int) (bool, error)
+	UpdateStatus(userId string, status string) error
+	// UpdateLeaderboard moves an existing user to another primary leaderboard, false if the user is gone
+	UpdateLeaderboard(userId string, leaderboard int) (bool, error)
+	// UpdateClan moves the user to another clan if they are still in the old one, empty ids meaning no clan
+	UpdateClan(userId string, oldClanId string, newClanId string) (bool, error)
+	UpdateNickname(userId string, oldNickname string, newNickname string) (bool, error)
+	GetLeaderboardUserIds(leaderboard int) ([]string, error)
+	Delete(userIds []string) error
 	Purge() error
 }
 
 type UserProfileRepositoryScylla struct {
 	scyllaClient *gocqlx.Session
+	game         *game_config.Game
 }
 
-func trackScyllaLatency(query string) func() {
+func trackScyllaLatency(game *game_config.Game, query string) func() {
 	start := time.Now()
 	return func() {
-		metrics.GetOrCreateHistogram(fmt.Sprintf(`scylla_query_latency_milliseconds{query=%q}`, query)).Update(float64(time.Since(start).Milliseconds()))
+		metrics.GetOrCreateHistogram(game.MetricName(fmt.Sprintf(`scylla_query_latency_milliseconds{query=%q}`, query))).Update(float64(time.Since(start).Milliseconds()))
 	}
 }
 
-func NewUserProfileRepository(ac *app_config.AppConfig) UserProfileRepository {
-	// DDL session — minimal config, no keyspace
-	ddlCluster := gocql.NewCluster(ac.ScyllaUrl)
-	ddlSession, err := gocqlx.WrapSession(ddlCluster.CreateSession())
-	if err != nil {
+// addColumnIfMissing evolves tables created by earlier versions, CREATE TABLE IF NOT EXISTS leaves them untouched.
+// The keyspace is the one of the session, every game has its own.
+func addColumnIfMissing(session *gocqlx.Session, keyspace string, table string, column string, columnType string) {
+	var existing string
+	err := session.Query(`SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ? AND column_name = ?`, nil).
+		Bind(keyspace, table, column).
+		Get(&existing)
+	if err == nil {
+		return
+	}
+	if err != gocql.ErrNotFound {
 		panic(err)
 	}
-
-	err = ddlSession.Query("CREATE KEYSPACE IF NOT EXISTS leaderboard WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}", nil).Exec()
-	if err != nil {
+	if err := session.Query(fmt.Sprintf(`ALTER TABLE %s ADD %s %s`, table, column, columnType), nil).Exec(); err != nil {
 		panic(err)
 	}
-	err = ddlSession.Query(`CREATE TABLE IF NOT EXISTS leaderboard.user_profile (
+}
+
+func NewUserProfileRepository(session *gocqlx.Session, game *game_config.Game) UserProfileRepository {
+	err := session.Query(`CREATE TABLE IF NOT EXISTS user_profile (
     	id uuid,
     	nickname text,
     	level int,
@@ -54,28 +69,14 @@ func NewUserProfileRepository(ac *app_config.AppConfig) UserProfileRepository {
 	if err != nil {
 		panic(err)
 	}
-	ddlSession.Close()
-
-	// Main session — tuned for production queries
-	cluster := gocql.NewCluster(ac.ScyllaUrl)
-	cluster.Keyspace = "leaderboard"
-	cluster.NumConns = ac.ScyllaNumConns
-	cluster.Consistency = gocql.Quorum
-	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(
-		gocql.RoundRobinHostPolicy(),
-	)
-	session, err := gocqlx.WrapSession(cluster.CreateSession())
-	if err != nil {
-		panic(err)
-	}
-	graceful_shutdown.AddOutputShutdownFunc(func() {
-		session.Close()
-	})
-	return &UserProfileRepositoryScylla{scyllaClient: &session}
+	addColumnIfMissing(session, game.Keyspace(), "user_profile", "status", "text")
+	addColumnIfMissing(session, game.Keyspace(), "user_profile", "nickname_changed_at", "timestamp")
+	addColumnIfMissing(session, game.Keyspace(), "user_profile", "clan_id", "uuid")
+	return &UserProfileRepositoryScylla{scyllaClient: session, game: game}
 }
 
 func (u *UserProfileRepositoryScylla) SignUp(r *entities.CreateUserProfileDto) (*entities.UserProfile, error) {
-	defer trackScyllaLatency("sign_up")()
+	defer trackScyllaLatency(u.game, "sign_up")()
 	id, _ := gocql.RandomUUID()
 	createdAt := time.Now()
 	q := u.scyllaClient.Query(
@@ -101,7 +102,7 @@ func (u *UserProfileRepositoryScylla) SignUp(r *entities.CreateUserProfileDto) (
 }
 
 func (u *UserProfileRepositoryScylla) GetManyUserProfiles(userIds []string) ([]*entities.UserProfile, error) {
-	defer trackScyllaLatency("get_many_user_profiles")()
+	defer trackScyllaLatency(u.game, "get_many_user_profiles")()
 	uuids := make([]gocql.UUID, len(userIds))
 	for i, userIdStr := range userIds {
 		uuid, err := gocql.ParseUUID(userIdStr)
@@ -122,7 +123,7 @@ func (u *UserProfileRepositoryScylla) GetManyUserProfiles(userIds []string) ([]*
 }
 
 func (u *UserProfileRepositoryScylla) GetUserProfile(userId string) (*entities.UserProfile, error) {
-	defer trackScyllaLatency("get_user_profile")()
+	defer trackScyllaLatency(u.game, "get_user_profile")()
 	userProfile := &entities.UserProfile{}
 	q := u.scyllaClient.Query(`SELECT * FROM user_profile WHERE id = ?`, nil).Bind(userId)
 	if err := q.Get(userProfile); err != nil {
@@ -136,7 +137,7 @@ func (u *UserProfileRepositoryScylla) GetUserProfile(userId string) (*entities.U
 }
 
 func (u *UserProfileRepositoryScylla) GetUserProfileEventual(userId string) (*entities.UserProfile, error) {
-	defer trackScyllaLatency("get_user_profile_eventual")()
+	defer trackScyllaLatency(u.game, "get_user_profile_eventual")()
 	userProfile := &entities.UserProfile{}
 	q := u.scyllaClient.Query(`SELECT * FROM user_profile WHERE id = ?`, nil).Bind(userId)
 	q.Consistency(gocql.One)
@@ -151,7 +152,7 @@ func (u *UserProfileRepositoryScylla) GetUserProfileEventual(userId string) (*en
 }
 
 func (u *UserProfileRepositoryScylla) UpdateLevel(userId string, currentLevel int, newLevel int) (bool, error) {
-	defer trackScyllaLatency("update_level")()
+	defer trackScyllaLatency(u.game, "update_level")()
 	applied := false
 	tempUserLevel := 0
 	updateQuery := u.scyllaClient.Query(`
@@ -167,7 +168,80 @@ func (u *UserProfileRepositoryScylla) UpdateLevel(userId string, currentLevel in
 	return applied, nil
 }
 
+func (u *UserProfileRepositoryScylla) UpdateNickname(userId string, oldNickname string, newNickname string) (bool, error) {
+	defer trackScyllaLatency(u.game, "update_nickname")()
+	var currentNickname string
+	return u.scyllaClient.Query(`
+			UPDATE user_profile
+			SET nickname = ?, nickname_changed_at = ?
+			WHERE id = ?
+			IF nickname = ?`, nil).
+		Bind(newNickname, time.Now(), userId, oldNickname).
+		ScanCAS(&currentNickname)
+}
+
+func (u *UserProfileRepositoryScylla) UpdateStatus(userId string, status string) error {
+	defer trackScyllaLatency(u.game, "update_status")()
+	return u.scyllaClient.Query(`UPDATE user_profile SET status = ? WHERE id = ?`, nil).Bind(status, userId).ExecRelease()
+}
+
+func (u *UserProfileRepositoryScylla) UpdateLeaderboard(userId string, leaderboard int) (bool, error) {
+	defer trackScyllaLatency(u.game, "update_leaderboard")()
+	// a plain update would bring a deleted profile back as a row with only the leaderboard set
+	return u.scyllaClient.Query(`UPDATE user_profile SET leaderboard = ? WHERE id = ? IF EXISTS`, nil).
+		Bind(leaderboard, userId).
+		ScanCAS()
+}
+
+func (u *UserProfileRepositoryScylla) UpdateClan(userId string, oldClanId string, newClanId string) (bool, error) {
+	defer trackScyllaLatency(u.game, "update_clan")()
+	var currentClanId *gocql.UUID
+	return u.scyllaClient.Query(`
+			UPDATE user_profile
+			SET clan_id = ?
+			WHERE id = ?
+			IF clan_id = ?`, nil).
+		Bind(nullableUUID(newClanId), userId, nullableUUID(oldClanId)).
+		ScanCAS(&currentClanId)
+}
+
+// nullableUUID binds an empty id as null
+func nullableUUID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// GetLeaderboardUserIds scans the whole table, it's meant for rare backoffice operations only
+func (u *UserProfileRepositoryScylla) GetLeaderboardUserIds(leaderboard int) ([]string, error) {
+	defer trackScyllaLatency(u.game, "get_leaderboard_user_ids")()
+	var userIds []string
+	q := u.scyllaClient.Query(`SELECT id FROM user_profile WHERE leaderboard = ? ALLOW FILTERING`, nil).Bind(leaderboard)
+	if err := q.SelectRelease(&userIds); err != nil {
+		return nil, err
+	}
+	return userIds, nil
+}
+
+func (u *UserProfileRepositoryScylla) Delete(userIds []string) error {
+	defer trackScyllaLatency(u.game, "delete")()
+	if len(userIds) == 0 {
+		return nil
+	}
+	uuids := make([]gocql.UUID, len(userIds))
+	for i, userIdStr := range userIds {
+		uuid, err := gocql.ParseUUID(userIdStr)
+		if err != nil {
+			return fmt.Errorf("invalid UUID format for user ID %s: %w", userIdStr, err)
+		}
+		uuids[i] = uuid
+	}
+	stmt, names := qb.Delete("user_profile").Where(qb.In("id")).ToCql()
+	return u.scyllaClient.Query(stmt, names).BindMap(qb.M{"id": uuids}).ExecRelease()
+}
+
 func (u *UserProfileRepositoryScylla) Purge() error {
-	defer trackScyllaLatency("purge")()
+	defer trackScyllaLatency(u.game, "purge")()
 	return u.scyllaClient.Query(`TRUNCATE user_profile`, nil).Exec()
 }
@@ -0,0 +1,64 @@
+package repositories
+
+import (
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/inits"
+	"os"
+	"testing"
+)
+
+// TestUserProfileMigratesGameKeyspace runs against a disposable Scylla, set SCYLLA_TEST_URL to run it
+func TestUserProfileMigratesGameKeyspace(t *testing.T) {
+	scyllaUrl := os.Getenv("SCYLLA_TEST_URL")
+	if scyllaUrl == "" {
+		t.Skip("SCYLLA_TEST_URL is not set")
+	}
+	ac := &app_config.AppConfig{ScyllaUrl: scyllaUrl, ScyllaNumConns: 1}
+	// the default game's table is already migrated, the other game's one is still the table of earlier versions
+	defaultGame := &game_config.Game{Id: "default", Default: true}
+	NewUserProfileRepository(inits.NewScyllaSession(ac, defaultGame), defaultGame)
+	game := &game_config.Game{Id: "migration_test"}
+	session := inits.NewScyllaSession(ac, game)
+	t.Cleanup(func() {
+		_ = session.Query("DROP KEYSPACE IF EXISTS "+game.Keyspace(), nil).Exec()
+	})
+	if err := session.Query("DROP TABLE IF EXISTS user_profile", nil).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Query(`CREATE TABLE user_profile (
+    	id uuid,
+    	nickname text,
+    	level int,
+    	leaderboard int,
+    	created_at timestamp,
+    	PRIMARY KEY (id))`, nil).Exec(); err != nil {
+		t.Fatal(err)
+	}
+
+	NewUserProfileRepository(session, game)
+
+	for _, column := range []string{"status", "nickname_changed_at", "clan_id"} {
+		if !hasColumn(t, session, game.Keyspace(), "user_profile", column) {
+			t.Fatalf("column %s missing in keyspace %s", column, game.Keyspace())
+		}
+	}
+}
+
+func hasColumn(t *testing.T, session *gocqlx.Session, keyspace string, table string, column string) bool {
+	t.Helper()
+	var columns []string
+	err := session.Query(`SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ?`, nil).
+		Bind(keyspace, table).
+		Select(&columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, existing := range columns {
+		if existing == column {
+			return true
+		}
+	}
+	return false
+}
@@ -4,24 +4,30 @@ import (
 	"context"
 	"fmt"
 	"github.com/redis/rueidis"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"strconv"
 )
 
 type UserXpRepository interface {
 	IncrementXp(userId string, score int) (int, error)
+	SetXp(userId string, xp int) error
 	GetXp(userId string) (int, error)
 	GetManyUsersXp(userIds []string) (map[string]int, error)
+	DeleteXp(userIds []string) error
+	Purge() error
 }
 
 type userXpRepositoryRedis struct {
-	c rueidis.Client
+	c  rueidis.Client
+	ns string
 }
 
-func NewUserXpRepository(c rueidis.Client) UserXpRepository {
-	return &userXpRepositoryRedis{c: c}
+func NewUserXpRepository(c rueidis.Client, game *game_config.Game) UserXpRepository {
+	return &userXpRepositoryRedis{c: c, ns: keyPrefix(game)}
 }
 
 func (u *userXpRepositoryRedis) key(userId string) string {
-	return fmt.Sprintf("user:{%s}:xp", userId)
+	return u.ns + fmt.Sprintf("user:{%s}:xp", userId)
 }
 
 func (u *userXpRepositoryRedis) IncrementXp(userId string, score int) (int, error) {
@@ -29,6 +35,10 @@ func (u *userXpRepositoryRedis) IncrementXp(userId string, score int) (int, erro
 	return int(xp), err
 }
 
+func (u *userXpRepositoryRedis) SetXp(userId string, xp int) error {
+	return u.c.Do(context.Background(), u.c.B().Set().Key(u.key(userId)).Value(strconv.Itoa(xp)).Build()).Error()
+}
+
 func (u *userXpRepositoryRedis) GetXp(userId string) (int, error) {
 	xp, err := u.c.Do(context.Background(), u.c.B().Get().Key(u.key(userId)).Build()).ToInt64()
 	return int(xp), err
@@ -42,3 +52,15 @@ func (u *userXpRepositoryRedis) GetManyUsersXp(userIds []string) (map[string]int
 	}
 	return xp, nil
 }
+
+func (u *userXpRepositoryRedis) DeleteXp(userIds []string) error {
+	keys := make([]string, 0, len(userIds))
+	for _, userId := range userIds {
+		keys = append(keys, u.key(userId))
+	}
+	return deleteKeys(u.c, keys)
+}
+
+func (u *userXpRepositoryRedis) Purge() error {
+	return deleteKeysByPattern(u.c, u.ns+"user:*:xp")
+}
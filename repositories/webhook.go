@@ -0,0 +1,149 @@
+package repositories
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/scylladb/gocqlx/v2"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"time"
+)
+
+// WebhookRepository keeps registered webhooks and the delivery log. Deliveries expire after the retention
+// given on save, so user ids in their payloads don't outlive it.
+type WebhookRepository interface {
+	Create(webhook *entities.Webhook) error
+	Get(webhookId string) (*entities.Webhook, error)
+	// GetAll scans the whole registry, which is small
+	GetAll() ([]*entities.Webhook, error)
+	Delete(webhookId string) error
+	SaveDelivery(delivery *entities.WebhookDelivery, retention time.Duration) error
+	GetDelivery(webhookId string, deliveryId string) (*entities.WebhookDelivery, error)
+	// GetDeliveries returns the latest deliveries of the webhook first
+	GetDeliveries(webhookId string, limit int) ([]*entities.WebhookDelivery, error)
+	PurgeDeliveries() error
+}
+
+type WebhookRepositoryScylla struct {
+	scyllaClient *gocqlx.Session
+	game         *game_config.Game
+}
+
+func NewWebhookRepository(session *gocqlx.Session, game *game_config.Game) WebhookRepository {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS webhook (
+    	id uuid,
+    	event_type text,
+    	url text,
+    	secret text,
+    	created_at timestamp,
+    	PRIMARY KEY (id))`,
+		`CREATE TABLE IF NOT EXISTS webhook_delivery (
+    	webhook_id uuid,
+    	id timeuuid,
+    	event_type text,
+    	payload text,
+    	status text,
+    	attempts int,
+    	last_status_code int,
+    	last_error text,
+    	created_at timestamp,
+    	updated_at timestamp,
+    	PRIMARY KEY (webhook_id, id))
+    	WITH CLUSTERING ORDER BY (id DESC)`,
+	}
+	for _, query := range queries {
+		if err := session.Query(query, nil).Exec(); err != nil {
+			panic(err)
+		}
+	}
+	return &WebhookRepositoryScylla{scyllaClient: session, game: game}
+}
+
+func (w *WebhookRepositoryScylla) Create(webhook *entities.Webhook) error {
+	defer trackScyllaLatency(w.game, "create_webhook")()
+	return w.scyllaClient.Query(`INSERT INTO webhook (id,event_type,url,secret,created_at) VALUES (?,?,?,?,?)`, nil).
+		Bind(webhook.Id, webhook.EventType, webhook.Url, webhook.Secret, time.UnixMilli(webhook.CreatedAt)).
+		ExecRelease()
+}
+
+func (w *WebhookRepositoryScylla) Get(webhookId string) (*entities.Webhook, error) {
+	defer trackScyllaLatency(w.game, "get_webhook")()
+	webhook := &entities.Webhook{}
+	if err := w.scyllaClient.Query(`SELECT id,event_type,url,secret,created_at FROM webhook WHERE id = ?`, nil).Bind(webhookId).Get(webhook); err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (w *WebhookRepositoryScylla) GetAll() ([]*entities.Webhook, error) {
+	defer trackScyllaLatency(w.game, "get_all_webhooks")()
+	var webhooks []*entities.Webhook
+	if err := w.scyllaClient.Query(`SELECT id,event_type,url,secret,created_at FROM webhook`, nil).SelectRelease(&webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (w *WebhookRepositoryScylla) Delete(webhookId string) error {
+	defer trackScyllaLatency(w.game, "delete_webhook")()
+	if err := w.scyllaClient.Query(`DELETE FROM webhook_delivery WHERE webhook_id = ?`, nil).Bind(webhookId).ExecRelease(); err != nil {
+		return err
+	}
+	return w.scyllaClient.Query(`DELETE FROM webhook WHERE id = ?`, nil).Bind(webhookId).ExecRelease()
+}
+
+func (w *WebhookRepositoryScylla) SaveDelivery(delivery *entities.WebhookDelivery, retention time.Duration) error {
+	defer trackScyllaLatency(w.game, "save_webhook_delivery")()
+	return w.scyllaClient.Query(
+		`INSERT INTO webhook_delivery (webhook_id,id,event_type,payload,status,attempts,last_status_code,last_error,created_at,updated_at) VALUES (?,?,?,?,?,?,?,?,?,?) USING TTL ?`, nil).
+		Bind(
+			delivery.WebhookId,
+			delivery.Id,
+			delivery.EventType,
+			delivery.Payload,
+			delivery.Status,
+			delivery.Attempts,
+			delivery.LastStatusCode,
+			delivery.LastError,
+			time.UnixMilli(delivery.CreatedAt),
+			time.UnixMilli(delivery.UpdatedAt),
+			int(retention.Seconds()),
+		).
+		ExecRelease()
+}
+
+const webhookDeliveryColumns = `webhook_id,id,event_type,payload,status,attempts,last_status_code,last_error,created_at,updated_at`
+
+func (w *WebhookRepositoryScylla) GetDelivery(webhookId string, deliveryId string) (*entities.WebhookDelivery, error) {
+	defer trackScyllaLatency(w.game, "get_webhook_delivery")()
+	delivery := &entities.WebhookDelivery{}
+	err := w.scyllaClient.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_delivery WHERE webhook_id = ? AND id = ?`, nil).
+		Bind(webhookId, deliveryId).
+		Get(delivery)
+	if err != nil {
+		if err == gocql.ErrNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (w *WebhookRepositoryScylla) GetDeliveries(webhookId string, limit int) ([]*entities.WebhookDelivery, error) {
+	defer trackScyllaLatency(w.game, "get_webhook_deliveries")()
+	var deliveries []*entities.WebhookDelivery
+	query := w.scyllaClient.Query(`SELECT `+webhookDeliveryColumns+` FROM webhook_delivery WHERE webhook_id = ? LIMIT ?`, nil).
+		Bind(webhookId, limit)
+	if err := query.SelectRelease(&deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (w *WebhookRepositoryScylla) PurgeDeliveries() error {
+	defer trackScyllaLatency(w.game, "purge_webhook_deliveries")()
+	return w.scyllaClient.Query(`TRUNCATE webhook_delivery`, nil).Exec()
+}
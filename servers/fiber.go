@@ -1,12 +1,15 @@
 package servers
 
 import (
+	"bufio"
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/VictoriaMetrics/metrics"
 	"github.com/gofiber/fiber/v3"
 	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/auth"
 	"github.com/skif48/leaderboard-engine/entities"
 	"github.com/skif48/leaderboard-engine/game_config"
 	"github.com/skif48/leaderboard-engine/graceful_shutdown"
@@ -15,15 +18,18 @@ import (
 	"github.com/skif48/leaderboard-engine/services"
 	"html/template"
 	"log/slog"
-	"math/rand/v2"
+	"strconv"
+	"time"
 )
 
 type LeaderboardsPageData struct {
+	Global       []*entities.LeaderboardScoreFull
 	Leaderboards map[int][]*entities.LeaderboardScoreFull
-}
-
-func randRange(min, max int) int {
-	return rand.IntN(max-min) + min
+	Clans        []*entities.ClanScore
+	// Names holds display names of the listed leaderboards
+	Names map[int]string
+	// History holds the positions of the last 24 hours of listed users, keyed by leaderboard, then user
+	History map[int]map[string][]int
 }
 
 //go:embed templates/leaderboards.html
@@ -32,59 +38,161 @@ var leaderboardsHtmlTemplate string
 type HttpHandler struct {
 	leaderboardsTemplate *template.Template
 
-	leaderBoardsAmount int
-
 	repo            repositories.UserProfileRepository
 	leaderboardRepo repositories.LeaderboardRepo
 	gas             *services.GameActionsService
 	ls              *services.LeaderboardService
+	ts              *auth.TokenService
+	acs             *services.AntiCheatService
+	ms              *services.ModerationService
+	ps              *services.PurgeService
+	uds             *services.UserDataService
+	ns              *services.NicknameService
+	las             services.LeaderboardAssignmentStrategy
+	lgs             *services.LeagueService
+	lms             *services.LeaderboardMembershipService
+	grs             *services.GlobalRankService
+	fs              *services.FriendsService
+	cs              *services.ClanService
+	tns             *services.TournamentService
+	ws              *services.WebhookService
+	nts             *services.NotificationService
+	achs            *services.AchievementService
+	ass             *services.ActionStatsService
+	rhs             *services.RankHistoryService
+	als             *services.ActionLogService
+	lts             *services.LeaderboardTransferService
+	lrs             *services.LeaderboardRegistryService
+
+	// streamsCtx ends notification streams on shutdown, they would otherwise keep the server from stopping
+	streamsCtx            context.Context
+	notificationHeartbeat time.Duration
 }
 
-func RunHttpServer(ac *app_config.AppConfig, repo repositories.UserProfileRepository, leaderboardRepo repositories.LeaderboardRepo, gas *services.GameActionsService, ls *services.LeaderboardService, gc *game_config.GameConfig) {
+// RegisterGameRoutes registers the HTTP routes of one game on the shared server
+func RegisterGameRoutes(ac *app_config.AppConfig, game *game_config.Game, gr *GameRouter, repo repositories.UserProfileRepository, leaderboardRepo repositories.LeaderboardRepo, rateLimiterRepo repositories.RateLimiterRepository, gas *services.GameActionsService, ls *services.LeaderboardService, ts *auth.TokenService, acs *services.AntiCheatService, ms *services.ModerationService, ps *services.PurgeService, uds *services.UserDataService, ns *services.NicknameService, las services.LeaderboardAssignmentStrategy, lgs *services.LeagueService, lms *services.LeaderboardMembershipService, grs *services.GlobalRankService, fs *services.FriendsService, cs *services.ClanService, tns *services.TournamentService, ws *services.WebhookService, nts *services.NotificationService, achs *services.AchievementService, ass *services.ActionStatsService, rhs *services.RankHistoryService, als *services.ActionLogService, lts *services.LeaderboardTransferService, lrs *services.LeaderboardRegistryService) {
 	leaderboardsTemplate, err := template.New("leaderboards.html").Funcs(template.FuncMap{
 		"add": func(a, b int) int {
 			return a + b
 		},
+		"sparkline": sparkline,
 	}).Parse(leaderboardsHtmlTemplate)
 	if err != nil {
 		panic(err)
 	}
 
+	streamsCtx, stopStreams := context.WithCancel(context.Background())
 	h := &HttpHandler{
 		leaderboardsTemplate: leaderboardsTemplate,
-		leaderBoardsAmount:   gc.MaxLeaderboards,
 		repo:                 repo,
 		leaderboardRepo:      leaderboardRepo,
 		gas:                  gas,
 		ls:                   ls,
-	}
-	app := fiber.New()
-	app.Use(middleware.MetricsMiddleware())
+		ts:                   ts,
+		acs:                  acs,
+		ms:                   ms,
+		ps:                   ps,
+		uds:                  uds,
+		ns:                   ns,
+		las:                  las,
+		lgs:                  lgs,
+		lms:                  lms,
+		grs:                  grs,
+		fs:                   fs,
+		cs:                   cs,
+		tns:                  tns,
+		ws:                   ws,
+		nts:                  nts,
+		achs:                 achs,
+		ass:                  ass,
+		rhs:                  rhs,
+		als:                  als,
+		lts:                  lts,
+		lrs:                  lrs,
 
-	app.Get("/metrics", func(ctx fiber.Ctx) error {
-		metrics.WritePrometheus(ctx.Response().BodyWriter(), true)
-		return nil
-	})
+		streamsCtx:            streamsCtx,
+		notificationHeartbeat: ac.NotificationStreamHeartbeat,
+	}
+	app := gr.addGame(game, gas)
 
 	app.Get("/leaderboards", h.GetLeaderboardsHTML)
 
-	app.Post("/api/v1/users/sign-up", h.SignUp)
-	app.Post("/api/v1/users/actions", h.Action)
-	app.Get("/api/v1/users/:userId/profile", h.GetUserProfile)
+	authMiddleware := middleware.AuthMiddleware(ts)
+	rateLimitMiddleware := middleware.RateLimitMiddleware(rateLimiterRepo, ac)
 
-	app.Post("/backoffice-api/purge", h.Purge)
+	app.Post("/api/v1/users/sign-up", h.SignUp)
+	app.Get("/api/v1/users", h.FindUsers, authMiddleware)
+	app.Post("/api/v1/users/actions", h.Action, authMiddleware, rateLimitMiddleware)
+	app.Get("/api/v1/users/:userId/profile", h.GetUserProfile, authMiddleware)
+	app.Get("/api/v1/users/:userId/leaderboard", h.GetUserLeaderboard, authMiddleware)
+	app.Get("/api/v1/users/:userId/leaderboard/friends", h.GetFriendsLeaderboard, authMiddleware)
+	app.Get("/api/v1/users/:userId/friends", h.GetFriends, authMiddleware)
+	app.Get("/api/v1/users/:userId/friend-requests", h.GetFriendRequests, authMiddleware)
+	app.Put("/api/v1/users/:userId/friends/:friendId", h.AddFriend, authMiddleware)
+	app.Delete("/api/v1/users/:userId/friends/:friendId", h.RemoveFriend, authMiddleware)
+	app.Get("/api/v1/users/:userId/notifications/stream", h.StreamNotifications, authMiddleware)
+	app.Get("/api/v1/users/:userId/achievements", h.GetUserAchievements, authMiddleware)
+	app.Get("/api/v1/users/:userId/stats", h.GetUserStats, authMiddleware)
+	app.Get("/api/v1/users/:userId/history", h.GetUserHistory, authMiddleware)
+	app.Get("/api/v1/leaderboards", h.GetListedLeaderboards, authMiddleware)
+	app.Get("/api/v1/leaderboards/global", h.GetGlobalLeaderboard, authMiddleware)
+	app.Get("/api/v1/clans", h.GetTopClans, authMiddleware)
+	app.Post("/api/v1/clans", h.CreateClan, authMiddleware)
+	app.Get("/api/v1/clans/:clanId", h.GetClan, authMiddleware)
+	app.Put("/api/v1/users/:userId/clan", h.JoinClan, authMiddleware)
+	app.Delete("/api/v1/users/:userId/clan", h.LeaveClan, authMiddleware)
+	app.Get("/api/v1/tournaments", h.GetTournaments, authMiddleware)
+	app.Get("/api/v1/tournaments/:tournamentId/leaderboard", h.GetTournamentLeaderboard, authMiddleware)
+	app.Get("/api/v1/users/:userId/tournaments", h.GetUserTournaments, authMiddleware)
+	app.Put("/api/v1/users/:userId/tournaments/:tournamentId", h.RegisterForTournament, authMiddleware)
+	app.Delete("/api/v1/users/:userId/tournaments/:tournamentId", h.UnregisterFromTournament, authMiddleware)
+	app.Get("/api/v1/users/:userId/rank", h.GetUserGlobalRank, authMiddleware)
+	app.Get("/api/v1/users/:userId/league", h.GetUserLeague, authMiddleware)
+	app.Get("/api/v1/users/:userId/leaderboards", h.GetUserLeaderboards, authMiddleware)
+	app.Get("/api/v1/users/:userId/export", h.ExportUserData, authMiddleware)
+	app.Delete("/api/v1/users/:userId", h.DeleteUser, authMiddleware)
+	app.Patch("/api/v1/users/:userId/nickname", h.ChangeNickname, authMiddleware)
 
-	graceful_shutdown.AddInputShutdownFunc(func() {
-		if err := app.Shutdown(); err != nil {
-			slog.With("error", err).Error("Failed to shutdown fiber server")
-		}
-	})
+	if ac.PurgeEnabled {
+		app.Post("/backoffice-api/purge", h.RequestPurge)
+		app.Post("/backoffice-api/purge/confirm", h.ConfirmPurge)
+	}
+	app.Get("/backoffice-api/anti-cheat/flagged-users", h.GetFlaggedUsers)
+	app.Delete("/backoffice-api/anti-cheat/flagged-users/:userId", h.ClearUserFlag)
+	app.Get("/backoffice-api/anti-cheat/users/:userId/quarantine", h.GetUserQuarantine)
+	app.Post("/backoffice-api/users/:userId/ban", h.BanUser)
+	app.Delete("/backoffice-api/users/:userId/ban", h.UnbanUser)
+	app.Post("/backoffice-api/users/:userId/shadow-ban", h.ShadowBanUser)
+	app.Post("/backoffice-api/users/:userId/score", h.AdjustUserScore)
+	app.Post("/backoffice-api/users/:userId/xp", h.AdjustUserXp)
+	app.Get("/backoffice-api/users/:userId/audit", h.GetUserModerationAudit)
+	app.Get("/backoffice-api/users/:userId/export", h.ExportUserDataBackoffice)
+	app.Post("/backoffice-api/leaderboards/global/histogram/rebuild", h.RebuildGlobalRankHistogram)
+	app.Post("/backoffice-api/users/:userId/leaderboards", h.JoinLeaderboard)
+	app.Delete("/backoffice-api/users/:userId/leaderboards/:leaderboard", h.LeaveLeaderboard)
+	app.Get("/backoffice-api/users", h.FindUsersBackoffice)
+	app.Get("/backoffice-api/users/:userId/actions", h.GetUserActionLog)
+	app.Post("/backoffice-api/leaderboards", h.CreateLeaderboard)
+	app.Get("/backoffice-api/leaderboards", h.GetLeaderboards)
+	app.Get("/backoffice-api/leaderboards/:leaderboard", h.GetLeaderboardInfo)
+	app.Put("/backoffice-api/leaderboards/:leaderboard", h.UpdateLeaderboard)
+	app.Post("/backoffice-api/leaderboards/:leaderboard/close", h.CloseLeaderboard)
+	app.Delete("/backoffice-api/leaderboards/:leaderboard", h.DeleteLeaderboard)
+	app.Get("/backoffice-api/leaderboards/:leaderboard/export", h.ExportLeaderboard)
+	app.Post("/backoffice-api/leaderboards/:leaderboard/import", h.ImportLeaderboard)
+	app.Post("/backoffice-api/tournaments", h.CreateTournament)
+	app.Get("/backoffice-api/tournaments", h.GetTournaments)
+	app.Get("/backoffice-api/tournaments/:tournamentId", h.GetTournament)
+	app.Put("/backoffice-api/tournaments/:tournamentId", h.UpdateTournament)
+	app.Delete("/backoffice-api/tournaments/:tournamentId", h.DeleteTournament)
+	app.Post("/backoffice-api/webhooks", h.RegisterWebhook)
+	app.Get("/backoffice-api/webhooks", h.GetWebhooks)
+	app.Delete("/backoffice-api/webhooks/:webhookId", h.DeleteWebhook)
+	app.Get("/backoffice-api/webhooks/:webhookId/deliveries", h.GetWebhookDeliveries)
+	app.Post("/backoffice-api/webhooks/:webhookId/deliveries/:deliveryId/redeliver", h.RedeliverWebhook)
 
-	go func() {
-		if err := app.Listen(fmt.Sprintf(":%d", ac.FiberPort)); err != nil {
-			panic(err)
-		}
-	}()
+	// runs before the shared server shuts down, which would otherwise wait for open streams
+	graceful_shutdown.AddInputShutdownFunc(stopStreams)
 }
 
 func (s *HttpHandler) GetLeaderboardsHTML(c fiber.Ctx) error {
@@ -94,8 +202,40 @@ func (s *HttpHandler) GetLeaderboardsHTML(c fiber.Ctx) error {
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
 
+	infos, err := s.lrs.GetListed()
+	if err != nil {
+		slog.Error("Failed to get leaderboards metadata", "error", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	names := make(map[int]string, len(infos))
+	for _, info := range infos {
+		names[info.Id] = info.Name
+	}
+
+	global, err := s.ls.GetGlobalLeaderboard()
+	if err != nil {
+		slog.Error("Failed to get global leaderboard data", "error", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	clans, err := s.cs.GetTopClans(repositories.LeaderboardTopSize)
+	if err != nil {
+		slog.Error("Failed to get clans data", "error", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+
+	// sparklines are decoration, the page is still served without them
+	history, err := s.rhs.GetRecentPositions(leaderboards)
+	if err != nil {
+		slog.Warn("Failed to get rank history data", "error", err)
+	}
+
 	pageData := LeaderboardsPageData{
+		Global:       global,
 		Leaderboards: leaderboards,
+		Names:        names,
+		Clans:        clans,
+		History:      history,
 	}
 
 	c.Set("Content-Type", "text/html")
@@ -104,6 +244,9 @@ func (s *HttpHandler) GetLeaderboardsHTML(c fiber.Ctx) error {
 
 func (s *HttpHandler) GetUserProfile(c fiber.Ctx) error {
 	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
 	userProfile, err := s.repo.GetUserProfile(userId)
 	if err != nil {
 		slog.Error(err.Error())
@@ -121,23 +264,46 @@ func (s *HttpHandler) SignUp(c fiber.Ctx) error {
 	if err := json.Unmarshal(c.Body(), req); err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
+	nickname, err := s.ns.Validate(req.Nickname)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	leaderboard, err := s.las.Assign()
+	if err != nil {
+		slog.Error("Failed to assign leaderboard", "error", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
 	createDto := &entities.CreateUserProfileDto{
-		Nickname:    req.Nickname,
+		Nickname:    nickname,
 		Xp:          0,
 		Level:       0,
-		Leaderboard: randRange(1, s.leaderBoardsAmount+1),
+		Leaderboard: leaderboard,
 	}
 	userProfile, err := s.repo.SignUp(createDto)
 	if err != nil {
 		slog.Error(err.Error())
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	if err := s.ns.Claim(userProfile.Nickname, userProfile.Id); err != nil {
+		if deleteErr := s.repo.Delete([]string{userProfile.Id}); deleteErr != nil {
+			slog.Error("Failed to delete user profile after nickname claim failure", "error", deleteErr)
+		}
+		return c.SendStatus(serviceErrorStatus(err))
+	}
 	if err := s.leaderboardRepo.AddUser(createDto.Leaderboard, userProfile.Id); err != nil {
 		slog.Error("Failed to add user to leaderboard", "error", err)
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	token, err := s.ts.Issue(userProfile.Id)
+	if err != nil {
+		slog.Error("Failed to issue user token", "error", err)
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
 	c.Status(fiber.StatusCreated)
-	return c.JSON(userProfile)
+	return c.JSON(&entities.SignUpResponse{
+		UserProfile: *userProfile,
+		Token:       token,
+	})
 }
 
 func (s *HttpHandler) Action(c fiber.Ctx) error {
@@ -145,6 +311,9 @@ func (s *HttpHandler) Action(c fiber.Ctx) error {
 	if err := json.Unmarshal(c.Body(), req); err != nil {
 		return c.SendStatus(fiber.StatusBadRequest)
 	}
+	if req.UserId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
 
 	userProfile, err := s.repo.GetUserProfileEventual(req.UserId)
 	if err != nil {
@@ -154,6 +323,9 @@ func (s *HttpHandler) Action(c fiber.Ctx) error {
 	if userProfile == nil {
 		return c.SendStatus(fiber.StatusNotFound)
 	}
+	if userProfile.Status == entities.UserStatusBanned {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
 
 	req.LeaderboardId = userProfile.Leaderboard
 
@@ -164,10 +336,876 @@ func (s *HttpHandler) Action(c fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusAccepted)
 }
 
-func (s *HttpHandler) Purge(c fiber.Ctx) error {
-	if err := s.repo.Purge(); err != nil {
+func (s *HttpHandler) RequestPurge(c fiber.Ctx) error {
+	target := &entities.PurgeTarget{}
+	if err := json.Unmarshal(c.Body(), target); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	confirmation, err := s.ps.RequestPurge(target)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPurgeTarget) {
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	c.Status(fiber.StatusAccepted)
+	return c.JSON(confirmation)
+}
+
+func (s *HttpHandler) ConfirmPurge(c fiber.Ctx) error {
+	req := &entities.ConfirmPurgeRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil || req.ConfirmationToken == "" {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if _, err := s.ps.ConfirmPurge(req.ConfirmationToken); err != nil {
+		if errors.Is(err, services.ErrInvalidPurgeConfirmation) {
+			return c.SendStatus(fiber.StatusForbidden)
+		}
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) GetFlaggedUsers(c fiber.Ctx) error {
+	flaggedUsers, err := s.acs.GetFlaggedUsers()
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(flaggedUsers)
+}
+
+func (s *HttpHandler) ClearUserFlag(c fiber.Ctx) error {
+	if err := s.acs.ClearFlag(c.Params("userId")); err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) GetUserQuarantine(c fiber.Ctx) error {
+	quarantinedActions, err := s.acs.GetUserQuarantine(c.Params("userId"))
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(quarantinedActions)
+}
+
+func (s *HttpHandler) GetUserLeaderboard(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	userProfile, err := s.repo.GetUserProfileEventual(userId)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if userProfile == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	userLeaderboard, err := s.ls.GetUserLeaderboard(userProfile)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(userLeaderboard)
+}
+
+// GetGlobalLeaderboard returns the global leaderboard as the authenticated user sees it
+func (s *HttpHandler) GetGlobalLeaderboard(c fiber.Ctx) error {
+	userProfile, err := s.repo.GetUserProfileEventual(middleware.AuthenticatedUserId(c))
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if userProfile == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	globalLeaderboard, err := s.ls.GetUserGlobalLeaderboard(userProfile)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(globalLeaderboard)
+}
+
+// GetUserGlobalRank returns an approximate rank unless ?exact=true is asked for, which is much more expensive
+func (s *HttpHandler) GetUserGlobalRank(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	rank, err := s.grs.GetUserRank(userId, fiber.Query[bool](c, "exact"))
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if rank == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.JSON(rank)
+}
+
+func (s *HttpHandler) RebuildGlobalRankHistogram(c fiber.Ctx) error {
+	users, err := s.grs.RebuildHistogram()
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(fiber.Map{"users": users})
+}
+
+func (s *HttpHandler) GetUserLeague(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	userLeague, err := s.lgs.GetUserLeague(userId)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(userLeague)
+}
+
+func (s *HttpHandler) GetUserLeaderboards(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	standings, err := s.lms.GetUserStandings(userId)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(standings)
+}
+
+func (s *HttpHandler) JoinLeaderboard(c fiber.Ctx) error {
+	req := &entities.JoinLeaderboardRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.lms.Join(c.Params("userId"), req.Leaderboard); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) LeaveLeaderboard(c fiber.Ctx) error {
+	leaderboard, err := strconv.Atoi(c.Params("leaderboard"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.lms.Leave(c.Params("userId"), leaderboard); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) GetFriends(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	friends, err := s.fs.GetFriends(userId)
+	if err != nil {
 		slog.Error(err.Error())
 		return c.SendStatus(fiber.StatusInternalServerError)
 	}
+	return c.JSON(friends)
+}
+
+func (s *HttpHandler) GetFriendRequests(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	requests, err := s.fs.GetFriendRequests(userId)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(requests)
+}
+
+func (s *HttpHandler) GetUserAchievements(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	userProfile, err := s.repo.GetUserProfileEventual(userId)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if userProfile == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	achievements, err := s.achs.GetUserAchievements(userProfile)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(achievements)
+}
+
+// GetUserStats returns action counters, daily ones for ?from=&to= given as 2006-01-02 or the last 30 days
+func (s *HttpHandler) GetUserStats(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	stats, err := s.ass.GetUserStats(userId, c.Query("from"), c.Query("to"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(stats)
+}
+
+// GetUserHistory returns the rank timeline for ?from=&to= given as 2006-01-02 or the last 7 days
+func (s *HttpHandler) GetUserHistory(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	history, err := s.rhs.GetUserHistory(userId, c.Query("from"), c.Query("to"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(history)
+}
+
+// GetUserActionLog pages through the user's processed actions, latest first. ?from=&to= are 2006-01-02 days,
+// the last 7 by default, ?before= takes the next cursor of the previous page.
+func (s *HttpHandler) GetUserActionLog(c fiber.Ctx) error {
+	page, err := s.als.GetUserLog(c.Params("userId"), c.Query("from"), c.Query("to"), c.Query("before"), fiber.Query[int](c, "limit", 0))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(page)
+}
+
+func (s *HttpHandler) GetListedLeaderboards(c fiber.Ctx) error {
+	infos, err := s.lrs.GetListed()
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(infos)
+}
+
+// GetLeaderboards lists all leaderboards for the backoffice, closed and hidden ones included
+func (s *HttpHandler) GetLeaderboards(c fiber.Ctx) error {
+	infos, err := s.lrs.GetAll()
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(infos)
+}
+
+func (s *HttpHandler) GetLeaderboardInfo(c fiber.Ctx) error {
+	leaderboard, err := strconv.Atoi(c.Params("leaderboard"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	info, err := s.lrs.Get(leaderboard)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(info)
+}
+
+func (s *HttpHandler) CreateLeaderboard(c fiber.Ctx) error {
+	req := &entities.LeaderboardInfoRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	info, err := s.lrs.Create(req)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(info)
+}
+
+func (s *HttpHandler) UpdateLeaderboard(c fiber.Ctx) error {
+	leaderboard, err := strconv.Atoi(c.Params("leaderboard"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	req := &entities.LeaderboardInfoRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	info, err := s.lrs.Update(leaderboard, req)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(info)
+}
+
+func (s *HttpHandler) CloseLeaderboard(c fiber.Ctx) error {
+	leaderboard, err := strconv.Atoi(c.Params("leaderboard"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	info, err := s.lrs.Close(leaderboard)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(info)
+}
+
+func (s *HttpHandler) DeleteLeaderboard(c fiber.Ctx) error {
+	leaderboard, err := strconv.Atoi(c.Params("leaderboard"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err := s.lrs.Delete(leaderboard); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// ExportLeaderboard streams every rank of the leaderboard, ?format= is csv (default) or ndjson
+func (s *HttpHandler) ExportLeaderboard(c fiber.Ctx) error {
+	leaderboard, err := strconv.Atoi(c.Params("leaderboard"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	format := c.Query("format", services.TransferFormatCsv)
+	if !services.ValidTransferFormat(format) {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	contentType := "text/csv"
+	if format == services.TransferFormatNdjson {
+		contentType = "application/x-ndjson"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="leaderboard-%d.%s"`, leaderboard, format))
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		if err := s.lts.Export(leaderboard, format, w); err != nil {
+			slog.With("error", err, "leaderboard", leaderboard).Error("Failed to export leaderboard")
+		}
+		if err := w.Flush(); err != nil {
+			slog.With("error", err, "leaderboard", leaderboard).Warn("Leaderboard export was cut off")
+		}
+	})
+}
+
+// ImportLeaderboard sets scores from a body in the export format, ?format= is csv (default) or ndjson.
+// Malformed input is rejected as a whole, with the offending line in the response.
+func (s *HttpHandler) ImportLeaderboard(c fiber.Ctx) error {
+	leaderboard, err := strconv.Atoi(c.Params("leaderboard"))
+	if err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	result, err := s.lts.Import(leaderboard, c.Query("format", services.TransferFormatCsv), c.Body())
+	if errors.Is(err, services.ErrInvalidLeaderboardImport) {
+		c.Status(fiber.StatusBadRequest)
+		return c.SendString(err.Error())
+	}
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(result)
+}
+
+// StreamNotifications keeps a server-sent events stream open and writes every notification of the user as JSON
+func (s *HttpHandler) StreamNotifications(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	ctx, cancel := context.WithCancel(s.streamsCtx)
+	notifications := make(chan []byte, 16)
+	go func() {
+		defer cancel()
+		if err := s.nts.Subscribe(ctx, userId, notifications); err != nil {
+			slog.With("error", err, "userId", userId).Error("Notification stream subscription failed")
+		}
+	}()
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	return c.SendStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		heartbeat := time.NewTicker(s.notificationHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-notifications:
+				_, _ = fmt.Fprintf(w, "data: %s\n\n", notification)
+			case <-heartbeat.C:
+				_, _ = w.WriteString(": heartbeat\n\n")
+			}
+			// a failed flush means the client went away
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func (s *HttpHandler) AddFriend(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	friends, err := s.fs.AddFriend(userId, c.Params("friendId"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	if !friends {
+		// the request waits for the other user to add this one back
+		return c.SendStatus(fiber.StatusAccepted)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) RemoveFriend(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	if err := s.fs.RemoveFriend(userId, c.Params("friendId")); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) GetFriendsLeaderboard(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	userProfile, err := s.repo.GetUserProfileEventual(userId)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if userProfile == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	friendsLeaderboard, err := s.fs.GetFriendsLeaderboard(userProfile)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(friendsLeaderboard)
+}
+
+const (
+	defaultClansLimit = 10
+	maxClansLimit     = 100
+)
+
+func (s *HttpHandler) GetTopClans(c fiber.Ctx) error {
+	limit := fiber.Query[int](c, "limit", defaultClansLimit)
+	if limit <= 0 || limit > maxClansLimit {
+		limit = maxClansLimit
+	}
+	clans, err := s.cs.GetTopClans(limit)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(clans)
+}
+
+func (s *HttpHandler) GetClan(c fiber.Ctx) error {
+	clan, err := s.cs.GetClan(c.Params("clanId"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(clan)
+}
+
+// CreateClan creates a clan led by the authenticated user, who leaves their current clan for it
+func (s *HttpHandler) CreateClan(c fiber.Ctx) error {
+	req := &entities.CreateClanRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	clan, err := s.cs.CreateClan(middleware.AuthenticatedUserId(c), req.Name)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(clan)
+}
+
+func (s *HttpHandler) JoinClan(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	req := &entities.JoinClanRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.cs.Join(userId, req.ClanId); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) LeaveClan(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	if err := s.cs.Leave(userId); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) GetTournaments(c fiber.Ctx) error {
+	tournaments, err := s.tns.GetAll()
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(tournaments)
+}
+
+func (s *HttpHandler) GetTournament(c fiber.Ctx) error {
+	tournament, err := s.tns.Get(c.Params("tournamentId"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(tournament)
+}
+
+func (s *HttpHandler) CreateTournament(c fiber.Ctx) error {
+	req := &entities.TournamentRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	tournament, err := s.tns.Create(req)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(tournament)
+}
+
+func (s *HttpHandler) UpdateTournament(c fiber.Ctx) error {
+	req := &entities.TournamentRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	tournament, err := s.tns.Update(c.Params("tournamentId"), req)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(tournament)
+}
+
+func (s *HttpHandler) DeleteTournament(c fiber.Ctx) error {
+	if err := s.tns.Delete(c.Params("tournamentId")); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) GetTournamentLeaderboard(c fiber.Ctx) error {
+	userProfile, err := s.repo.GetUserProfileEventual(middleware.AuthenticatedUserId(c))
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	if userProfile == nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	tournamentLeaderboard, err := s.tns.GetLeaderboard(userProfile, c.Params("tournamentId"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(tournamentLeaderboard)
+}
+
+func (s *HttpHandler) GetUserTournaments(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	registrations, err := s.tns.GetUserRegistrations(userId)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(registrations)
+}
+
+func (s *HttpHandler) RegisterForTournament(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	if err := s.tns.Register(userId, c.Params("tournamentId")); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) UnregisterFromTournament(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	if err := s.tns.Unregister(userId, c.Params("tournamentId")); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) RegisterWebhook(c fiber.Ctx) error {
+	req := &entities.WebhookRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	webhook, err := s.ws.Register(req)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	c.Status(fiber.StatusCreated)
+	return c.JSON(webhook)
+}
+
+func (s *HttpHandler) GetWebhooks(c fiber.Ctx) error {
+	webhooks, err := s.ws.GetAll()
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(webhooks)
+}
+
+func (s *HttpHandler) DeleteWebhook(c fiber.Ctx) error {
+	if err := s.ws.Delete(c.Params("webhookId")); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetWebhookDeliveries lists the latest deliveries, ?status=failed narrows them down to the ones worth redelivering
+func (s *HttpHandler) GetWebhookDeliveries(c fiber.Ctx) error {
+	deliveries, err := s.ws.GetDeliveries(c.Params("webhookId"), c.Query("status"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(deliveries)
+}
+
+func (s *HttpHandler) RedeliverWebhook(c fiber.Ctx) error {
+	delivery, err := s.ws.Redeliver(c.Params("webhookId"), c.Params("deliveryId"))
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	c.Status(fiber.StatusAccepted)
+	return c.JSON(delivery)
+}
+
+func serviceErrorStatus(err error) int {
+	var cooldownErr *services.NicknameCooldownError
+	switch {
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrClanNotFound), errors.Is(err, services.ErrTournamentNotFound),
+		errors.Is(err, services.ErrWebhookNotFound), errors.Is(err, services.ErrWebhookDeliveryNotFound),
+		errors.Is(err, services.ErrLeaderboardNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrInvalidNickname), errors.Is(err, services.ErrInvalidMembership), errors.Is(err, services.ErrInvalidFriend),
+		errors.Is(err, services.ErrInvalidClanName), errors.Is(err, services.ErrInvalidTournament),
+		errors.Is(err, services.ErrInvalidWebhook), errors.Is(err, services.ErrInvalidStatsRange),
+		errors.Is(err, services.ErrInvalidHistoryRange), errors.Is(err, services.ErrInvalidActionLogQuery),
+		errors.Is(err, services.ErrInvalidTransferFormat), errors.Is(err, services.ErrInvalidLeaderboard):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrClanForbidden), errors.Is(err, services.ErrTournamentForbidden):
+		return fiber.StatusForbidden
+	case errors.Is(err, services.ErrOffensiveNickname), errors.Is(err, services.ErrOffensiveClanName):
+		return fiber.StatusUnprocessableEntity
+	case errors.Is(err, services.ErrNicknameTaken), errors.Is(err, services.ErrTooManyFriends), errors.Is(err, services.ErrTooManyFriendRequests),
+		errors.Is(err, services.ErrClanNameTaken),
+		errors.Is(err, services.ErrClanFull), errors.Is(err, services.ErrClanMembershipConflict), errors.Is(err, services.ErrTournamentClosed),
+		errors.Is(err, services.ErrLeaderboardExists), errors.Is(err, services.ErrLeaderboardClosed), errors.Is(err, services.ErrLeagueLeaderboard):
+		return fiber.StatusConflict
+	case errors.As(err, &cooldownErr):
+		return fiber.StatusTooManyRequests
+	case errors.Is(err, services.ErrWebhookQueueFull):
+		return fiber.StatusServiceUnavailable
+	}
+	slog.Error(err.Error())
+	return fiber.StatusInternalServerError
+}
+
+func parseModerationRequest(c fiber.Ctx) (*entities.ModerationRequest, bool) {
+	req := &entities.ModerationRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil || req.Reason == "" {
+		return nil, false
+	}
+	return req, true
+}
+
+func (s *HttpHandler) BanUser(c fiber.Ctx) error {
+	req, ok := parseModerationRequest(c)
+	if !ok {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.ms.Ban(c.Params("userId"), req.Reason); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) UnbanUser(c fiber.Ctx) error {
+	req, ok := parseModerationRequest(c)
+	if !ok {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.ms.Unban(c.Params("userId"), req.Reason); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) ShadowBanUser(c fiber.Ctx) error {
+	req, ok := parseModerationRequest(c)
+	if !ok {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.ms.ShadowBan(c.Params("userId"), req.Reason); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) AdjustUserScore(c fiber.Ctx) error {
+	req := &entities.AdjustmentRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil || req.Reason == "" {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.ms.AdjustScore(c.Params("userId"), req); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) AdjustUserXp(c fiber.Ctx) error {
+	req := &entities.AdjustmentRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil || req.Reason == "" {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	if err := s.ms.AdjustXp(c.Params("userId"), req); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) GetUserModerationAudit(c fiber.Ctx) error {
+	entries, err := s.ms.GetAudit(c.Params("userId"))
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(entries)
+}
+
+func (s *HttpHandler) ExportUserData(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	export, err := s.uds.Export(userId)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="user-%s.json"`, userId))
+	return c.JSON(export)
+}
+
+func (s *HttpHandler) ExportUserDataBackoffice(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	export, err := s.uds.ExportWithModeration(userId)
+	if err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="user-%s.json"`, userId))
+	return c.JSON(export)
+}
+
+func (s *HttpHandler) DeleteUser(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	if err := s.uds.Erase(userId); err != nil {
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (s *HttpHandler) ChangeNickname(c fiber.Ctx) error {
+	userId := c.Params("userId")
+	if userId != middleware.AuthenticatedUserId(c) {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	req := &entities.ChangeNicknameRequest{}
+	if err := json.Unmarshal(c.Body(), req); err != nil {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	nickname, err := s.ns.ChangeNickname(userId, req.Nickname)
+	if err != nil {
+		var cooldownErr *services.NicknameCooldownError
+		if errors.As(err, &cooldownErr) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(cooldownErr.RetryAfter.Seconds())+1))
+		}
+		return c.SendStatus(serviceErrorStatus(err))
+	}
+	return c.JSON(&entities.ChangeNicknameRequest{Nickname: nickname})
+}
+
+const (
+	defaultUserSearchLimit = 10
+	maxUserSearchLimit     = 50
+)
+
+func (s *HttpHandler) FindUsers(c fiber.Ctx) error {
+	return s.findUsers(c, false)
+}
+
+func (s *HttpHandler) FindUsersBackoffice(c fiber.Ctx) error {
+	return s.findUsers(c, true)
+}
+
+// findUsers looks a user up by exact nickname with ?nickname= or searches by nickname prefix with ?prefix=
+func (s *HttpHandler) findUsers(c fiber.Ctx, includeHidden bool) error {
+	if nickname := c.Query("nickname"); nickname != "" {
+		userProfile, err := s.ns.Lookup(nickname, includeHidden)
+		if err != nil {
+			slog.Error(err.Error())
+			return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		if userProfile == nil {
+			return c.JSON([]*entities.UserProfile{})
+		}
+		return c.JSON([]*entities.UserProfile{userProfile})
+	}
+	prefix := c.Query("prefix")
+	if prefix == "" {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}
+	limit := fiber.Query[int](c, "limit", defaultUserSearchLimit)
+	if limit <= 0 || limit > maxUserSearchLimit {
+		limit = maxUserSearchLimit
+	}
+	userProfiles, err := s.ns.Search(prefix, limit, includeHidden)
+	if err != nil {
+		slog.Error(err.Error())
+		return c.SendStatus(fiber.StatusInternalServerError)
+	}
+	return c.JSON(userProfiles)
+}
@@ -0,0 +1,78 @@
+package servers
+
+import (
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gofiber/fiber/v3"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/graceful_shutdown"
+	"github.com/skif48/leaderboard-engine/servers/middleware"
+	"github.com/skif48/leaderboard-engine/services"
+	"log/slog"
+)
+
+type gameEntry struct {
+	game *game_config.Game
+	gas  *services.GameActionsService
+}
+
+// GameRouter serves all games from one HTTP server and one Kafka consumer. Routes of every game are registered
+// under /games/:gameId, requests without the prefix are routed by their API key or go to the default game.
+type GameRouter struct {
+	app           *fiber.App
+	games         map[string]*gameEntry
+	defaultGameId string
+}
+
+func NewGameRouter(ac *app_config.AppConfig, games []*game_config.Game) *GameRouter {
+	app := fiber.New()
+
+	// registered before the game middleware, metrics of all games are served from the same endpoint
+	app.Get("/metrics", func(ctx fiber.Ctx) error {
+		metrics.WritePrometheus(ctx.Response().BodyWriter(), true)
+		return nil
+	})
+	app.Use(middleware.GameMiddleware(games, ac.GameApiKeys))
+	app.Use(middleware.MetricsMiddleware())
+
+	gr := &GameRouter{
+		app:   app,
+		games: make(map[string]*gameEntry, len(games)),
+	}
+	for _, game := range games {
+		if game.Default {
+			gr.defaultGameId = game.Id
+		}
+	}
+	return gr
+}
+
+// addGame returns the router the game's routes are registered on.
+func (gr *GameRouter) addGame(game *game_config.Game, gas *services.GameActionsService) fiber.Router {
+	gr.games[game.Id] = &gameEntry{game: game, gas: gas}
+	return gr.app.Group(middleware.GamePathPrefix + game.Id)
+}
+
+// game returns the game with the id, an empty id stands for the default game.
+func (gr *GameRouter) game(gameId string) (*gameEntry, bool) {
+	if gameId == "" {
+		gameId = gr.defaultGameId
+	}
+	entry, ok := gr.games[gameId]
+	return entry, ok
+}
+
+func RunHttpServer(ac *app_config.AppConfig, gr *GameRouter) {
+	graceful_shutdown.AddInputShutdownFunc(func() {
+		if err := gr.app.Shutdown(); err != nil {
+			slog.With("error", err).Error("Failed to shutdown fiber server")
+		}
+	})
+
+	go func() {
+		if err := gr.app.Listen(fmt.Sprintf(":%d", ac.FiberPort)); err != nil {
+			panic(err)
+		}
+	}()
+}
@@ -8,14 +8,14 @@ import (
 	"github.com/skif48/leaderboard-engine/app_config"
 	"github.com/skif48/leaderboard-engine/entities"
 	"github.com/skif48/leaderboard-engine/graceful_shutdown"
-	"github.com/skif48/leaderboard-engine/services"
 	"log/slog"
 	"sync"
 	"time"
 )
 
 type chMsg struct {
-	ga *entities.GameAction
+	ga   *entities.GameAction
+	game *gameEntry
 }
 
 type KafkaConsumer struct {
@@ -23,10 +23,11 @@ type KafkaConsumer struct {
 	ch        []chan *chMsg
 	workersWg *sync.WaitGroup
 
-	gas *services.GameActionsService
+	gr *GameRouter
 }
 
-func RunKafkaConsumer(ac *app_config.AppConfig, gas *services.GameActionsService) {
+// RunKafkaConsumer consumes actions of all games and hands each one to the game it was produced for
+func RunKafkaConsumer(ac *app_config.AppConfig, gr *GameRouter) {
 	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:        ac.KafkaBrokers,
 		GroupID:        ac.KafkaConsumerGroupId,
@@ -40,7 +41,7 @@ func RunKafkaConsumer(ac *app_config.AppConfig, gas *services.GameActionsService
 	kc := &KafkaConsumer{
 		r:         r,
 		ch:        make([]chan *chMsg, ac.KafkaLeaderboardTopicConsumerConcurrency),
-		gas:       gas,
+		gr:        gr,
 		workersWg: &sync.WaitGroup{},
 	}
 
@@ -76,12 +77,12 @@ func (kc *KafkaConsumer) runWorkers() {
 			ch := kc.ch[i]
 			for m := range ch {
 				start := time.Now()
-				if err := kc.gas.HandleAction(m.ga); err != nil {
-					slog.With("error", err).Error("Failed to handle action")
+				if err := m.game.gas.HandleAction(m.ga); err != nil {
+					slog.With("error", err, "game", m.game.game.Id).Error("Failed to handle action")
 					continue
 				}
-				metrics.GetOrCreateCounter(`kafka_processed_messages{topic="leaderboard"}`).Inc()
-				metrics.GetOrCreateHistogram(`kafka_processing_time_milliseconds{topic="leaderboard"}`).Update(float64(time.Since(start).Milliseconds()))
+				metrics.GetOrCreateCounter(m.game.game.MetricName(`kafka_processed_messages{topic="leaderboard"}`)).Inc()
+				metrics.GetOrCreateHistogram(m.game.game.MetricName(`kafka_processing_time_milliseconds{topic="leaderboard"}`)).Update(float64(time.Since(start).Milliseconds()))
 			}
 		}(i)
 	}
@@ -101,10 +102,18 @@ func (kc *KafkaConsumer) listen(ctx context.Context) {
 			slog.Error(err.Error())
 			continue
 		}
+		// actions produced before games were introduced have no game id and belong to the default game
+		game, ok := kc.gr.game(gameAction.GameId)
+		if !ok {
+			slog.With("game", gameAction.GameId).Warn("Skipping action of unknown game")
+			metrics.GetOrCreateCounter(`kafka_skipped_messages{topic="leaderboard", reason="unknown_game"}`).Inc()
+			continue
+		}
 		leaderboardId := gameAction.LeaderboardId
 		channelId := leaderboardId % len(kc.ch)
 		kc.ch[channelId] <- &chMsg{
-			ga: gameAction,
+			ga:   gameAction,
+			game: game,
 		}
 	}
 }
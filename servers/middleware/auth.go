@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/skif48/leaderboard-engine/auth"
+	"strings"
+)
+
+const UserIdLocal = "userId"
+
+// AuthMiddleware verifies the bearer token and stores the user id it was issued for in ctx locals.
+func AuthMiddleware(ts *auth.TokenService) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		token, found := strings.CutPrefix(ctx.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !found || token == "" {
+			return ctx.SendStatus(fiber.StatusUnauthorized)
+		}
+		userId, err := ts.Verify(token)
+		if err != nil {
+			return ctx.SendStatus(fiber.StatusUnauthorized)
+		}
+		ctx.Locals(UserIdLocal, userId)
+		return ctx.Next()
+	}
+}
+
+// AuthenticatedUserId returns the user id set by AuthMiddleware.
+func AuthenticatedUserId(ctx fiber.Ctx) string {
+	userId, _ := ctx.Locals(UserIdLocal).(string)
+	return userId
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"strings"
+)
+
+const (
+	GameIdLocal = "gameId"
+	// GamePathPrefix is followed by the game id in paths of game routes
+	GamePathPrefix = "/games/"
+)
+
+// GameMiddleware picks the game of the request: from the /games/:gameId path prefix, else from the API key, else the
+// default game. Unprefixed requests are rewritten to the prefixed path, which is where game routes are registered.
+// Once API keys are configured, a request with a key that isn't one of them is rejected rather than served by the
+// default game.
+func GameMiddleware(games []*game_config.Game, apiKeys map[string]string) fiber.Handler {
+	known := make(map[string]bool, len(games))
+	defaultGameId := ""
+	for _, game := range games {
+		known[game.Id] = true
+		if game.Default {
+			defaultGameId = game.Id
+		}
+	}
+	for apiKey, gameId := range apiKeys {
+		if apiKey == "" || !known[gameId] {
+			panic(fmt.Sprintf("api key is mapped to unknown game %q", gameId))
+		}
+	}
+	return func(ctx fiber.Ctx) error {
+		path := ctx.Path()
+		gameId := ""
+		if rest, ok := strings.CutPrefix(path, GamePathPrefix); ok {
+			gameId, _, _ = strings.Cut(rest, "/")
+			if !known[gameId] {
+				return ctx.SendStatus(fiber.StatusNotFound)
+			}
+		}
+		if apiKey := ctx.Get(ApiKeyHeader); apiKey != "" && len(apiKeys) > 0 {
+			keyGameId, ok := apiKeys[apiKey]
+			if !ok {
+				return ctx.SendStatus(fiber.StatusUnauthorized)
+			}
+			if gameId != "" && gameId != keyGameId {
+				return ctx.SendStatus(fiber.StatusForbidden)
+			}
+			gameId = keyGameId
+		}
+		if gameId == "" {
+			gameId = defaultGameId
+		}
+		if !strings.HasPrefix(path, GamePathPrefix) {
+			// fiber matches the remaining routes against the path as it is when Next is called, so the
+			// rewritten request continues on the game's routes. game_test.go pins this down.
+			ctx.Path(GamePathPrefix + gameId + path)
+		}
+		ctx.Locals(GameIdLocal, gameId)
+		return ctx.Next()
+	}
+}
+
+// GameId returns the game id set by GameMiddleware.
+func GameId(ctx fiber.Ctx) string {
+	gameId, _ := ctx.Locals(GameIdLocal).(string)
+	return gameId
+}
+
+// gamePath is the request path as the client addressed the game, without the game prefix
+func gamePath(ctx fiber.Ctx) string {
+	return strings.TrimPrefix(ctx.Path(), GamePathPrefix+GameId(ctx))
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+// newGameTestApp registers game routes the way GameRouter does, one group per game on the root app behind the
+// game middleware, each route answering with the game it was registered for and the game id local
+func newGameTestApp(apiKeys map[string]string) *fiber.App {
+	games := []*game_config.Game{{Id: "alpha", Default: true}, {Id: "beta"}}
+	app := fiber.New()
+	app.Use(GameMiddleware(games, apiKeys))
+	for _, game := range games {
+		app.Group(GamePathPrefix+game.Id).Get("/ping", func(ctx fiber.Ctx) error {
+			return ctx.SendString(game.Id + "/" + GameId(ctx))
+		})
+	}
+	return app
+}
+
+func TestGameMiddlewareRouting(t *testing.T) {
+	apiKeys := map[string]string{"alpha-key": "alpha", "beta-key": "beta"}
+	tests := []struct {
+		name   string
+		path   string
+		apiKey string
+		status int
+		body   string
+	}{
+		{name: "default game", path: "/ping", status: fiber.StatusOK, body: "alpha/alpha"},
+		{name: "rewritten by api key", path: "/ping", apiKey: "beta-key", status: fiber.StatusOK, body: "beta/beta"},
+		{name: "prefixed", path: "/games/beta/ping", status: fiber.StatusOK, body: "beta/beta"},
+		{name: "prefixed with matching api key", path: "/games/beta/ping", apiKey: "beta-key", status: fiber.StatusOK, body: "beta/beta"},
+		{name: "prefixed with another game's api key", path: "/games/beta/ping", apiKey: "alpha-key", status: fiber.StatusForbidden},
+		{name: "unknown api key", path: "/ping", apiKey: "other-key", status: fiber.StatusUnauthorized},
+		{name: "unknown game", path: "/games/gamma/ping", status: fiber.StatusNotFound},
+		{name: "unknown route", path: "/pong", apiKey: "beta-key", status: fiber.StatusNotFound},
+	}
+	app := newGameTestApp(apiKeys)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodGet, tt.path, nil)
+			if tt.apiKey != "" {
+				req.Header.Set(ApiKeyHeader, tt.apiKey)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.body == "" {
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.body {
+				t.Fatalf("body %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestGameMiddlewareWithoutApiKeys(t *testing.T) {
+	app := newGameTestApp(nil)
+	req := httptest.NewRequest(fiber.MethodGet, "/ping", nil)
+	// without API keys configured, a key doesn't pick the game
+	req.Header.Set(ApiKeyHeader, "partner-key")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != fiber.StatusOK || string(body) != "alpha/alpha" {
+		t.Fatalf("status %d with body %q, want 200 with %q", resp.StatusCode, body, "alpha/alpha")
+	}
+}
@@ -7,12 +7,13 @@ import (
 	"time"
 )
 
+// MetricsMiddleware records requests by game, so it has to run after GameMiddleware
 func MetricsMiddleware() fiber.Handler {
 	return func(ctx fiber.Ctx) error {
 		start := time.Now()
 		defer func() {
-			metrics.GetOrCreateCounter(fmt.Sprintf(`http_requests_total{path=%q, method=%q, status="%d"}`, ctx.Path(), ctx.Method(), ctx.Response().StatusCode())).Inc()
-			metrics.GetOrCreateHistogram(fmt.Sprintf(`http_requests_latency{path=%q, method=%q, status="%d"}`, ctx.Path(), ctx.Method(), ctx.Response().StatusCode())).UpdateDuration(start)
+			metrics.GetOrCreateCounter(fmt.Sprintf(`http_requests_total{game=%q, path=%q, method=%q, status="%d"}`, GameId(ctx), gamePath(ctx), ctx.Method(), ctx.Response().StatusCode())).Inc()
+			metrics.GetOrCreateHistogram(fmt.Sprintf(`http_requests_latency{game=%q, path=%q, method=%q, status="%d"}`, GameId(ctx), gamePath(ctx), ctx.Method(), ctx.Response().StatusCode())).UpdateDuration(start)
 		}()
 		return ctx.Next()
 	}
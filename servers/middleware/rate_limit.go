@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gofiber/fiber/v3"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+)
+
+const ApiKeyHeader = "X-Api-Key"
+
+// RateLimitMiddleware limits requests per authenticated user and per API key, so it has to run after AuthMiddleware.
+// Only configured API keys get the API key limit, requests without one are limited per client IP instead, so
+// made-up keys can't buy a fresh limit each. Limiter failures are logged and the request is let through, a Redis
+// hiccup should not take the API down.
+func RateLimitMiddleware(rl repositories.RateLimiterRepository, ac *app_config.AppConfig) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		if userId := AuthenticatedUserId(ctx); userId != "" {
+			if allowed, retryAfter := hit(rl, "user", userId, ac.RateLimitUserRequests, ac.RateLimitUserWindow); !allowed {
+				return reject(ctx, "user", retryAfter)
+			}
+		}
+		if apiKey := ctx.Get(ApiKeyHeader); apiKey != "" && ac.GameApiKeys[apiKey] != "" {
+			if allowed, retryAfter := hit(rl, "api_key", apiKey, ac.RateLimitApiKeyRequests, ac.RateLimitApiKeyWindow); !allowed {
+				return reject(ctx, "api_key", retryAfter)
+			}
+		} else if allowed, retryAfter := hit(rl, "ip", ctx.IP(), ac.RateLimitIpRequests, ac.RateLimitIpWindow); !allowed {
+			return reject(ctx, "ip", retryAfter)
+		}
+		return ctx.Next()
+	}
+}
+
+func hit(rl repositories.RateLimiterRepository, limiter string, id string, limit int, window time.Duration) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	allowed, retryAfter, err := rl.Hit(limiter+":"+id, limit, window)
+	if err != nil {
+		slog.With("error", err, "limiter", limiter).Error("Failed to check rate limit")
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+func reject(ctx fiber.Ctx, limiter string, retryAfter time.Duration) error {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`rate_limit_rejected_requests_total{game=%q, limiter=%q, path=%q}`, GameId(ctx), limiter, gamePath(ctx))).Inc()
+	ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+	return ctx.SendStatus(fiber.StatusTooManyRequests)
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rateLimiterMemory counts hits per key and never lets a window pass
+type rateLimiterMemory struct {
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func (r *rateLimiterMemory) Hit(key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hits[key]++
+	if r.hits[key] > limit {
+		return false, window, nil
+	}
+	return true, 0, nil
+}
+
+func TestRateLimitMiddlewareApiKeys(t *testing.T) {
+	ac := &app_config.AppConfig{
+		GameApiKeys:             map[string]string{"partner-key": "alpha"},
+		RateLimitApiKeyRequests: 3,
+		RateLimitApiKeyWindow:   time.Second,
+		RateLimitIpRequests:     2,
+		RateLimitIpWindow:       time.Second,
+	}
+	rl := &rateLimiterMemory{hits: make(map[string]int)}
+	app := fiber.New()
+	app.Get("/ping", func(ctx fiber.Ctx) error {
+		return ctx.SendStatus(fiber.StatusOK)
+	}, RateLimitMiddleware(rl, ac))
+	request := func(apiKey string) int {
+		req := httptest.NewRequest(fiber.MethodGet, "/ping", nil)
+		if apiKey != "" {
+			req.Header.Set(ApiKeyHeader, apiKey)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// made-up keys and requests without a key share the limit of the client IP
+	for i, apiKey := range []string{"", "made-up-1", "made-up-2"} {
+		want := fiber.StatusOK
+		if i >= ac.RateLimitIpRequests {
+			want = fiber.StatusTooManyRequests
+		}
+		if status := request(apiKey); status != want {
+			t.Fatalf("request %d with key %q: status %d, want %d", i, apiKey, status, want)
+		}
+	}
+	// a configured key has its own limit
+	for i := 0; i < ac.RateLimitApiKeyRequests; i++ {
+		if status := request("partner-key"); status != fiber.StatusOK {
+			t.Fatalf("partner request %d: status %d, want 200", i, status)
+		}
+	}
+	if status := request("partner-key"); status != fiber.StatusTooManyRequests {
+		t.Fatalf("partner request over the limit: status %d, want 429", status)
+	}
+}
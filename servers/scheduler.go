@@ -0,0 +1,57 @@
+package servers
+
+import (
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/graceful_shutdown"
+	"github.com/skif48/leaderboard-engine/services"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// runPeriodically runs the job every interval until shutdown, which waits for a running job to finish
+func runPeriodically(game *game_config.Game, name string, interval time.Duration, job func() error) {
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := job(); err != nil {
+					slog.With("job", name, "game", game.Id, "error", err).Error("Periodic job failed")
+				}
+			}
+		}
+	}()
+	graceful_shutdown.AddInputShutdownFunc(func() {
+		close(stop)
+		wg.Wait()
+		slog.With("job", name, "game", game.Id).Info("Periodic job stopped")
+	})
+}
+
+func RunLeagueScheduler(ac *app_config.AppConfig, game *game_config.Game, ls *services.LeagueService) {
+	if !ls.Enabled() {
+		return
+	}
+	runPeriodically(game, "league_cycle", ac.LeagueCycleCheckInterval, ls.CloseEndedCycle)
+}
+
+func RunActionStatsFlusher(ac *app_config.AppConfig, game *game_config.Game, ass *services.ActionStatsService) {
+	runPeriodically(game, "action_stats_flush", ac.ActionStatsFlushInterval, ass.Flush)
+}
+
+func RunRankSnapshotter(ac *app_config.AppConfig, game *game_config.Game, rhs *services.RankHistoryService) {
+	runPeriodically(game, "rank_snapshot", ac.RankSnapshotInterval, rhs.Snapshot)
+}
+
+func RunTournamentScheduler(ac *app_config.AppConfig, game *game_config.Game, ts *services.TournamentService) {
+	runPeriodically(game, "tournament_close", ac.TournamentCloseCheckInterval, ts.CloseEnded)
+}
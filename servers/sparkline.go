@@ -0,0 +1,38 @@
+package servers
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+const (
+	sparklineWidth  = 80
+	sparklineHeight = 20
+)
+
+// sparkline renders positions as an inline SVG line, better positions are drawn higher
+func sparkline(positions []int) template.HTML {
+	if len(positions) < 2 {
+		return ""
+	}
+	best, worst := positions[0], positions[0]
+	for _, position := range positions {
+		best = min(best, position)
+		worst = max(worst, position)
+	}
+	points := make([]string, 0, len(positions))
+	for i, position := range positions {
+		x := float64(i) * sparklineWidth / float64(len(positions)-1)
+		y := float64(sparklineHeight) / 2
+		if worst > best {
+			y = 1 + float64(position-best)*(sparklineHeight-2)/float64(worst-best)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d"><title>#%d → #%d</title><polyline points="%s"/></svg>`,
+		sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight,
+		positions[0], positions[len(positions)-1], strings.Join(points, " "),
+	))
+}
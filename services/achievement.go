@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"time"
+)
+
+// AchievementProgress is where the user stands after a single action
+type AchievementProgress struct {
+	Action      string
+	ActionCount int
+	Level       int
+	// Position is the best visible position over the user's leaderboards after the action, 0 when it wasn't looked up
+	Position int
+}
+
+// AchievementService unlocks the achievements declared in the game config as users play
+type AchievementService struct {
+	acr   repositories.ActionCounterRepository
+	ar    repositories.AchievementRepository
+	ep    *EventPublisher
+	gc    *game_config.GameConfig
+	topic string
+	game  *game_config.Game
+}
+
+func NewAchievementService(ac *app_config.AppConfig, game *game_config.Game, gc *game_config.GameConfig, acr repositories.ActionCounterRepository, ar repositories.AchievementRepository, ep *EventPublisher) *AchievementService {
+	return &AchievementService{
+		acr:   acr,
+		ar:    ar,
+		ep:    ep,
+		gc:    gc,
+		topic: ac.KafkaAchievementsTopic,
+		game:  game,
+	}
+}
+
+// HandleProgress unlocks every achievement whose goal the user has reached. Goals are checked against where the
+// user stands rather than crossed by the action, so an unlock missed because of a failure, or a goal reached
+// before the achievement was configured, is caught up on the next action. Events are only published when publish
+// is set, shadow-banned users still see their achievements but must not trigger rewards downstream.
+func (a *AchievementService) HandleProgress(userId string, progress *AchievementProgress, publish bool) error {
+	reached := make([]*game_config.AchievementConfig, 0)
+	for i := range a.gc.Achievements {
+		if achievementReached(&a.gc.Achievements[i], progress) {
+			reached = append(reached, &a.gc.Achievements[i])
+		}
+	}
+	if len(reached) == 0 {
+		return nil
+	}
+	// already unlocked achievements are skipped with one read instead of a lightweight transaction each
+	unlockedAchievements, err := a.ar.GetUserAchievements(userId)
+	if err != nil {
+		return err
+	}
+	alreadyUnlocked := make(map[string]bool, len(unlockedAchievements))
+	for _, unlocked := range unlockedAchievements {
+		alreadyUnlocked[unlocked.AchievementId] = true
+	}
+	var events []Event
+	for _, achievement := range reached {
+		if alreadyUnlocked[achievement.Id] {
+			continue
+		}
+		unlocked := &entities.UserAchievement{
+			UserId:        userId,
+			AchievementId: achievement.Id,
+			UnlockedAt:    time.Now().UnixMilli(),
+		}
+		applied, err := a.ar.Unlock(unlocked)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			continue
+		}
+		metrics.GetOrCreateCounter(a.game.MetricName(fmt.Sprintf(`achievements_unlocked_total{achievement=%q}`, achievement.Id))).Inc()
+		if publish {
+			events = append(events, Event{Key: userId, Payload: &entities.AchievementUnlockedEvent{
+				UserId:        userId,
+				AchievementId: achievement.Id,
+				Name:          achievement.Name,
+				UnlockedAt:    unlocked.UnlockedAt,
+			}})
+		}
+	}
+	return a.ep.Publish(a.topic, events...)
+}
+
+// achievementReached tells whether the user stands at or past the goal of the achievement
+func achievementReached(achievement *game_config.AchievementConfig, progress *AchievementProgress) bool {
+	switch achievement.Type {
+	case game_config.AchievementActionCount:
+		return achievement.Action == progress.Action && progress.ActionCount >= achievement.Goal
+	case game_config.AchievementLevel:
+		return progress.Level >= achievement.Goal
+	case game_config.AchievementTopPosition:
+		return progress.Position > 0 && progress.Position <= achievement.Goal
+	}
+	return false
+}
+
+// GetUserAchievements lists every configured achievement with the user's progress towards it
+func (a *AchievementService) GetUserAchievements(userProfile *entities.UserProfile) ([]*entities.Achievement, error) {
+	unlocked, err := a.ar.GetUserAchievements(userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	unlockedAt := make(map[string]int64, len(unlocked))
+	for _, achievement := range unlocked {
+		unlockedAt[achievement.AchievementId] = achievement.UnlockedAt
+	}
+	counters, err := a.acr.GetCounters(userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	achievements := make([]*entities.Achievement, 0, len(a.gc.Achievements))
+	for _, config := range a.gc.Achievements {
+		achievement := &entities.Achievement{
+			Id:          config.Id,
+			Name:        config.Name,
+			Description: config.Description,
+			Goal:        config.Goal,
+		}
+		switch config.Type {
+		case game_config.AchievementActionCount:
+			achievement.Progress = min(counters[config.Action], config.Goal)
+		case game_config.AchievementLevel:
+			achievement.Progress = min(userProfile.Level, config.Goal)
+		}
+		if at, ok := unlockedAt[config.Id]; ok {
+			achievement.Unlocked = true
+			achievement.UnlockedAt = at
+			achievement.Progress = config.Goal
+		}
+		achievements = append(achievements, achievement)
+	}
+	return achievements, nil
+}
+
+func (a *AchievementService) GetUnlocked(userId string) ([]*entities.UserAchievement, error) {
+	return a.ar.GetUserAchievements(userId)
+}
+
+func (a *AchievementService) EraseUser(userId string) error {
+	return a.ar.DeleteUserAchievements(userId)
+}
+
+func (a *AchievementService) Purge() error {
+	return a.ar.Purge()
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gocql/gocql"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/graceful_shutdown"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	actionLogDefaultDays  = 7
+	actionLogMaxDays      = 31
+	actionLogDefaultLimit = 50
+	actionLogMaxLimit     = 500
+)
+
+var ErrInvalidActionLogQuery = errors.New("invalid action log query")
+
+// ActionLogService keeps an audit log of every consumed game action for dispute resolution. The consumer only
+// hands entries over, a background writer stores them in batches and applies backpressure when it falls behind.
+// Entries still buffered at shutdown are flushed before the service stops.
+type ActionLogService struct {
+	alr           repositories.ActionLogRepository
+	retention     time.Duration
+	batchSize     int
+	flushInterval time.Duration
+	game          *game_config.Game
+
+	entries chan *entities.ActionLogEntry
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewActionLogService(ac *app_config.AppConfig, game *game_config.Game, alr repositories.ActionLogRepository) *ActionLogService {
+	a := &ActionLogService{
+		alr:           alr,
+		retention:     ac.ActionLogRetention,
+		batchSize:     ac.ActionLogBatchSize,
+		flushInterval: ac.ActionLogFlushInterval,
+		entries:       make(chan *entities.ActionLogEntry, ac.ActionLogBufferSize),
+		stop:          make(chan struct{}),
+		game:          game,
+	}
+	a.wg.Add(1)
+	go a.write()
+	graceful_shutdown.AddOutputShutdownFunc(func() {
+		close(a.stop)
+		a.wg.Wait()
+		slog.Info("Action log writer stopped")
+	})
+	return a
+}
+
+// Record queues the entry, blocking while the buffer is full. Entries recorded after shutdown are dropped.
+func (a *ActionLogService) Record(entry *entities.ActionLogEntry) {
+	processedAt := time.UnixMilli(entry.ProcessedAt)
+	entry.Id = gocql.UUIDFromTime(processedAt).String()
+	select {
+	case a.entries <- entry:
+	case <-a.stop:
+		metrics.GetOrCreateCounter(a.game.MetricName(`action_log_dropped_total{reason="shutdown"}`)).Inc()
+	}
+}
+
+func (a *ActionLogService) write() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*entities.ActionLogEntry, 0, a.batchSize)
+	for {
+		select {
+		case entry := <-a.entries:
+			batch = append(batch, entry)
+			if len(batch) >= a.batchSize {
+				batch = a.flush(batch)
+			}
+		case <-ticker.C:
+			batch = a.flush(batch)
+		case <-a.stop:
+			for {
+				select {
+				case entry := <-a.entries:
+					batch = append(batch, entry)
+				default:
+					a.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush stores the batch and returns it emptied for reuse, a failed batch is logged and dropped
+func (a *ActionLogService) flush(batch []*entities.ActionLogEntry) []*entities.ActionLogEntry {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := a.alr.Save(batch, a.retention); err != nil {
+		slog.With("error", err, "entries", len(batch)).Error("Failed to write action log")
+		metrics.GetOrCreateCounter(a.game.MetricName(`action_log_dropped_total{reason="write_failed"}`)).Add(len(batch))
+	} else {
+		metrics.GetOrCreateCounter(a.game.MetricName(`action_log_written_total`)).Add(len(batch))
+	}
+	return batch[:0]
+}
+
+// GetUserLog pages through the user's log latest first, over the "2006-01-02" days [from, to] or the last 7 days.
+// before is the Next cursor of the previous page.
+func (a *ActionLogService) GetUserLog(userId string, from string, to string, before string, limit int) (*entities.ActionLogPage, error) {
+	fromDay, toDay, ok := parseDayRange(from, to, actionLogDefaultDays, actionLogMaxDays)
+	if _, err := gocql.ParseUUID(userId); err != nil {
+		return nil, ErrInvalidActionLogQuery
+	}
+	if !ok || limit < 0 || limit > actionLogMaxLimit {
+		return nil, ErrInvalidActionLogQuery
+	}
+	if limit == 0 {
+		limit = actionLogDefaultLimit
+	}
+	day := toDay
+	if before != "" {
+		cursor, err := gocql.ParseUUID(before)
+		if err != nil {
+			return nil, ErrInvalidActionLogQuery
+		}
+		day = cursor.Time().UTC().Truncate(24 * time.Hour)
+	}
+	page := &entities.ActionLogPage{Entries: make([]*entities.ActionLogEntry, 0, limit)}
+	for ; !day.Before(fromDay) && len(page.Entries) < limit; day = day.AddDate(0, 0, -1) {
+		entries, err := a.alr.GetUserLog(userId, day.Format(actionStatsDayLayout), before, limit-len(page.Entries))
+		if err != nil {
+			return nil, err
+		}
+		page.Entries = append(page.Entries, entries...)
+		before = ""
+	}
+	if len(page.Entries) == limit {
+		page.Next = page.Entries[len(page.Entries)-1].Id
+	}
+	return page, nil
+}
+
+// GetRetainedLog returns the user's whole log still within retention, latest first, for data exports
+func (a *ActionLogService) GetRetainedLog(userId string) ([]*entities.ActionLogEntry, error) {
+	to := time.Now()
+	days := historyDays(to.Add(-a.retention), to)
+	log := make([]*entities.ActionLogEntry, 0)
+	for _, day := range slices.Backward(days) {
+		before := ""
+		for {
+			entries, err := a.alr.GetUserLog(userId, day, before, actionLogMaxLimit)
+			if err != nil {
+				return nil, err
+			}
+			log = append(log, entries...)
+			if len(entries) < actionLogMaxLimit {
+				break
+			}
+			before = entries[len(entries)-1].Id
+		}
+	}
+	return log, nil
+}
+
+// EraseUser removes the user's log of every day still within retention
+func (a *ActionLogService) EraseUser(userId string) error {
+	to := time.Now()
+	return a.alr.DeleteUserLog(userId, historyDays(to.Add(-a.retention), to))
+}
+
+func (a *ActionLogService) Purge() error {
+	return a.alr.Purge()
+}
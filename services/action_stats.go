@@ -0,0 +1,199 @@
+package services
+
+import (
+	"errors"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gocql/gocql"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	actionStatsDayLayout   = "2006-01-02"
+	actionStatsDefaultDays = 30
+	actionStatsMaxDays     = 366
+)
+
+var ErrInvalidStatsRange = errors.New("invalid stats range")
+
+// ActionStatsService counts the actions every user performs. Counting happens in Redis on the hot path and the
+// counters are flushed to Scylla periodically, reads merge the stored counters with the ones not flushed yet.
+type ActionStatsService struct {
+	acr       repositories.ActionCounterRepository
+	asr       repositories.ActionStatsRepository
+	flushSize int
+	game      *game_config.Game
+}
+
+func NewActionStatsService(ac *app_config.AppConfig, game *game_config.Game, acr repositories.ActionCounterRepository, asr repositories.ActionStatsRepository) *ActionStatsService {
+	return &ActionStatsService{
+		acr:       acr,
+		asr:       asr,
+		flushSize: ac.ActionStatsFlushBatchSize,
+		game:      game,
+	}
+}
+
+// CountAction counts the action on the UTC day it was performed at and returns the user's all-time count of it
+func (a *ActionStatsService) CountAction(userId string, action string, at time.Time) (int, error) {
+	return a.acr.Increment(userId, action, at.UTC().Format(actionStatsDayLayout))
+}
+
+// Flush moves pending counters to Scylla until none are left. Counters stay inflight in Redis until Scylla
+// stored them, a user whose counters failed to be stored is handed out again by the next flush, and one left
+// inflight by a crash is picked up with their next action. Counters are incremented, so a batch that timed out
+// and may have been applied is not retried, counting an action twice is worse than losing it.
+func (a *ActionStatsService) Flush() error {
+	failed := make([]string, 0)
+	defer func() {
+		if err := a.acr.MarkDirty(failed...); err != nil {
+			slog.With("error", err).Error("Failed to keep action stats for the next flush")
+		}
+	}()
+	for {
+		userIds, err := a.acr.PopDirtyUsers(a.flushSize)
+		if err != nil {
+			return err
+		}
+		for i, userId := range userIds {
+			pending, err := a.acr.ClaimPending(userId)
+			if err != nil {
+				// the users not flushed yet were already popped, they have to stay dirty as well
+				failed = append(failed, userIds[i:]...)
+				return err
+			}
+			if err := a.asr.Add(userId, pending); err != nil {
+				slog.With("error", err, "userId", userId).Error("Failed to flush action stats")
+				if !counterWriteOutcomeUnknown(err) {
+					failed = append(failed, userId)
+					continue
+				}
+				metrics.GetOrCreateCounter(a.game.MetricName(`action_stats_unconfirmed_users_total`)).Inc()
+			}
+			morePending, err := a.acr.ConfirmPending(userId)
+			if err != nil {
+				failed = append(failed, userIds[i:]...)
+				return err
+			}
+			if morePending {
+				failed = append(failed, userId)
+			}
+		}
+		metrics.GetOrCreateCounter(a.game.MetricName(`action_stats_flushed_users_total`)).Add(len(userIds))
+		if len(userIds) < a.flushSize {
+			return nil
+		}
+	}
+}
+
+// counterWriteOutcomeUnknown tells whether a failed counter write may have been applied anyway
+func counterWriteOutcomeUnknown(err error) bool {
+	var writeTimeout *gocql.RequestErrWriteTimeout
+	return errors.As(err, &writeTimeout) || errors.Is(err, gocql.ErrTimeoutNoResponse)
+}
+
+// GetUserStats returns all-time counters and the daily ones within [from, to], days as "2006-01-02".
+// Without a range the last 30 days are returned.
+func (a *ActionStatsService) GetUserStats(userId string, from string, to string) (*entities.UserActionStats, error) {
+	fromDay, toDay, ok := parseDayRange(from, to, actionStatsDefaultDays, actionStatsMaxDays)
+	if !ok {
+		return nil, ErrInvalidStatsRange
+	}
+	from, to = fromDay.Format(actionStatsDayLayout), toDay.Format(actionStatsDayLayout)
+	daily, err := a.asr.GetDaily(userId, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return a.userStats(userId, daily, func(day string) bool {
+		return day >= from && day <= to
+	})
+}
+
+// GetAllStats returns all-time counters and the daily ones of every day, for data exports
+func (a *ActionStatsService) GetAllStats(userId string) (*entities.UserActionStats, error) {
+	daily, err := a.asr.GetAllDaily(userId)
+	if err != nil {
+		return nil, err
+	}
+	return a.userStats(userId, daily, func(string) bool {
+		return true
+	})
+}
+
+// userStats merges the stored daily counters with the totals and the counters not flushed yet of the days in range
+func (a *ActionStatsService) userStats(userId string, daily map[string]map[string]int, inRange func(day string) bool) (*entities.UserActionStats, error) {
+	totals, err := a.asr.GetTotals(userId)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := a.acr.GetPending(userId)
+	if err != nil {
+		return nil, err
+	}
+	for day, counters := range pending {
+		for action, count := range counters {
+			totals[action] += count
+			if !inRange(day) {
+				continue
+			}
+			if daily[day] == nil {
+				daily[day] = make(map[string]int)
+			}
+			daily[day][action] += count
+		}
+	}
+	stats := &entities.UserActionStats{
+		UserId: userId,
+		Total:  totals,
+		Daily:  make([]*entities.DailyActionStats, 0, len(daily)),
+	}
+	for _, day := range slices.Sorted(maps.Keys(daily)) {
+		stats.Daily = append(stats.Daily, &entities.DailyActionStats{Day: day, Actions: daily[day]})
+	}
+	return stats, nil
+}
+
+// parseDayRange parses an inclusive range of "2006-01-02" days, defaulting to the last defaultDays days up to today.
+// It reports false for unparsable days or a range longer than maxDays.
+func parseDayRange(from string, to string, defaultDays int, maxDays int) (time.Time, time.Time, bool) {
+	toDay := time.Now().UTC().Truncate(24 * time.Hour)
+	if to != "" {
+		parsed, err := time.Parse(actionStatsDayLayout, to)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		toDay = parsed
+	}
+	fromDay := toDay.AddDate(0, 0, -(defaultDays - 1))
+	if from != "" {
+		parsed, err := time.Parse(actionStatsDayLayout, from)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		fromDay = parsed
+	}
+	if fromDay.After(toDay) || toDay.Sub(fromDay) >= time.Duration(maxDays)*24*time.Hour {
+		return time.Time{}, time.Time{}, false
+	}
+	return fromDay, toDay, true
+}
+
+func (a *ActionStatsService) EraseUser(userId string) error {
+	if err := a.acr.DeleteCounters(userId); err != nil {
+		return err
+	}
+	return a.asr.DeleteUserStats(userId)
+}
+
+func (a *ActionStatsService) Purge() error {
+	if err := a.acr.Purge(); err != nil {
+		return err
+	}
+	return a.asr.Purge()
+}
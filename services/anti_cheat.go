@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	StageProduce = "produce"
+	StageConsume = "consume"
+
+	RuleTimestampSkew   = "timestamp_skew"
+	RuleActionFrequency = "action_frequency"
+	RuleActionSequence  = "action_sequence"
+)
+
+type RuleViolation struct {
+	Rule   string
+	Reason string
+}
+
+// AntiCheatService evaluates plausibility rules from GameConfig against incoming actions.
+// Rule state is kept per stage, so an action checked at produce time is counted again independently at consume time.
+type AntiCheatService struct {
+	acr  repositories.AntiCheatRepository
+	qr   repositories.QuarantineRepository
+	cfg  game_config.AntiCheatConfig
+	game *game_config.Game
+}
+
+func NewAntiCheatService(game *game_config.Game, gc *game_config.GameConfig, acr repositories.AntiCheatRepository, qr repositories.QuarantineRepository) *AntiCheatService {
+	return &AntiCheatService{
+		acr:  acr,
+		qr:   qr,
+		cfg:  gc.AntiCheat,
+		game: game,
+	}
+}
+
+// Check returns the first rule the action violates, or nil if the action looks plausible.
+func (a *AntiCheatService) Check(stage string, action *entities.GameAction) (*RuleViolation, error) {
+	receivedAt := time.UnixMilli(action.ReceivedAt)
+	if action.ReceivedAt == 0 {
+		receivedAt = time.Now()
+	}
+
+	if a.cfg.MaxTimestampSkewSeconds > 0 {
+		skew := math.Abs(float64(receivedAt.UnixMilli())/1000 - action.Timestamp)
+		if skew > a.cfg.MaxTimestampSkewSeconds {
+			return &RuleViolation{
+				Rule:   RuleTimestampSkew,
+				Reason: fmt.Sprintf("timestamp is %.0fs away from server time", skew),
+			}, nil
+		}
+	}
+
+	if rule, ok := a.cfg.MaxActionFrequency[action.Action]; ok {
+		window := time.Duration(rule.WindowSeconds * float64(time.Second))
+		count, err := a.acr.CountAction(stage, action.UserId, action.Action, receivedAt, window)
+		if err != nil {
+			return nil, err
+		}
+		if count > rule.MaxCount {
+			return &RuleViolation{
+				Rule:   RuleActionFrequency,
+				Reason: fmt.Sprintf("%s performed %d times within %.0fs, max is %d", action.Action, count, rule.WindowSeconds, rule.MaxCount),
+			}, nil
+		}
+	}
+
+	if rule, ok := a.cfg.RequiredPrecedingActions[action.Action]; ok {
+		precedingTimestamp, found, err := a.acr.GetLastActionTimestamp(stage, action.UserId, rule.Action)
+		if err != nil {
+			return nil, err
+		}
+		if !found || action.Timestamp-precedingTimestamp > rule.WithinSeconds || action.Timestamp < precedingTimestamp {
+			return &RuleViolation{
+				Rule:   RuleActionSequence,
+				Reason: fmt.Sprintf("%s without %s within preceding %.0fs", action.Action, rule.Action, rule.WithinSeconds),
+			}, nil
+		}
+	}
+
+	if err := a.acr.SetLastActionTimestamp(stage, action.UserId, action.Action, action.Timestamp); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Quarantine stores the action for review instead of applying it and flags its user.
+func (a *AntiCheatService) Quarantine(stage string, action *entities.GameAction, violation *RuleViolation) error {
+	metrics.GetOrCreateCounter(a.game.MetricName(fmt.Sprintf(`anti_cheat_violations_total{stage=%q, rule=%q}`, stage, violation.Rule))).Inc()
+	slog.With("userId", action.UserId, "action", action.Action, "stage", stage, "rule", violation.Rule).Warn("Game action quarantined")
+	err := a.qr.Save(&entities.QuarantinedAction{
+		UserId:          action.UserId,
+		QuarantinedAt:   time.Now().UnixMilli(),
+		Action:          action.Action,
+		ActionTimestamp: action.Timestamp,
+		ReceivedAt:      action.ReceivedAt,
+		LeaderboardId:   action.LeaderboardId,
+		Stage:           stage,
+		Rule:            violation.Rule,
+		Reason:          violation.Reason,
+	})
+	if err != nil {
+		return err
+	}
+	return a.acr.FlagUser(action.UserId)
+}
+
+func (a *AntiCheatService) GetFlaggedUsers() ([]*entities.FlaggedUser, error) {
+	return a.acr.GetFlaggedUsers()
+}
+
+func (a *AntiCheatService) GetUserQuarantine(userId string) ([]*entities.QuarantinedAction, error) {
+	return a.qr.GetUserQuarantine(userId)
+}
+
+func (a *AntiCheatService) ClearFlag(userId string) error {
+	return a.acr.UnflagUser(userId)
+}
@@ -0,0 +1,346 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ClanAggregationSum  = "sum"
+	ClanAggregationTopK = "top_k"
+)
+
+var (
+	ErrClanNotFound           = errors.New("clan not found")
+	ErrInvalidClanName        = errors.New("invalid clan name")
+	ErrOffensiveClanName      = errors.New("offensive clan name")
+	ErrClanNameTaken          = errors.New("clan name is taken")
+	ErrClanFull               = errors.New("clan is full")
+	ErrClanForbidden          = errors.New("user can't join clans")
+	ErrClanMembershipConflict = errors.New("clan membership changed concurrently")
+)
+
+// ClanService ranks clans by the contributions of their members. A member contributes the score they earn
+// while in the clan. Leaving takes the contribution out of the clan, and it is brought into the next clan
+// the user joins. Contributions of shadow-banned users stop growing, banned users are removed from their clan.
+type ClanService struct {
+	upr    repositories.UserProfileRepository
+	cr     repositories.ClanRepository
+	csr    repositories.ClanScoreRepository
+	ns     *NicknameService
+	filter NicknameFilter
+	cfg    game_config.ClansConfig
+	topK   int
+}
+
+func NewClanService(gc *game_config.GameConfig, upr repositories.UserProfileRepository, cr repositories.ClanRepository, csr repositories.ClanScoreRepository, ns *NicknameService, filter NicknameFilter) *ClanService {
+	topK := 0
+	switch gc.Clans.Aggregation {
+	case ClanAggregationSum, "":
+	case ClanAggregationTopK:
+		if gc.Clans.TopK <= 0 {
+			panic("clan top_k aggregation needs a positive top_k")
+		}
+		topK = gc.Clans.TopK
+	default:
+		panic(fmt.Sprintf("unknown clan aggregation: %s", gc.Clans.Aggregation))
+	}
+	return &ClanService{
+		upr:    upr,
+		cr:     cr,
+		csr:    csr,
+		ns:     ns,
+		filter: filter,
+		cfg:    gc.Clans,
+		topK:   topK,
+	}
+}
+
+func (c *ClanService) getUserProfile(userId string) (*entities.UserProfile, error) {
+	userProfile, err := c.upr.GetUserProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	if userProfile == nil {
+		return nil, ErrUserNotFound
+	}
+	return userProfile, nil
+}
+
+// CreateClan creates the clan and moves the user into it
+func (c *ClanService) CreateClan(userId string, name string) (*entities.Clan, error) {
+	name = strings.TrimSpace(name)
+	length := utf8.RuneCountInString(name)
+	if length < c.cfg.NameMinLength || (c.cfg.NameMaxLength > 0 && length > c.cfg.NameMaxLength) {
+		return nil, ErrInvalidClanName
+	}
+	if c.filter.IsOffensive(name) {
+		return nil, ErrOffensiveClanName
+	}
+	userProfile, err := c.getUserProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	if userProfile.Status == entities.UserStatusBanned {
+		return nil, ErrClanForbidden
+	}
+	id, _ := gocql.RandomUUID()
+	clan := &entities.Clan{
+		Id:        id.String(),
+		Name:      name,
+		CreatedBy: userId,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	claimed, err := c.cr.ClaimName(NormalizeNickname(name), clan.Id)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrClanNameTaken
+	}
+	if err := c.cr.Create(clan); err != nil {
+		return nil, err
+	}
+	if err := c.join(userProfile, clan.Id); err != nil {
+		return nil, err
+	}
+	return clan, nil
+}
+
+// Join moves the user into the clan, leaving the one they are in
+func (c *ClanService) Join(userId string, clanId string) error {
+	userProfile, err := c.getUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	if userProfile.ClanId == clanId {
+		return nil
+	}
+	if userProfile.Status == entities.UserStatusBanned {
+		return ErrClanForbidden
+	}
+	if _, err := gocql.ParseUUID(clanId); err != nil {
+		return ErrClanNotFound
+	}
+	clan, err := c.cr.Get(clanId)
+	if err != nil {
+		return err
+	}
+	if clan == nil {
+		return ErrClanNotFound
+	}
+	return c.join(userProfile, clanId)
+}
+
+// join takes the user's place in the clan first, so the capacity check and the new member are one atomic step
+// and concurrent joins can't overfill the clan
+func (c *ClanService) join(userProfile *entities.UserProfile, clanId string) error {
+	added, err := c.csr.AddMember(clanId, userProfile.Id, c.cfg.MaxMembers, c.topK)
+	if err != nil {
+		return err
+	}
+	if !added {
+		return ErrClanFull
+	}
+	updated, err := c.upr.UpdateClan(userProfile.Id, userProfile.ClanId, clanId)
+	if err == nil && !updated {
+		err = ErrClanMembershipConflict
+	}
+	if err != nil {
+		if _, _, releaseErr := c.csr.RemoveMember(clanId, userProfile.Id, c.topK); releaseErr != nil {
+			slog.With("clanId", clanId, "userId", userProfile.Id, "error", releaseErr).Error("Failed to release clan place")
+		}
+		return err
+	}
+	if userProfile.ClanId != "" {
+		if err := c.removeMember(userProfile.ClanId, userProfile.Id); err != nil {
+			return err
+		}
+	}
+	if err := c.cr.AddMember(clanId, userProfile.Id); err != nil {
+		return err
+	}
+	contribution, err := c.csr.TakeCarriedContribution(userProfile.Id)
+	if err != nil || contribution == 0 {
+		return err
+	}
+	return c.csr.AddContribution(clanId, userProfile.Id, contribution, c.topK)
+}
+
+func (c *ClanService) Leave(userId string) error {
+	userProfile, err := c.getUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	return c.leave(userProfile)
+}
+
+func (c *ClanService) leave(userProfile *entities.UserProfile) error {
+	if userProfile.ClanId == "" {
+		return nil
+	}
+	updated, err := c.upr.UpdateClan(userProfile.Id, userProfile.ClanId, "")
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrClanMembershipConflict
+	}
+	return c.removeMember(userProfile.ClanId, userProfile.Id)
+}
+
+// removeMember takes the member's contribution out of the clan for them to carry along, the last one to leave
+// disbands the clan
+func (c *ClanService) removeMember(clanId string, userId string) error {
+	contribution, remaining, err := c.csr.RemoveMember(clanId, userId, c.topK)
+	if err != nil {
+		return err
+	}
+	if err := c.csr.SaveCarriedContribution(userId, contribution); err != nil {
+		return err
+	}
+	if err := c.cr.RemoveMember(clanId, userId); err != nil {
+		return err
+	}
+	if remaining > 0 {
+		return nil
+	}
+	clan, err := c.cr.Get(clanId)
+	if err != nil || clan == nil {
+		return err
+	}
+	if err := c.cr.ReleaseName(NormalizeNickname(clan.Name), clanId); err != nil {
+		return err
+	}
+	slog.With("clanId", clanId).Info("Clan disbanded")
+	return c.cr.Delete(clanId)
+}
+
+// AddContribution credits the action's score to the user's clan
+func (c *ClanService) AddContribution(userProfile *entities.UserProfile, score int) error {
+	if userProfile.ClanId == "" || userProfile.Status != entities.UserStatusActive {
+		return nil
+	}
+	return c.csr.AddContribution(userProfile.ClanId, userProfile.Id, score, c.topK)
+}
+
+func (c *ClanService) GetTopClans(count int) ([]*entities.ClanScore, error) {
+	clanScores, err := c.csr.GetTopClans(count)
+	if err != nil {
+		return nil, err
+	}
+	clanIds := make([]string, 0, len(clanScores))
+	for _, clanScore := range clanScores {
+		clanIds = append(clanIds, clanScore.ClanId)
+	}
+	clans, err := c.cr.GetMany(clanIds)
+	if err != nil {
+		return nil, err
+	}
+	clanIdToName := make(map[string]string, len(clans))
+	for _, clan := range clans {
+		clanIdToName[clan.Id] = clan.Name
+	}
+	for _, clanScore := range clanScores {
+		clanScore.Name = c.filter.Mask(clanIdToName[clanScore.ClanId])
+	}
+	return clanScores, nil
+}
+
+// GetClan returns the clan with its members as other players see them, best contributors first
+func (c *ClanService) GetClan(clanId string) (*entities.ClanDetails, error) {
+	if _, err := gocql.ParseUUID(clanId); err != nil {
+		return nil, ErrClanNotFound
+	}
+	clan, err := c.cr.Get(clanId)
+	if err != nil {
+		return nil, err
+	}
+	if clan == nil {
+		return nil, ErrClanNotFound
+	}
+	clan.Name = c.filter.Mask(clan.Name)
+	clanScore, err := c.csr.GetClanScore(clanId)
+	if err != nil {
+		return nil, err
+	}
+	if clanScore != nil {
+		clanScore.Name = clan.Name
+	}
+	members, err := c.cr.GetMembers(clanId)
+	if err != nil {
+		return nil, err
+	}
+	contributions, err := c.csr.GetContributions(clanId)
+	if err != nil {
+		return nil, err
+	}
+	userIds := make([]string, 0, len(members))
+	for _, member := range members {
+		userIds = append(userIds, member.UserId)
+	}
+	userProfiles, err := c.ns.getProfiles(userIds, false)
+	if err != nil {
+		return nil, err
+	}
+	userIdToNickname := make(map[string]string, len(userProfiles))
+	for _, userProfile := range userProfiles {
+		userIdToNickname[userProfile.Id] = userProfile.Nickname
+	}
+	visibleMembers := make([]*entities.ClanMember, 0, len(members))
+	for _, member := range members {
+		nickname, visible := userIdToNickname[member.UserId]
+		if !visible {
+			continue
+		}
+		member.Nickname = nickname
+		member.Contribution = contributions[member.UserId]
+		visibleMembers = append(visibleMembers, member)
+	}
+	slices.SortFunc(visibleMembers, func(a, b *entities.ClanMember) int {
+		return cmp.Compare(b.Contribution, a.Contribution)
+	})
+	return &entities.ClanDetails{
+		Clan:    clan,
+		Score:   clanScore,
+		Members: visibleMembers,
+	}, nil
+}
+
+// GetUserContribution returns the user's contribution to their clan, or the one they carry when not in any
+func (c *ClanService) GetUserContribution(userProfile *entities.UserProfile) (int, error) {
+	if userProfile.ClanId == "" {
+		return c.csr.GetCarriedContribution(userProfile.Id)
+	}
+	contributions, err := c.csr.GetContributions(userProfile.ClanId)
+	if err != nil {
+		return 0, err
+	}
+	return contributions[userProfile.Id], nil
+}
+
+// RemoveUser takes the user out of their clan for good, contribution included
+func (c *ClanService) RemoveUser(userProfile *entities.UserProfile) error {
+	if err := c.leave(userProfile); err != nil {
+		return err
+	}
+	_, err := c.csr.TakeCarriedContribution(userProfile.Id)
+	return err
+}
+
+func (c *ClanService) Purge() error {
+	if err := c.cr.Purge(); err != nil {
+		return err
+	}
+	return c.csr.Purge()
+}
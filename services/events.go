@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/segmentio/kafka-go"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/graceful_shutdown"
+	"log/slog"
+)
+
+// GameIdHeader names the Kafka header carrying the id of the game a message belongs to
+const GameIdHeader = "game_id"
+
+// Event is published to Kafka as JSON, keyed so events of the same user land on the same partition
+type Event struct {
+	Key     string
+	Payload any
+}
+
+// EventPublisher publishes domain events of a game to Kafka topics, the topic is chosen per call.
+// Payloads are left as they are, the game is told by the GameIdHeader of every message.
+type EventPublisher struct {
+	kw     *kafka.Writer
+	gameId string
+}
+
+func NewEventPublisher(ac *app_config.AppConfig, game *game_config.Game) *EventPublisher {
+	kw := &kafka.Writer{
+		Addr:                   kafka.TCP(ac.KafkaBrokers...),
+		Balancer:               &kafka.Murmur2Balancer{Consistent: true},
+		AllowAutoTopicCreation: true,
+	}
+	graceful_shutdown.AddOutputShutdownFunc(func() {
+		if err := kw.Close(); err != nil {
+			slog.With("error", err).Error("Failed to close kafka events writer")
+		}
+	})
+	return &EventPublisher{kw: kw, gameId: game.Id}
+}
+
+func (e *EventPublisher) Publish(topic string, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		bytes, err := json.Marshal(event.Payload)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, kafka.Message{
+			Topic:   topic,
+			Key:     []byte(event.Key),
+			Value:   bytes,
+			Headers: []kafka.Header{{Key: GameIdHeader, Value: []byte(e.gameId)}},
+		})
+	}
+	return e.kw.WriteMessages(context.Background(), messages...)
+}
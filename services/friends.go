@@ -0,0 +1,203 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"slices"
+)
+
+const (
+	maxFriends = 500
+	// maxFriendRequests caps the pending requests a user has received
+	maxFriendRequests = 100
+)
+
+var (
+	ErrInvalidFriend         = errors.New("invalid friend")
+	ErrTooManyFriends        = errors.New("too many friends")
+	ErrTooManyFriendRequests = errors.New("too many friend requests")
+)
+
+// FriendsService keeps friendships mutual: adding a user sends a friend request, which becomes a friendship
+// once that user adds the requester back. Nobody ends up on a friends list they didn't ask for.
+type FriendsService struct {
+	upr    repositories.UserProfileRepository
+	lr     repositories.LeaderboardRepo
+	fr     repositories.FriendshipRepository
+	ns     *NicknameService
+	filter NicknameFilter
+}
+
+func NewFriendsService(upr repositories.UserProfileRepository, lr repositories.LeaderboardRepo, fr repositories.FriendshipRepository, ns *NicknameService, filter NicknameFilter) *FriendsService {
+	return &FriendsService{
+		upr:    upr,
+		lr:     lr,
+		fr:     fr,
+		ns:     ns,
+		filter: filter,
+	}
+}
+
+func (f *FriendsService) getFriendIds(userId string) ([]string, error) {
+	friendships, err := f.fr.GetFriends(userId)
+	if err != nil {
+		return nil, err
+	}
+	friendIds := make([]string, 0, len(friendships))
+	for _, friendship := range friendships {
+		friendIds = append(friendIds, friendship.FriendId)
+	}
+	return friendIds, nil
+}
+
+// AddFriend accepts the friend's pending request to the user, or otherwise sends the friend a request.
+// Returns whether the two are friends now.
+func (f *FriendsService) AddFriend(userId string, friendId string) (bool, error) {
+	if userId == friendId {
+		return false, ErrInvalidFriend
+	}
+	friendProfile, err := f.upr.GetUserProfile(friendId)
+	if err != nil {
+		return false, err
+	}
+	if friendProfile == nil {
+		return false, ErrUserNotFound
+	}
+	friendIds, err := f.getFriendIds(userId)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(friendIds, friendId) {
+		return true, nil
+	}
+	if len(friendIds) >= maxFriends {
+		return false, ErrTooManyFriends
+	}
+	requests, err := f.fr.GetRequests(userId)
+	if err != nil {
+		return false, err
+	}
+	for _, request := range requests {
+		if request.OtherUserId != friendId {
+			continue
+		}
+		if !request.Incoming {
+			return false, nil
+		}
+		return true, f.accept(userId, friendId)
+	}
+	friendRequests, err := f.fr.GetRequests(friendId)
+	if err != nil {
+		return false, err
+	}
+	incoming := 0
+	for _, request := range friendRequests {
+		if request.Incoming {
+			incoming++
+		}
+	}
+	if incoming >= maxFriendRequests {
+		return false, ErrTooManyFriendRequests
+	}
+	return false, f.fr.AddRequest(userId, friendId)
+}
+
+// accept makes the requester a friend, unless they have run out of room since sending the request
+func (f *FriendsService) accept(userId string, requesterId string) error {
+	requesterFriendIds, err := f.getFriendIds(requesterId)
+	if err != nil {
+		return err
+	}
+	if len(requesterFriendIds) >= maxFriends {
+		return ErrTooManyFriends
+	}
+	if err := f.fr.Add(userId, requesterId); err != nil {
+		return err
+	}
+	return f.fr.RemoveRequest(userId, requesterId)
+}
+
+// RemoveFriend ends the friendship, or withdraws or declines a pending request between the two users
+func (f *FriendsService) RemoveFriend(userId string, friendId string) error {
+	if err := f.fr.RemoveRequest(userId, friendId); err != nil {
+		return err
+	}
+	return f.fr.Remove(userId, friendId)
+}
+
+// GetFriendRequests returns the requests the user has sent and received that are still pending
+func (f *FriendsService) GetFriendRequests(userId string) ([]*entities.FriendRequest, error) {
+	return f.fr.GetRequests(userId)
+}
+
+// GetFriends returns friends the way other players see them, banned and shadow-banned ones are left out
+func (f *FriendsService) GetFriends(userId string) ([]*entities.UserProfile, error) {
+	friendIds, err := f.getFriendIds(userId)
+	if err != nil {
+		return nil, err
+	}
+	return f.ns.getProfiles(friendIds, false)
+}
+
+// GetFriendsLeaderboard ranks the user and their friends by the score each has on their own leaderboard.
+// Shadow-banned users see themselves in place, like on their leaderboard.
+func (f *FriendsService) GetFriendsLeaderboard(userProfile *entities.UserProfile) (*entities.FriendsLeaderboard, error) {
+	friendIds, err := f.getFriendIds(userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	friendsLeaderboard := &entities.FriendsLeaderboard{Scores: []*entities.LeaderboardScoreFull{}}
+	userIdToProfile := map[string]*entities.UserProfile{userProfile.Id: userProfile}
+	userLeaderboards := make(map[string]int, len(friendIds))
+	if len(friendIds) > 0 {
+		friendProfiles, err := f.upr.GetManyUserProfiles(friendIds)
+		if err != nil {
+			return nil, err
+		}
+		for _, friendProfile := range friendProfiles {
+			userIdToProfile[friendProfile.Id] = friendProfile
+			userLeaderboards[friendProfile.Id] = friendProfile.Leaderboard
+		}
+	}
+	scores, err := f.lr.GetScores(userLeaderboards)
+	if err != nil {
+		return nil, err
+	}
+	userScore, err := f.lr.GetUserScore(userProfile.Leaderboard, userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	if userScore != nil {
+		scores[userProfile.Id] = userScore.Score
+	}
+
+	for userId, score := range scores {
+		friendsLeaderboard.Scores = append(friendsLeaderboard.Scores, &entities.LeaderboardScoreFull{
+			LeaderboardScore: entities.LeaderboardScore{
+				Leaderboard: userIdToProfile[userId].Leaderboard,
+				UserId:      userId,
+				Score:       score,
+			},
+			Nickname: f.filter.Mask(userIdToProfile[userId].Nickname),
+		})
+	}
+	slices.SortFunc(friendsLeaderboard.Scores, func(a, b *entities.LeaderboardScoreFull) int {
+		if a.Score != b.Score {
+			return cmp.Compare(b.Score, a.Score)
+		}
+		return cmp.Compare(b.UserId, a.UserId)
+	})
+	for i, score := range friendsLeaderboard.Scores {
+		score.Position = i + 1
+		if score.UserId == userProfile.Id {
+			friendsLeaderboard.User = score
+		}
+	}
+	return friendsLeaderboard, nil
+}
+
+func (f *FriendsService) Purge() error {
+	return f.fr.Purge()
+}
@@ -11,17 +11,31 @@ import (
 	"github.com/skif48/leaderboard-engine/game_config"
 	"github.com/skif48/leaderboard-engine/repositories"
 	"log/slog"
+	"time"
 )
 
 type GameActionsService struct {
-	kw  *kafka.Writer
-	lr  repositories.LeaderboardRepo
-	upr repositories.UserProfileRepository
-	uxr repositories.UserXpRepository
-	gc  *game_config.GameConfig
+	kw   *kafka.Writer
+	lr   repositories.LeaderboardRepo
+	upr  repositories.UserProfileRepository
+	uxr  repositories.UserXpRepository
+	gc   *game_config.GameConfig
+	acs  *AntiCheatService
+	lms  *LeaderboardMembershipService
+	cs   *ClanService
+	ts   *TournamentService
+	ws   *WebhookService
+	ns   *NotificationService
+	achs *AchievementService
+	ass  *ActionStatsService
+	als  *ActionLogService
+	game *game_config.Game
+
+	filter       NicknameFilter
+	overtakenTop int
 }
 
-func NewGameActionsService(ac *app_config.AppConfig, gc *game_config.GameConfig, lr repositories.LeaderboardRepo, upr repositories.UserProfileRepository, uxr repositories.UserXpRepository) *GameActionsService {
+func NewGameActionsService(ac *app_config.AppConfig, game *game_config.Game, gc *game_config.GameConfig, lr repositories.LeaderboardRepo, upr repositories.UserProfileRepository, uxr repositories.UserXpRepository, acs *AntiCheatService, lms *LeaderboardMembershipService, cs *ClanService, ts *TournamentService, ws *WebhookService, ns *NotificationService, achs *AchievementService, ass *ActionStatsService, als *ActionLogService, filter NicknameFilter) *GameActionsService {
 	kw := &kafka.Writer{
 		Addr:                   kafka.TCP(ac.KafkaBrokers...),
 		Topic:                  "game-actions",
@@ -30,43 +44,146 @@ func NewGameActionsService(ac *app_config.AppConfig, gc *game_config.GameConfig,
 	}
 
 	return &GameActionsService{
-		kw:  kw,
-		lr:  lr,
-		upr: upr,
-		uxr: uxr,
-		gc:  gc,
+		kw:   kw,
+		lr:   lr,
+		upr:  upr,
+		uxr:  uxr,
+		gc:   gc,
+		acs:  acs,
+		lms:  lms,
+		cs:   cs,
+		ts:   ts,
+		ws:   ws,
+		ns:   ns,
+		achs: achs,
+		ass:  ass,
+		als:  als,
+		game: game,
+
+		filter:       filter,
+		overtakenTop: ac.OvertakenTopPositions,
 	}
 }
 
 func (gas *GameActionsService) ProduceAction(action *entities.GameAction) error {
+	action.GameId = gas.game.Id
+	action.ReceivedAt = time.Now().UnixMilli()
+	violation, err := gas.acs.Check(StageProduce, action)
+	if err != nil {
+		return err
+	}
+	if violation != nil {
+		return gas.acs.Quarantine(StageProduce, action, violation)
+	}
 	bytes, err := json.Marshal(action)
 	if err != nil {
 		return err
 	}
 	return gas.kw.WriteMessages(context.Background(), kafka.Message{
-		Key:   []byte(action.UserId),
-		Value: bytes,
+		Key:     []byte(action.UserId),
+		Value:   bytes,
+		Headers: []kafka.Header{{Key: GameIdHeader, Value: []byte(gas.game.Id)}},
 	})
 }
 
+// HandleAction applies the consumed action and records the outcome in the action log
 func (gas *GameActionsService) HandleAction(action *entities.GameAction) error {
+	start := time.Now()
+	entry := &entities.ActionLogEntry{
+		UserId:        action.UserId,
+		Action:        action.Action,
+		LeaderboardId: action.LeaderboardId,
+		Timestamp:     action.Timestamp,
+		ReceivedAt:    action.ReceivedAt,
+	}
+	err := gas.handleAction(action, entry)
+	if err != nil {
+		entry.Outcome = entities.ActionOutcomeFailed
+		entry.Error = err.Error()
+	}
+	entry.ProcessedAt = time.Now().UnixMilli()
+	entry.ProcessingTimeUs = time.Since(start).Microseconds()
+	gas.als.Record(entry)
+	return err
+}
+
+func (gas *GameActionsService) handleAction(action *entities.GameAction, entry *entities.ActionLogEntry) error {
 	score, ok := gas.gc.ActionsScoreMap[action.Action]
 	if !ok {
 		return fmt.Errorf("unknown action: %s", action.Action)
 	}
-	metrics.GetOrCreateCounter(fmt.Sprintf("game_actions_count{action=%q}", action.Action)).Inc()
+	violation, err := gas.acs.Check(StageConsume, action)
+	if err != nil {
+		return err
+	}
+	if violation != nil {
+		entry.Outcome = entities.ActionOutcomeQuarantined
+		return gas.acs.Quarantine(StageConsume, action, violation)
+	}
+	metrics.GetOrCreateCounter(gas.game.MetricName(fmt.Sprintf("game_actions_count{action=%q}", action.Action))).Inc()
 	userProfile, err := gas.upr.GetUserProfile(action.UserId)
 	if err != nil {
 		return err
 	}
-	_, err = gas.lr.UpdateScore(userProfile.Leaderboard, action.UserId, score)
+	if userProfile == nil {
+		// the user was deleted or never signed up, retrying wouldn't find them either
+		slog.With("userId", action.UserId, "action", action.Action).Warn("Skipped action of unknown user")
+		metrics.GetOrCreateCounter(gas.game.MetricName(`game_actions_rejected{reason="unknown_user"}`)).Inc()
+		entry.Outcome = entities.ActionOutcomeUnknownUser
+		return nil
+	}
+	entry.Level = userProfile.Level
+	if userProfile.Status == entities.UserStatusBanned {
+		metrics.GetOrCreateCounter(gas.game.MetricName(`game_actions_rejected{reason="banned"}`)).Inc()
+		entry.Outcome = entities.ActionOutcomeBanned
+		return nil
+	}
+	leaderboards, err := gas.lms.ScoringLeaderboards(userProfile)
+	if err != nil {
+		return err
+	}
+	receivedAt := time.Now()
+	if action.ReceivedAt > 0 {
+		receivedAt = time.UnixMilli(action.ReceivedAt)
+	}
+	actionCount, err := gas.ass.CountAction(action.UserId, action.Action, receivedAt)
 	if err != nil {
 		return err
 	}
+	progress := &AchievementProgress{
+		Action:      action.Action,
+		ActionCount: actionCount,
+		Level:       userProfile.Level,
+	}
+	entry.Score = score
+	if userProfile.Status == entities.UserStatusShadowBanned {
+		entry.Outcome = entities.ActionOutcomeShadow
+		_, err = gas.lr.UpdateShadowScores(leaderboards, action.UserId, score)
+		if err != nil {
+			return err
+		}
+	} else {
+		entry.Outcome = entities.ActionOutcomeApplied
+		finalScores, err := gas.lr.UpdateScores(leaderboards, action.UserId, score)
+		if err != nil {
+			return err
+		}
+		progress.Position, err = gas.handlePositionChanges(userProfile, finalScores, score)
+		if err != nil {
+			return err
+		}
+	}
+	if err := gas.cs.AddContribution(userProfile, score); err != nil {
+		return err
+	}
+	if err := gas.ts.HandleAction(userProfile, action, score); err != nil {
+		return err
+	}
 	newXp, err := gas.uxr.IncrementXp(action.UserId, score)
 	if err != nil {
 		return err
 	}
+	entry.XpDelta = score
 
 	newLevel := 0
 
@@ -83,8 +200,90 @@ func (gas *GameActionsService) HandleAction(action *entities.GameAction) error {
 		}
 		if !updated {
 			slog.With("userId", action.UserId).Warn("User level update was ignored, race condition")
+		} else {
+			progress.Level = newLevel
+			entry.Level = newLevel
+			if userProfile.Status == entities.UserStatusActive {
+				gas.ws.Emit(entities.WebhookEventLevelUp, &entities.LevelUpEvent{
+					UserId:        action.UserId,
+					PreviousLevel: userProfile.Level,
+					Level:         newLevel,
+					Xp:            newXp,
+				})
+			}
 		}
 	}
 
-	return err
+	return gas.achs.HandleProgress(action.UserId, progress, userProfile.Status == entities.UserStatusActive)
+}
+
+const enteredTopPositions = 10
+
+// handlePositionChanges raises the events a score increase can cause on each leaderboard: entering the top 10
+// and overtaking players in the top positions. Finding the previous position costs a roundtrip per leaderboard.
+// It returns the best position over the leaderboards, 0 when nothing was looked up.
+func (gas *GameActionsService) handlePositionChanges(userProfile *entities.UserProfile, finalScores map[int]int, score int) (int, error) {
+	bestPosition := 0
+	if score <= 0 {
+		return bestPosition, nil
+	}
+	enteredTopSubscribed := gas.ws.Subscribed(entities.WebhookEventEnteredTop10)
+	for leaderboard, finalScore := range finalScores {
+		previousPosition, position, err := gas.lr.GetPositionChange(leaderboard, userProfile.Id, finalScore-score)
+		if err != nil {
+			return 0, err
+		}
+		if position == 0 {
+			continue
+		}
+		if bestPosition == 0 || position < bestPosition {
+			bestPosition = position
+		}
+		if position >= previousPosition {
+			continue
+		}
+		if enteredTopSubscribed && position <= enteredTopPositions && previousPosition > enteredTopPositions {
+			gas.ws.Emit(entities.WebhookEventEnteredTop10, &entities.EnteredTopEvent{
+				UserId:           userProfile.Id,
+				Leaderboard:      leaderboard,
+				PreviousPosition: previousPosition,
+				Position:         position,
+				Score:            finalScore,
+			})
+		}
+		if err := gas.notifyOvertaken(userProfile, leaderboard, position, previousPosition, finalScore, score); err != nil {
+			return 0, err
+		}
+	}
+	return bestPosition, nil
+}
+
+// notifyOvertaken tells the users passed within the top positions, they now sit right behind the user
+func (gas *GameActionsService) notifyOvertaken(userProfile *entities.UserProfile, leaderboard int, position int, previousPosition int, finalScore int, score int) error {
+	if position > gas.overtakenTop {
+		return nil
+	}
+	overtaken, err := gas.lr.GetOvertaken(leaderboard, finalScore-score, finalScore, min(previousPosition, gas.overtakenTop)-position)
+	if err != nil {
+		return err
+	}
+	if len(overtaken) == 0 {
+		return nil
+	}
+	events := make([]*entities.OvertakenEvent, 0, len(overtaken))
+	for i, overtakenScore := range overtaken {
+		events = append(events, &entities.OvertakenEvent{
+			UserId:              overtakenScore.UserId,
+			Leaderboard:         leaderboard,
+			Position:            position + i + 1,
+			Score:               overtakenScore.Score,
+			OvertakenBy:         userProfile.Id,
+			OvertakenByNickname: gas.filter.Mask(userProfile.Nickname),
+			OvertakenByScore:    finalScore,
+		})
+	}
+	if err := gas.ns.NotifyOvertaken(events); err != nil {
+		slog.With("error", err, "userId", userProfile.Id).Error("Failed to notify overtaken users")
+	}
+	return nil
 }
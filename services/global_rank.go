@@ -0,0 +1,113 @@
+package services
+
+import (
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"math"
+	"sync"
+	"time"
+)
+
+// GlobalRankService tells users where they stand on the global leaderboard. The approximate rank is
+// estimated from the score histogram, cached for a short while, so it costs no Redis roundtrips
+// beyond reading the user's own score. The exact rank counts higher scores in every shard.
+type GlobalRankService struct {
+	lr      repositories.LeaderboardRepo
+	refresh time.Duration
+
+	mu          sync.Mutex
+	histogram   map[int]int
+	total       int
+	refreshedAt time.Time
+}
+
+func NewGlobalRankService(ac *app_config.AppConfig, lr repositories.LeaderboardRepo) *GlobalRankService {
+	return &GlobalRankService{
+		lr:      lr,
+		refresh: ac.GlobalRankHistogramRefresh,
+	}
+}
+
+func (g *GlobalRankService) getHistogram() (map[int]int, int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.histogram != nil && time.Since(g.refreshedAt) < g.refresh {
+		return g.histogram, g.total, nil
+	}
+	histogram, err := g.lr.GetGlobalHistogram()
+	if err != nil {
+		return nil, 0, err
+	}
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+	g.histogram, g.total, g.refreshedAt = histogram, total, time.Now()
+	return histogram, total, nil
+}
+
+// GetUserRank returns nil if the user is not on the global leaderboard
+func (g *GlobalRankService) GetUserRank(userId string, exact bool) (*entities.GlobalRank, error) {
+	if exact {
+		return g.getExactRank(userId)
+	}
+	score, found, err := g.lr.GetGlobalScore(userId)
+	if err != nil || !found {
+		return nil, err
+	}
+	histogram, total, err := g.getHistogram()
+	if err != nil {
+		return nil, err
+	}
+	return newGlobalRank(userId, score, estimatePosition(histogram, score), total, true), nil
+}
+
+func (g *GlobalRankService) getExactRank(userId string) (*entities.GlobalRank, error) {
+	userScore, err := g.lr.GetGlobalUserScore(userId)
+	if err != nil || userScore == nil {
+		return nil, err
+	}
+	total, err := g.lr.GetGlobalSize()
+	if err != nil {
+		return nil, err
+	}
+	return newGlobalRank(userId, userScore.Score, userScore.Position, total, false), nil
+}
+
+// RebuildHistogram recounts the histogram from the leaderboard, for data written before it was maintained or with
+// other buckets
+func (g *GlobalRankService) RebuildHistogram() (int, error) {
+	return g.lr.RebuildGlobalHistogram()
+}
+
+func newGlobalRank(userId string, score int, position int, total int, approximate bool) *entities.GlobalRank {
+	topPercent := 100.0
+	if total > 0 {
+		topPercent = math.Min(100, math.Round(float64(position)/float64(total)*1000)/10)
+	}
+	return &entities.GlobalRank{
+		UserId:      userId,
+		Score:       score,
+		Position:    position,
+		Total:       total,
+		TopPercent:  topPercent,
+		Approximate: approximate,
+	}
+}
+
+// estimatePosition counts users in higher buckets and assumes scores are spread evenly within the user's bucket
+func estimatePosition(histogram map[int]int, score int) int {
+	userBucket := repositories.GlobalRankBucket(score)
+	lowest, highest := repositories.GlobalRankBucketBounds(userBucket)
+	higher := 0.0
+	for bucket, count := range histogram {
+		switch {
+		case bucket > userBucket:
+			higher += float64(count)
+		case bucket == userBucket:
+			higher += float64(count) * float64(max(0, highest-score)) / float64(max(1, highest-lowest+1))
+		}
+	}
+	return int(math.Round(higher)) + 1
+}
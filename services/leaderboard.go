@@ -9,28 +9,33 @@ type LeaderboardService struct {
 	leaderboardRepo repositories.LeaderboardRepo
 	userProfileRepo repositories.UserProfileRepository
 	userXpRepo      repositories.UserXpRepository
+	nicknameFilter  NicknameFilter
+	registry        *LeaderboardRegistryService
 }
 
-func NewLeaderboardService(leaderboardRepo repositories.LeaderboardRepo, userProfileRepo repositories.UserProfileRepository, userXpRepo repositories.UserXpRepository) *LeaderboardService {
+func NewLeaderboardService(leaderboardRepo repositories.LeaderboardRepo, userProfileRepo repositories.UserProfileRepository, userXpRepo repositories.UserXpRepository, nicknameFilter NicknameFilter, registry *LeaderboardRegistryService) *LeaderboardService {
 	return &LeaderboardService{
 		leaderboardRepo: leaderboardRepo,
 		userProfileRepo: userProfileRepo,
 		userXpRepo:      userXpRepo,
+		nicknameFilter:  nicknameFilter,
+		registry:        registry,
 	}
 }
 
+// GetAllLeaderboards returns the top of every listed leaderboard, closed and hidden ones are left out
 func (l *LeaderboardService) GetAllLeaderboards() (map[int][]*entities.LeaderboardScoreFull, error) {
-	leaderboardIds, err := l.leaderboardRepo.GetAllLeaderboardsIds()
+	infos, err := l.registry.GetListed()
 	if err != nil {
 		return nil, err
 	}
-	leaderboardScores := make(map[int][]*entities.LeaderboardScoreFull, len(leaderboardIds))
-	for _, leaderboardId := range leaderboardIds {
-		scores, err := l.GetLeaderboard(leaderboardId)
+	leaderboardScores := make(map[int][]*entities.LeaderboardScoreFull, len(infos))
+	for _, info := range infos {
+		scores, err := l.GetLeaderboard(info.Id)
 		if err != nil {
 			return nil, err
 		}
-		leaderboardScores[leaderboardId] = scores
+		leaderboardScores[info.Id] = scores
 	}
 	return leaderboardScores, nil
 }
@@ -40,21 +45,29 @@ func (l *LeaderboardService) GetLeaderboard(leaderboardId int) ([]*entities.Lead
 	if err != nil {
 		return nil, err
 	}
-	userIdToScore := make(map[string]int, len(leaderboard))
+	return l.withNicknames(leaderboard)
+}
+
+func (l *LeaderboardService) GetGlobalLeaderboard() ([]*entities.LeaderboardScoreFull, error) {
+	leaderboard, err := l.leaderboardRepo.GetGlobalLeaderboard(repositories.LeaderboardTopSize)
+	if err != nil {
+		return nil, err
+	}
+	return l.withNicknames(leaderboard)
+}
+
+func (l *LeaderboardService) withNicknames(leaderboard []*entities.LeaderboardScore) ([]*entities.LeaderboardScoreFull, error) {
 	userIds := make([]string, 0, len(leaderboard))
 	for _, score := range leaderboard {
-		userIdToScore[score.UserId] = score.Score
 		userIds = append(userIds, score.UserId)
 	}
 	userProfiles, err := l.userProfileRepo.GetManyUserProfiles(userIds)
 	if err != nil {
 		return nil, err
 	}
-	userXps, err := l.userXpRepo.GetManyUsersXp(userIds)
 
 	userIdToProfile := make(map[string]*entities.UserProfile, len(userProfiles))
 	for _, profile := range userProfiles {
-		profile.Xp = userXps[profile.Id]
 		userIdToProfile[profile.Id] = profile
 	}
 
@@ -63,10 +76,70 @@ func (l *LeaderboardService) GetLeaderboard(leaderboardId int) ([]*entities.Lead
 		if profile, exists := userIdToProfile[score.UserId]; exists {
 			fullScore := &entities.LeaderboardScoreFull{
 				LeaderboardScore: *score,
-				Nickname:         profile.Nickname,
+				Nickname:         l.nicknameFilter.Mask(profile.Nickname),
 			}
 			fullScores = append(fullScores, fullScore)
 		}
 	}
 	return fullScores, nil
 }
+
+// GetUserLeaderboard returns the user's leaderboard as the user sees it, shadow-banned users are put back in place for themselves
+func (l *LeaderboardService) GetUserLeaderboard(userProfile *entities.UserProfile) (*entities.UserLeaderboard, error) {
+	scores, err := l.GetLeaderboard(userProfile.Leaderboard)
+	if err != nil {
+		return nil, err
+	}
+	userScore, err := l.leaderboardRepo.GetUserScore(userProfile.Leaderboard, userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	userLeaderboard := &entities.UserLeaderboard{
+		Leaderboard: userProfile.Leaderboard,
+		Scores:      scores,
+	}
+	userLeaderboard.Scores, userLeaderboard.User = l.placeUser(userProfile, scores, userScore)
+	return userLeaderboard, nil
+}
+
+// GetUserGlobalLeaderboard is GetUserLeaderboard for the global leaderboard
+func (l *LeaderboardService) GetUserGlobalLeaderboard(userProfile *entities.UserProfile) (*entities.GlobalLeaderboard, error) {
+	scores, err := l.GetGlobalLeaderboard()
+	if err != nil {
+		return nil, err
+	}
+	userScore, err := l.leaderboardRepo.GetGlobalUserScore(userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	globalLeaderboard := &entities.GlobalLeaderboard{}
+	globalLeaderboard.Scores, globalLeaderboard.User = l.placeUser(userProfile, scores, userScore)
+	return globalLeaderboard, nil
+}
+
+func (l *LeaderboardService) placeUser(userProfile *entities.UserProfile, scores []*entities.LeaderboardScoreFull, userScore *entities.LeaderboardScore) ([]*entities.LeaderboardScoreFull, *entities.LeaderboardScoreFull) {
+	if userScore == nil {
+		return scores, nil
+	}
+	user := &entities.LeaderboardScoreFull{
+		LeaderboardScore: *userScore,
+		Nickname:         l.nicknameFilter.Mask(userProfile.Nickname),
+	}
+
+	if userProfile.Status == entities.UserStatusShadowBanned && userScore.Position <= len(scores)+1 {
+		index := userScore.Position - 1
+		withUser := make([]*entities.LeaderboardScoreFull, 0, len(scores)+1)
+		withUser = append(withUser, scores[:index]...)
+		withUser = append(withUser, user)
+		for _, score := range scores[index:] {
+			shifted := *score
+			shifted.Position++
+			withUser = append(withUser, &shifted)
+		}
+		if len(withUser) > repositories.LeaderboardTopSize {
+			withUser = withUser[:repositories.LeaderboardTopSize]
+		}
+		return withUser, user
+	}
+	return scores, user
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"fmt"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"math/rand/v2"
+	"strconv"
+	"time"
+)
+
+const (
+	AssignmentStrategyRandom         = "random"
+	AssignmentStrategyLeastPopulated = "least_populated"
+	AssignmentStrategyCapacityCapped = "capacity_capped"
+	AssignmentStrategyCohort         = "cohort"
+	AssignmentStrategyLeagueEntry    = "league_entry"
+)
+
+// LeaderboardAssignmentStrategy picks the leaderboard a signing up user is placed on
+type LeaderboardAssignmentStrategy interface {
+	Assign() (int, error)
+}
+
+func NewLeaderboardAssignmentStrategy(gc *game_config.GameConfig, lr repositories.LeaderboardRepo, lar repositories.LeaderboardAssignmentRepository) LeaderboardAssignmentStrategy {
+	cfg := gc.LeaderboardAssignment
+	switch cfg.Strategy {
+	case AssignmentStrategyRandom, "":
+		return &randomAssignment{maxLeaderboards: gc.MaxLeaderboards}
+	case AssignmentStrategyLeastPopulated:
+		leaderboards := make([]int, 0, gc.MaxLeaderboards)
+		for leaderboard := 1; leaderboard <= gc.MaxLeaderboards; leaderboard++ {
+			leaderboards = append(leaderboards, leaderboard)
+		}
+		return &leastPopulatedAssignment{leaderboards: leaderboards, lr: lr}
+	case AssignmentStrategyLeagueEntry:
+		// newcomers start in the lowest tier and work their way up
+		if len(gc.Leagues.Tiers) == 0 || len(gc.Leagues.Tiers[0].Leaderboards) == 0 {
+			panic("league entry assignment needs a lowest league tier with leaderboards")
+		}
+		return &leastPopulatedAssignment{leaderboards: gc.Leagues.Tiers[0].Leaderboards, lr: lr}
+	case AssignmentStrategyCapacityCapped:
+		if cfg.Capacity <= 0 {
+			panic("leaderboard assignment capacity must be positive")
+		}
+		return &capacityCappedAssignment{capacity: cfg.Capacity, lar: lar}
+	case AssignmentStrategyCohort:
+		if cfg.CohortPeriodDays <= 0 || cfg.BoardsPerCohort <= 0 {
+			panic("leaderboard assignment cohort period and boards per cohort must be positive")
+		}
+		return &cohortAssignment{
+			period:          time.Duration(cfg.CohortPeriodDays) * 24 * time.Hour,
+			boardsPerCohort: cfg.BoardsPerCohort,
+			lar:             lar,
+		}
+	}
+	panic(fmt.Sprintf("unknown leaderboard assignment strategy: %s", cfg.Strategy))
+}
+
+// randomAssignment spreads users uniformly over leaderboards 1..MaxLeaderboards
+type randomAssignment struct {
+	maxLeaderboards int
+}
+
+func (r *randomAssignment) Assign() (int, error) {
+	return rand.IntN(r.maxLeaderboards) + 1, nil
+}
+
+// leastPopulatedAssignment fills up the smallest of the leaderboards first
+type leastPopulatedAssignment struct {
+	leaderboards []int
+	lr           repositories.LeaderboardRepo
+}
+
+func (l *leastPopulatedAssignment) Assign() (int, error) {
+	sizes, err := l.lr.GetLeaderboardsSizes(l.leaderboards)
+	if err != nil {
+		return 0, err
+	}
+	// ties are broken randomly so concurrent sign-ups don't all pile onto the same board
+	smallest := make([]int, 0, 1)
+	for _, leaderboard := range l.leaderboards {
+		switch {
+		case len(smallest) == 0 || sizes[leaderboard] < sizes[smallest[0]]:
+			smallest = append(smallest[:0], leaderboard)
+		case sizes[leaderboard] == sizes[smallest[0]]:
+			smallest = append(smallest, leaderboard)
+		}
+	}
+	return smallest[rand.IntN(len(smallest))], nil
+}
+
+// capacityCappedAssignment fills leaderboards one by one up to the capacity, opening a new one when the last is full
+type capacityCappedAssignment struct {
+	capacity int
+	lar      repositories.LeaderboardAssignmentRepository
+}
+
+func (c *capacityCappedAssignment) Assign() (int, error) {
+	seat, err := c.lar.NextSeat(AssignmentStrategyCapacityCapped)
+	if err != nil {
+		return 0, err
+	}
+	return (seat-1)/c.capacity + 1, nil
+}
+
+// cohortAssignment places users signing up within the same period on the same group of leaderboards,
+// so newcomers compete with newcomers. Cohort n owns leaderboards n*BoardsPerCohort+1..(n+1)*BoardsPerCohort.
+type cohortAssignment struct {
+	period          time.Duration
+	boardsPerCohort int
+	lar             repositories.LeaderboardAssignmentRepository
+}
+
+func (c *cohortAssignment) Assign() (int, error) {
+	cohort := int(time.Now().UnixMilli() / c.period.Milliseconds())
+	seat, err := c.lar.NextSeat(AssignmentStrategyCohort + ":" + strconv.Itoa(cohort))
+	if err != nil {
+		return 0, err
+	}
+	return cohort*c.boardsPerCohort + (seat-1)%c.boardsPerCohort + 1, nil
+}
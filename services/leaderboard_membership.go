@@ -0,0 +1,179 @@
+package services
+
+import (
+	"errors"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"slices"
+	"time"
+)
+
+var ErrInvalidMembership = errors.New("invalid leaderboard membership")
+
+// LeaderboardMembershipService manages the leaderboards a user is on besides the primary one from their profile,
+// which stays the one sign-up assignment and leagues work with. Scores go to all of them.
+type LeaderboardMembershipService struct {
+	upr repositories.UserProfileRepository
+	lr  repositories.LeaderboardRepo
+	lmr repositories.LeaderboardMembershipRepository
+	lrs *LeaderboardRegistryService
+}
+
+func NewLeaderboardMembershipService(upr repositories.UserProfileRepository, lr repositories.LeaderboardRepo, lmr repositories.LeaderboardMembershipRepository, lrs *LeaderboardRegistryService) *LeaderboardMembershipService {
+	return &LeaderboardMembershipService{
+		upr: upr,
+		lr:  lr,
+		lmr: lmr,
+		lrs: lrs,
+	}
+}
+
+// UserLeaderboards returns all leaderboards of the user, the primary one first
+func (l *LeaderboardMembershipService) UserLeaderboards(userProfile *entities.UserProfile) ([]int, error) {
+	memberships, err := l.lmr.GetUserMemberships(userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	leaderboards := make([]int, 0, len(memberships)+1)
+	leaderboards = append(leaderboards, userProfile.Leaderboard)
+	for _, membership := range memberships {
+		if membership.Leaderboard != userProfile.Leaderboard {
+			leaderboards = append(leaderboards, membership.Leaderboard)
+		}
+	}
+	return leaderboards, nil
+}
+
+// ScoringLeaderboards returns the leaderboards of the user that still take scores, closed ones are frozen
+func (l *LeaderboardMembershipService) ScoringLeaderboards(userProfile *entities.UserProfile) ([]int, error) {
+	leaderboards, err := l.UserLeaderboards(userProfile)
+	if err != nil {
+		return nil, err
+	}
+	return l.lrs.OpenLeaderboards(leaderboards)
+}
+
+// Join puts the user on the leaderboard with zero score, respecting their moderation status. League tiers can't
+// be joined, users only get there through sign-up assignment and league moves.
+func (l *LeaderboardMembershipService) Join(userId string, leaderboard int) error {
+	if leaderboard <= 0 {
+		return ErrInvalidMembership
+	}
+	if l.lrs.isLeagueLeaderboard(leaderboard) {
+		return ErrLeagueLeaderboard
+	}
+	userProfile, err := l.upr.GetUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	if userProfile == nil {
+		return ErrUserNotFound
+	}
+	leaderboards, err := l.UserLeaderboards(userProfile)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(leaderboards, leaderboard) {
+		return nil
+	}
+	open, err := l.lrs.OpenLeaderboards([]int{leaderboard})
+	if err != nil {
+		return err
+	}
+	if len(open) == 0 {
+		return ErrLeaderboardClosed
+	}
+	err = l.lmr.Add(&entities.LeaderboardMembership{
+		UserId:      userId,
+		Leaderboard: leaderboard,
+		JoinedAt:    time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	switch userProfile.Status {
+	case entities.UserStatusBanned:
+		return nil
+	case entities.UserStatusShadowBanned:
+		_, err = l.lr.UpdateShadowScore(leaderboard, userId, 0)
+		return err
+	}
+	return l.lr.AddUser(leaderboard, userId)
+}
+
+// Leave takes the user and their score off the leaderboard, the primary leaderboard can't be left
+func (l *LeaderboardMembershipService) Leave(userId string, leaderboard int) error {
+	userProfile, err := l.upr.GetUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	if userProfile == nil {
+		return ErrUserNotFound
+	}
+	if userProfile.Leaderboard == leaderboard {
+		return ErrInvalidMembership
+	}
+	if err := l.lmr.Remove(userId, leaderboard); err != nil {
+		return err
+	}
+	return l.lr.RemoveUser(leaderboard, userId)
+}
+
+func (l *LeaderboardMembershipService) GetUserStandings(userId string) ([]*entities.UserLeaderboardStanding, error) {
+	userProfile, err := l.upr.GetUserProfileEventual(userId)
+	if err != nil {
+		return nil, err
+	}
+	if userProfile == nil {
+		return nil, ErrUserNotFound
+	}
+	leaderboards, err := l.UserLeaderboards(userProfile)
+	if err != nil {
+		return nil, err
+	}
+	standings := make([]*entities.UserLeaderboardStanding, 0, len(leaderboards))
+	for _, leaderboard := range leaderboards {
+		score, err := l.lr.GetUserScore(leaderboard, userId)
+		if err != nil {
+			return nil, err
+		}
+		standings = append(standings, &entities.UserLeaderboardStanding{
+			Leaderboard: leaderboard,
+			Primary:     leaderboard == userProfile.Leaderboard,
+			Score:       score,
+		})
+	}
+	return standings, nil
+}
+
+// DeleteUserMemberships takes the user off all leaderboards but the primary one and forgets the memberships
+func (l *LeaderboardMembershipService) DeleteUserMemberships(userProfile *entities.UserProfile) error {
+	leaderboards, err := l.UserLeaderboards(userProfile)
+	if err != nil {
+		return err
+	}
+	for _, leaderboard := range leaderboards[1:] {
+		if err := l.lr.RemoveUser(leaderboard, userProfile.Id); err != nil {
+			return err
+		}
+	}
+	return l.lmr.DeleteUserMemberships(userProfile.Id)
+}
+
+// PurgeLeaderboardMemberships forgets all memberships of the leaderboard, its data is expected to be purged separately
+func (l *LeaderboardMembershipService) PurgeLeaderboardMemberships(leaderboard int) error {
+	userIds, err := l.lmr.GetLeaderboardMemberIds(leaderboard)
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		if err := l.lmr.Remove(userId, leaderboard); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *LeaderboardMembershipService) Purge() error {
+	return l.lmr.Purge()
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLeaderboardNotFound = errors.New("leaderboard not found")
+	ErrInvalidLeaderboard  = errors.New("invalid leaderboard")
+	ErrLeaderboardExists   = errors.New("leaderboard already registered")
+	ErrLeaderboardClosed   = errors.New("leaderboard is closed")
+	ErrLeagueLeaderboard   = errors.New("leaderboard is part of a league")
+)
+
+const leaderboardNameMaxLength = 64
+
+// LeaderboardRegistryService manages leaderboard metadata and lifecycle. Leaderboards come into existence as soon
+// as a user is put on them, registering one only names it and controls whether it's listed. Closed leaderboards
+// keep their final scores but take no more scores or members, hidden ones work as usual but aren't listed.
+type LeaderboardRegistryService struct {
+	lrr     repositories.LeaderboardRegistryRepository
+	lr      repositories.LeaderboardRepo
+	gc      *game_config.GameConfig
+	refresh time.Duration
+
+	mu          sync.Mutex
+	registered  map[int]*entities.LeaderboardInfo
+	refreshedAt time.Time
+}
+
+func NewLeaderboardRegistryService(ac *app_config.AppConfig, gc *game_config.GameConfig, lrr repositories.LeaderboardRegistryRepository, lr repositories.LeaderboardRepo) *LeaderboardRegistryService {
+	return &LeaderboardRegistryService{
+		lrr:     lrr,
+		lr:      lr,
+		gc:      gc,
+		refresh: ac.LeaderboardRegistryRefresh,
+	}
+}
+
+func defaultLeaderboardInfo(leaderboard int) *entities.LeaderboardInfo {
+	return &entities.LeaderboardInfo{
+		Id:          leaderboard,
+		Name:        fmt.Sprintf("Leaderboard %d", leaderboard),
+		Aggregation: entities.LeaderboardAggregationSum,
+		Visibility:  entities.LeaderboardVisibilityPublic,
+	}
+}
+
+func validateLeaderboardRequest(req *entities.LeaderboardInfoRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Aggregation == "" {
+		req.Aggregation = entities.LeaderboardAggregationSum
+	}
+	if req.Visibility == "" {
+		req.Visibility = entities.LeaderboardVisibilityPublic
+	}
+	if req.Name == "" || len(req.Name) > leaderboardNameMaxLength || req.Aggregation != entities.LeaderboardAggregationSum {
+		return ErrInvalidLeaderboard
+	}
+	if req.Visibility != entities.LeaderboardVisibilityPublic && req.Visibility != entities.LeaderboardVisibilityHidden {
+		return ErrInvalidLeaderboard
+	}
+	return nil
+}
+
+// Create registers a leaderboard, which may already have scores
+func (l *LeaderboardRegistryService) Create(req *entities.LeaderboardInfoRequest) (*entities.LeaderboardInfo, error) {
+	if req.Id <= 0 {
+		return nil, ErrInvalidLeaderboard
+	}
+	if err := validateLeaderboardRequest(req); err != nil {
+		return nil, err
+	}
+	info := &entities.LeaderboardInfo{
+		Id:          req.Id,
+		Name:        req.Name,
+		Description: req.Description,
+		Aggregation: req.Aggregation,
+		Visibility:  req.Visibility,
+		CreatedAt:   time.Now().UnixMilli(),
+		Registered:  true,
+	}
+	created, err := l.lrr.Create(info)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, ErrLeaderboardExists
+	}
+	l.invalidate()
+	return info, nil
+}
+
+func (l *LeaderboardRegistryService) Update(leaderboard int, req *entities.LeaderboardInfoRequest) (*entities.LeaderboardInfo, error) {
+	if err := validateLeaderboardRequest(req); err != nil {
+		return nil, err
+	}
+	info, err := l.getRegistered(leaderboard)
+	if err != nil {
+		return nil, err
+	}
+	info.Name = req.Name
+	info.Description = req.Description
+	info.Aggregation = req.Aggregation
+	info.Visibility = req.Visibility
+	updated, err := l.lrr.Update(info)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrLeaderboardNotFound
+	}
+	l.invalidate()
+	return info, nil
+}
+
+// isLeagueLeaderboard tells whether the leaderboard is a tier of the league, leagues own who is on those
+func (l *LeaderboardRegistryService) isLeagueLeaderboard(leaderboard int) bool {
+	return l.gc.Leagues.Enabled && l.gc.LeagueTierOf(leaderboard) >= 0
+}
+
+// Close freezes the leaderboard: it's no longer listed, scored or joinable. Closing can't be undone.
+// Leaderboards of league tiers can't be closed, league cycles reset them and move users onto them.
+// Actions and joins check the registry cache of their instance, so other instances keep scoring the leaderboard
+// until their cache is refreshed, up to LeaderboardRegistryRefresh after the close.
+func (l *LeaderboardRegistryService) Close(leaderboard int) (*entities.LeaderboardInfo, error) {
+	if l.isLeagueLeaderboard(leaderboard) {
+		return nil, ErrLeagueLeaderboard
+	}
+	info, err := l.getRegistered(leaderboard)
+	if err != nil {
+		return nil, err
+	}
+	if info.Closed() {
+		return nil, ErrLeaderboardClosed
+	}
+	info.ClosedAt = time.Now().UnixMilli()
+	closed, err := l.lrr.Close(leaderboard, info.ClosedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !closed {
+		return nil, ErrLeaderboardClosed
+	}
+	l.invalidate()
+	return info, nil
+}
+
+// Delete forgets the leaderboard's metadata, its scores are kept and it's reported with defaults again
+func (l *LeaderboardRegistryService) Delete(leaderboard int) error {
+	if _, err := l.getRegistered(leaderboard); err != nil {
+		return err
+	}
+	if err := l.lrr.Delete(leaderboard); err != nil {
+		return err
+	}
+	l.invalidate()
+	return nil
+}
+
+// Get returns the leaderboard's registry entry, or the defaults if it was never registered
+func (l *LeaderboardRegistryService) Get(leaderboard int) (*entities.LeaderboardInfo, error) {
+	if leaderboard <= 0 {
+		return nil, ErrLeaderboardNotFound
+	}
+	info, err := l.lrr.Get(leaderboard)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return defaultLeaderboardInfo(leaderboard), nil
+	}
+	return info, nil
+}
+
+func (l *LeaderboardRegistryService) getRegistered(leaderboard int) (*entities.LeaderboardInfo, error) {
+	if leaderboard <= 0 {
+		return nil, ErrLeaderboardNotFound
+	}
+	info, err := l.lrr.Get(leaderboard)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, ErrLeaderboardNotFound
+	}
+	return info, nil
+}
+
+// GetAll returns registered leaderboards together with the unregistered ones that have users, ordered by id
+func (l *LeaderboardRegistryService) GetAll() ([]*entities.LeaderboardInfo, error) {
+	registered, err := l.lrr.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return l.withUnregistered(byLeaderboardId(registered))
+}
+
+// GetListed is GetAll without closed and hidden leaderboards, read from the cached registry
+func (l *LeaderboardRegistryService) GetListed() ([]*entities.LeaderboardInfo, error) {
+	registered, err := l.getCached()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := l.withUnregistered(registered)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(infos, func(info *entities.LeaderboardInfo) bool {
+		return !info.Listed()
+	}), nil
+}
+
+func byLeaderboardId(infos []*entities.LeaderboardInfo) map[int]*entities.LeaderboardInfo {
+	registered := make(map[int]*entities.LeaderboardInfo, len(infos))
+	for _, info := range infos {
+		registered[info.Id] = info
+	}
+	return registered
+}
+
+func (l *LeaderboardRegistryService) withUnregistered(registered map[int]*entities.LeaderboardInfo) ([]*entities.LeaderboardInfo, error) {
+	leaderboardIds, err := l.lr.GetAllLeaderboardsIds()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*entities.LeaderboardInfo, 0, len(registered)+len(leaderboardIds))
+	for _, info := range registered {
+		infos = append(infos, info)
+	}
+	for _, leaderboard := range leaderboardIds {
+		if _, ok := registered[leaderboard]; !ok {
+			infos = append(infos, defaultLeaderboardInfo(leaderboard))
+		}
+	}
+	slices.SortFunc(infos, func(a, b *entities.LeaderboardInfo) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	return infos, nil
+}
+
+// OpenLeaderboards filters out closed leaderboards, read from the cached registry since every action goes through it
+func (l *LeaderboardRegistryService) OpenLeaderboards(leaderboards []int) ([]int, error) {
+	registered, err := l.getCached()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(slices.Clone(leaderboards), func(leaderboard int) bool {
+		info, ok := registered[leaderboard]
+		return ok && info.Closed()
+	}), nil
+}
+
+func (l *LeaderboardRegistryService) invalidate() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.registered = nil
+}
+
+// getCached returns registered leaderboards by id, the map is shared and must not be modified
+func (l *LeaderboardRegistryService) getCached() (map[int]*entities.LeaderboardInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.registered == nil || time.Since(l.refreshedAt) >= l.refresh {
+		registered, err := l.lrr.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		l.registered, l.refreshedAt = byLeaderboardId(registered), time.Now()
+	}
+	return l.registered, nil
+}
+
+func (l *LeaderboardRegistryService) Purge() error {
+	if err := l.lrr.Purge(); err != nil {
+		return err
+	}
+	l.invalidate()
+	return nil
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	TransferFormatCsv    = "csv"
+	TransferFormatNdjson = "ndjson"
+
+	leaderboardTransferChunkSize  = 1000
+	leaderboardImportUnknownLimit = 100
+)
+
+var (
+	ErrInvalidTransferFormat    = errors.New("invalid transfer format")
+	ErrInvalidLeaderboardImport = errors.New("invalid leaderboard import")
+)
+
+var leaderboardCsvHeader = []string{"position", "user_id", "nickname", "level", "score"}
+
+// LeaderboardTransferService exports whole leaderboards and imports scores into them, both in CSV with a header
+// line or in newline delimited JSON. Imports overwrite scores of the listed users only. They are applied like
+// any other score change: global scores move by the difference and users not on the leaderboard yet become
+// members of it, so later actions keep scoring there.
+type LeaderboardTransferService struct {
+	lr     repositories.LeaderboardRepo
+	upr    repositories.UserProfileRepository
+	lmr    repositories.LeaderboardMembershipRepository
+	lrs    *LeaderboardRegistryService
+	filter NicknameFilter
+}
+
+func NewLeaderboardTransferService(lr repositories.LeaderboardRepo, upr repositories.UserProfileRepository, lmr repositories.LeaderboardMembershipRepository, lrs *LeaderboardRegistryService, filter NicknameFilter) *LeaderboardTransferService {
+	return &LeaderboardTransferService{
+		lr:     lr,
+		upr:    upr,
+		lmr:    lmr,
+		lrs:    lrs,
+		filter: filter,
+	}
+}
+
+func ValidTransferFormat(format string) bool {
+	return format == TransferFormatCsv || format == TransferFormatNdjson
+}
+
+// Export writes every visible rank of the leaderboard, reading it a chunk at a time
+func (l *LeaderboardTransferService) Export(leaderboard int, format string, w io.Writer) error {
+	if !ValidTransferFormat(format) {
+		return ErrInvalidTransferFormat
+	}
+	csvWriter := csv.NewWriter(w)
+	jsonEncoder := json.NewEncoder(w)
+	if format == TransferFormatCsv {
+		if err := csvWriter.Write(leaderboardCsvHeader); err != nil {
+			return err
+		}
+	}
+	for offset := 0; ; offset += leaderboardTransferChunkSize {
+		scores, err := l.lr.GetStandings(leaderboard, offset, leaderboardTransferChunkSize)
+		if err != nil {
+			return err
+		}
+		rows, err := l.exportRows(scores)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if format == TransferFormatCsv {
+				err = csvWriter.Write([]string{
+					strconv.Itoa(row.Position),
+					row.UserId,
+					row.Nickname,
+					strconv.Itoa(row.Level),
+					strconv.Itoa(row.Score),
+				})
+			} else {
+				err = jsonEncoder.Encode(row)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return err
+		}
+		if len(scores) < leaderboardTransferChunkSize {
+			return nil
+		}
+	}
+}
+
+// exportRows adds nicknames and levels, users whose profile is gone keep their rank with both left empty
+func (l *LeaderboardTransferService) exportRows(scores []*entities.LeaderboardScore) ([]*entities.LeaderboardExportRow, error) {
+	userIds := make([]string, 0, len(scores))
+	for _, score := range scores {
+		userIds = append(userIds, score.UserId)
+	}
+	userProfiles, err := l.upr.GetManyUserProfiles(userIds)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]*entities.UserProfile, len(userProfiles))
+	for _, profile := range userProfiles {
+		profiles[profile.Id] = profile
+	}
+	rows := make([]*entities.LeaderboardExportRow, 0, len(scores))
+	for _, score := range scores {
+		row := &entities.LeaderboardExportRow{
+			Position: score.Position,
+			UserId:   score.UserId,
+			Score:    score.Score,
+		}
+		if profile, ok := profiles[score.UserId]; ok {
+			row.Nickname = l.filter.Mask(profile.Nickname)
+			row.Level = profile.Level
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Import sets the scores listed in the data, the whole input is parsed before anything is written.
+// A user listed twice gets the last score. Closed leaderboards are frozen and can't be imported into.
+func (l *LeaderboardTransferService) Import(leaderboard int, format string, data []byte) (*entities.LeaderboardImportResult, error) {
+	if !ValidTransferFormat(format) {
+		return nil, ErrInvalidTransferFormat
+	}
+	info, err := l.lrs.Get(leaderboard)
+	if err != nil {
+		return nil, err
+	}
+	if info.Closed() {
+		return nil, ErrLeaderboardClosed
+	}
+	var scores []*entities.LeaderboardScore
+	if format == TransferFormatCsv {
+		scores, err = parseCsvImport(data)
+	} else {
+		scores, err = parseNdjsonImport(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	memberIds, err := l.lmr.GetLeaderboardMemberIds(leaderboard)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool, len(memberIds))
+	for _, userId := range memberIds {
+		members[userId] = true
+	}
+	result := &entities.LeaderboardImportResult{Leaderboard: leaderboard, UnknownUserIds: make([]string, 0)}
+	for start := 0; start < len(scores); start += leaderboardTransferChunkSize {
+		if err := l.importChunk(leaderboard, scores[start:min(start+leaderboardTransferChunkSize, len(scores))], members, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// importChunk makes the listed users members of the leaderboard unless it's their primary one, then sets their scores
+func (l *LeaderboardTransferService) importChunk(leaderboard int, chunk []*entities.LeaderboardScore, members map[string]bool, result *entities.LeaderboardImportResult) error {
+	userIds := make([]string, 0, len(chunk))
+	for _, score := range chunk {
+		userIds = append(userIds, score.UserId)
+	}
+	userProfiles, err := l.upr.GetManyUserProfiles(userIds)
+	if err != nil {
+		return err
+	}
+	profiles := make(map[string]*entities.UserProfile, len(userProfiles))
+	for _, profile := range userProfiles {
+		profiles[profile.Id] = profile
+	}
+	valid := make([]*entities.LeaderboardScore, 0, len(chunk))
+	for _, score := range chunk {
+		profile, ok := profiles[score.UserId]
+		switch {
+		case !ok:
+			result.Unknown++
+			if len(result.UnknownUserIds) < leaderboardImportUnknownLimit {
+				result.UnknownUserIds = append(result.UnknownUserIds, score.UserId)
+			}
+		case profile.Status != entities.UserStatusActive:
+			result.Skipped++
+		default:
+			valid = append(valid, score)
+		}
+	}
+	now := time.Now().UnixMilli()
+	for _, score := range valid {
+		if profiles[score.UserId].Leaderboard == leaderboard || members[score.UserId] {
+			continue
+		}
+		err := l.lmr.Add(&entities.LeaderboardMembership{UserId: score.UserId, Leaderboard: leaderboard, JoinedAt: now})
+		if err != nil {
+			return err
+		}
+		members[score.UserId] = true
+	}
+	if err := l.lr.SetScores(leaderboard, valid); err != nil {
+		return err
+	}
+	result.Imported += len(valid)
+	return nil
+}
+
+// dedupeImport keeps the last score of every user, in the order users first appeared
+func dedupeImport(scores []*entities.LeaderboardScore) []*entities.LeaderboardScore {
+	indexes := make(map[string]int, len(scores))
+	deduped := make([]*entities.LeaderboardScore, 0, len(scores))
+	for _, score := range scores {
+		if i, ok := indexes[score.UserId]; ok {
+			deduped[i] = score
+			continue
+		}
+		indexes[score.UserId] = len(deduped)
+		deduped = append(deduped, score)
+	}
+	return deduped
+}
+
+func parseImportScore(line int, userId string, score string) (*entities.LeaderboardScore, error) {
+	if _, err := gocql.ParseUUID(userId); err != nil {
+		return nil, fmt.Errorf("%w: line %d: invalid user_id %q", ErrInvalidLeaderboardImport, line, userId)
+	}
+	value, err := strconv.Atoi(score)
+	if err != nil {
+		return nil, fmt.Errorf("%w: line %d: invalid score %q", ErrInvalidLeaderboardImport, line, score)
+	}
+	return &entities.LeaderboardScore{UserId: userId, Score: value}, nil
+}
+
+// parseCsvImport reads user_id and score by the header line, so exports can be imported back as they are
+func parseCsvImport(data []byte) ([]*entities.LeaderboardScore, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing header", ErrInvalidLeaderboardImport)
+	}
+	userIdColumn, scoreColumn := -1, -1
+	for i, column := range header {
+		switch column {
+		case "user_id":
+			userIdColumn = i
+		case "score":
+			scoreColumn = i
+		}
+	}
+	if userIdColumn < 0 || scoreColumn < 0 {
+		return nil, fmt.Errorf("%w: header must have user_id and score columns", ErrInvalidLeaderboardImport)
+	}
+	var scores []*entities.LeaderboardScore
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidLeaderboardImport, err.Error())
+		}
+		if len(record) <= max(userIdColumn, scoreColumn) {
+			return nil, fmt.Errorf("%w: line %d: missing columns", ErrInvalidLeaderboardImport, line)
+		}
+		score, err := parseImportScore(line, record[userIdColumn], record[scoreColumn])
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+	}
+	return dedupeImport(scores), nil
+}
+
+func parseNdjsonImport(data []byte) ([]*entities.LeaderboardScore, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var scores []*entities.LeaderboardScore
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		row := &struct {
+			UserId string      `json:"user_id"`
+			Score  json.Number `json:"score"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), row); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidLeaderboardImport, line, err.Error())
+		}
+		score, err := parseImportScore(line, row.UserId, row.Score.String())
+		if err != nil {
+			return nil, err
+		}
+		scores = append(scores, score)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLeaderboardImport, err.Error())
+	}
+	return dedupeImport(scores), nil
+}
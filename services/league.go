@@ -0,0 +1,370 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+const userLeagueResultsLimit = 20
+
+var errLeagueCloseTakenOver = errors.New("league cycle close was taken over by another instance")
+
+// LeagueService runs league cycles. Cycles are aligned to the unix epoch, at the end of each one the top users
+// of every leaderboard of a tier are promoted to the tier above, the bottom ones relegated to the tier below,
+// and scores of all tier leaderboards start over from zero. Shadow-banned users are neither ranked nor moved.
+type LeagueService struct {
+	upr     repositories.UserProfileRepository
+	lr      repositories.LeaderboardRepo
+	lrr     repositories.LeagueResultRepository
+	lcr     repositories.LeagueCycleRepository
+	lclr    repositories.LeagueCloseRepository
+	ep      *EventPublisher
+	cfg     game_config.LeaguesConfig
+	gc      *game_config.GameConfig
+	topic   string
+	lockTtl time.Duration
+	game    *game_config.Game
+}
+
+func NewLeagueService(ac *app_config.AppConfig, game *game_config.Game, gc *game_config.GameConfig, upr repositories.UserProfileRepository, lr repositories.LeaderboardRepo, lrr repositories.LeagueResultRepository, lcr repositories.LeagueCycleRepository, lclr repositories.LeagueCloseRepository, ep *EventPublisher) *LeagueService {
+	if gc.Leagues.Enabled && (gc.Leagues.CycleHours <= 0 || len(gc.Leagues.Tiers) == 0) {
+		panic("leagues need a positive cycle length and at least one tier")
+	}
+	return &LeagueService{
+		upr:     upr,
+		lr:      lr,
+		lrr:     lrr,
+		lcr:     lcr,
+		lclr:    lclr,
+		ep:      ep,
+		cfg:     gc.Leagues,
+		gc:      gc,
+		topic:   ac.KafkaLeagueResultsTopic,
+		lockTtl: ac.LeagueCycleLockTtl,
+		game:    game,
+	}
+}
+
+func (l *LeagueService) Enabled() bool {
+	return l.cfg.Enabled
+}
+
+func (l *LeagueService) cycleDuration() time.Duration {
+	return time.Duration(l.cfg.CycleHours) * time.Hour
+}
+
+func (l *LeagueService) currentCycle() int {
+	return int(time.Now().UnixMilli() / l.cycleDuration().Milliseconds())
+}
+
+// CloseEndedCycle closes the cycle that ended last unless it already was. When the service was down for
+// several cycles only the last one is closed, standings of the others are gone anyway. A close is claimed
+// with a lightweight transaction so only one instance runs it, one that hasn't renewed its claim for
+// longer than the lock ttl is assumed dead and taken over.
+func (l *LeagueService) CloseEndedCycle() error {
+	endedCycle := l.currentCycle() - 1
+	lastClosed, err := l.lcr.GetLastClosedCycle()
+	if err != nil {
+		return err
+	}
+	if lastClosed == 0 {
+		// leagues were just enabled, the running cycle is the first one
+		return l.lcr.SetLastClosedCycle(endedCycle)
+	}
+	if lastClosed >= endedCycle {
+		return nil
+	}
+	cycleClose, err := l.lclr.GetClose(endedCycle)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	var claimed bool
+	switch {
+	case cycleClose == nil:
+		cycleClose = &entities.LeagueCycleClose{Cycle: endedCycle, Status: entities.LeagueCycleStatusClosing}
+		claimed, err = l.lclr.StartClose(endedCycle, now)
+	case cycleClose.Status == entities.LeagueCycleStatusClosed:
+		return l.lcr.SetLastClosedCycle(endedCycle)
+	case now-cycleClose.ClosingAt >= l.lockTtl.Milliseconds():
+		claimed, err = l.lclr.ClaimClose(endedCycle, cycleClose.ClosingAt, now)
+	default:
+		return nil
+	}
+	if err != nil || !claimed {
+		return err
+	}
+	cycleClose.ClosingAt = now
+	if err := l.closeCycle(cycleClose); err != nil {
+		// released, so the next check resumes the close instead of waiting for the claim to go stale
+		if _, releaseErr := l.lclr.ClaimClose(endedCycle, cycleClose.ClosingAt, 0); releaseErr != nil {
+			slog.With("cycle", endedCycle, "error", releaseErr).Error("Failed to release league cycle close")
+		}
+		return err
+	}
+	return l.lcr.SetLastClosedCycle(endedCycle)
+}
+
+// renewClaim keeps other instances from taking over a close that is still making progress
+func (l *LeagueService) renewClaim(cycleClose *entities.LeagueCycleClose) error {
+	now := time.Now().UnixMilli()
+	renewed, err := l.lclr.ClaimClose(cycleClose.Cycle, cycleClose.ClosingAt, now)
+	if err != nil {
+		return err
+	}
+	if !renewed {
+		return errLeagueCloseTakenOver
+	}
+	cycleClose.ClosingAt = now
+	return nil
+}
+
+func (l *LeagueService) tierLeaderboards() []int {
+	leaderboards := make([]int, 0)
+	for _, tier := range l.cfg.Tiers {
+		leaderboards = append(leaderboards, tier.Leaderboards...)
+	}
+	return leaderboards
+}
+
+// closeCycle runs the close in recorded steps, so a failed close resumes where it stopped and never ranks
+// leaderboards that were already reset: the moves of all users are planned from the final standings and saved,
+// then the tier leaderboards are reset, then the moves are applied one by one. Result events are published last,
+// they are keyed by user so consumers can deduplicate those of a close that was resumed by cycle and user.
+func (l *LeagueService) closeCycle(cycleClose *entities.LeagueCycleClose) error {
+	start := time.Now()
+	if !cycleClose.Planned {
+		if err := l.planMoves(cycleClose); err != nil {
+			return err
+		}
+		if err := l.lclr.MarkPlanned(cycleClose.Cycle); err != nil {
+			return err
+		}
+	}
+	for _, leaderboard := range l.tierLeaderboards() {
+		if slices.Contains(cycleClose.ResetLeaderboards, leaderboard) {
+			continue
+		}
+		if err := l.renewClaim(cycleClose); err != nil {
+			return err
+		}
+		if err := l.lr.ResetScores(leaderboard); err != nil {
+			return err
+		}
+		if err := l.lclr.MarkReset(cycleClose.Cycle, leaderboard); err != nil {
+			return err
+		}
+	}
+
+	events := make([]Event, 0)
+	for _, leaderboard := range l.tierLeaderboards() {
+		if err := l.renewClaim(cycleClose); err != nil {
+			return err
+		}
+		moves, err := l.lclr.GetMoves(cycleClose.Cycle, leaderboard)
+		if err != nil {
+			return err
+		}
+		for _, move := range moves {
+			if !move.Applied {
+				applied, err := l.applyMove(move)
+				if err != nil {
+					return err
+				}
+				if !applied {
+					continue
+				}
+			}
+			events = append(events, Event{Key: move.UserId, Payload: &move.LeagueResult})
+		}
+	}
+	if err := l.ep.Publish(l.topic, events...); err != nil {
+		return err
+	}
+	if err := l.lclr.MarkClosed(cycleClose.Cycle, time.Now().UnixMilli()); err != nil {
+		return err
+	}
+	slog.With("cycle", cycleClose.Cycle, "users", len(events), "duration", time.Since(start)).Info("League cycle closed")
+	return nil
+}
+
+// planMoves ranks every tier leaderboard and saves where each user goes. Leaderboards keep their users until
+// the moves are applied, so the least populated one of the target tier can be picked up front.
+func (l *LeagueService) planMoves(cycleClose *entities.LeagueCycleClose) error {
+	tiers := l.cfg.Tiers
+	sizes, err := l.lr.GetLeaderboardsSizes(l.tierLeaderboards())
+	if err != nil {
+		return err
+	}
+	closedAt := time.Now().UnixMilli()
+	for tierIndex, tier := range tiers {
+		for _, leaderboard := range tier.Leaderboards {
+			if err := l.renewClaim(cycleClose); err != nil {
+				return err
+			}
+			standings, err := l.lr.GetStandings(leaderboard, 0, -1)
+			if err != nil {
+				return err
+			}
+			if standings, err = l.primaryMembers(leaderboard, standings); err != nil {
+				return err
+			}
+			moves := make([]*entities.LeagueMove, 0, len(standings))
+			for i, standing := range standings {
+				move := &entities.LeagueMove{
+					LeagueResult: entities.LeagueResult{
+						UserId:          standing.UserId,
+						Cycle:           cycleClose.Cycle,
+						ClosedAt:        closedAt,
+						FromLeaderboard: leaderboard,
+						ToLeaderboard:   leaderboard,
+						FromTier:        tier.Name,
+						ToTier:          tier.Name,
+						Outcome:         entities.LeagueOutcomeStayed,
+						Position:        standing.Position,
+						Score:           standing.Score,
+					},
+				}
+				toTier := tierIndex
+				switch {
+				case i < l.cfg.PromotionCount && tierIndex < len(tiers)-1:
+					move.Outcome = entities.LeagueOutcomePromoted
+					toTier = tierIndex + 1
+				case i >= len(standings)-l.cfg.RelegationCount && tierIndex > 0:
+					move.Outcome = entities.LeagueOutcomeRelegated
+					toTier = tierIndex - 1
+				}
+				if move.Outcome != entities.LeagueOutcomeStayed {
+					move.ToTier = tiers[toTier].Name
+					move.ToLeaderboard = leastPopulated(tiers[toTier].Leaderboards, sizes)
+					sizes[move.FromLeaderboard]--
+					sizes[move.ToLeaderboard]++
+				}
+				moves = append(moves, move)
+			}
+			// moves of an earlier attempt are replaced, users may have left the leaderboard since
+			if err := l.lclr.DeleteMoves(cycleClose.Cycle, leaderboard); err != nil {
+				return err
+			}
+			if err := l.lclr.SaveMoves(moves); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// primaryMembers leaves out users the leaderboard is a secondary one of, leagues only move users between primary
+// leaderboards. Profiles are read in chunks within the partition limit.
+func (l *LeagueService) primaryMembers(leaderboard int, standings []*entities.LeaderboardScore) ([]*entities.LeaderboardScore, error) {
+	members := make([]*entities.LeaderboardScore, 0, len(standings))
+	for chunk := range slices.Chunk(standings, repositories.RankHistoryMaxPartitionsPerQuery) {
+		userIds := make([]string, 0, len(chunk))
+		for _, standing := range chunk {
+			userIds = append(userIds, standing.UserId)
+		}
+		userProfiles, err := l.upr.GetManyUserProfiles(userIds)
+		if err != nil {
+			return nil, err
+		}
+		primary := make(map[string]bool, len(userProfiles))
+		for _, profile := range userProfiles {
+			primary[profile.Id] = profile.Leaderboard == leaderboard
+		}
+		for _, standing := range chunk {
+			if primary[standing.UserId] {
+				members = append(members, standing)
+			}
+		}
+	}
+	return members, nil
+}
+
+// applyMove moves the user and records the result, false if the move was skipped because the user is gone
+func (l *LeagueService) applyMove(move *entities.LeagueMove) (bool, error) {
+	if move.Outcome != entities.LeagueOutcomeStayed {
+		moved, err := l.moveUser(move.UserId, move.FromLeaderboard, move.ToLeaderboard)
+		if err != nil {
+			return false, err
+		}
+		if !moved {
+			slog.With("userId", move.UserId, "cycle", move.Cycle).Warn("Skipped league move of a deleted user")
+			metrics.GetOrCreateCounter(l.game.MetricName(`league_moves_skipped_total`)).Inc()
+			return false, l.lclr.DeleteMove(move)
+		}
+	}
+	if err := l.lrr.Save(&move.LeagueResult); err != nil {
+		return false, err
+	}
+	if err := l.lclr.MarkMoveApplied(move); err != nil {
+		return false, err
+	}
+	metrics.GetOrCreateCounter(l.game.MetricName(fmt.Sprintf(`league_results_total{outcome=%q}`, move.Outcome))).Inc()
+	return true, nil
+}
+
+// moveUser updates the profile first, so actions consumed meanwhile already score on the new leaderboard.
+// A user deleted since the moves were planned is left alone.
+func (l *LeagueService) moveUser(userId string, from int, to int) (bool, error) {
+	moved, err := l.upr.UpdateLeaderboard(userId, to)
+	if err != nil || !moved {
+		return false, err
+	}
+	if err := l.lr.RemoveUser(from, userId); err != nil {
+		return false, err
+	}
+	return true, l.lr.AddUser(to, userId)
+}
+
+func leastPopulated(leaderboards []int, sizes map[int]int) int {
+	smallest := leaderboards[0]
+	for _, leaderboard := range leaderboards[1:] {
+		if sizes[leaderboard] < sizes[smallest] {
+			smallest = leaderboard
+		}
+	}
+	return smallest
+}
+
+func (l *LeagueService) GetUserLeague(userId string) (*entities.UserLeague, error) {
+	userProfile, err := l.upr.GetUserProfileEventual(userId)
+	if err != nil {
+		return nil, err
+	}
+	if userProfile == nil {
+		return nil, ErrUserNotFound
+	}
+	results, err := l.lrr.GetUserResults(userId, userLeagueResultsLimit)
+	if err != nil {
+		return nil, err
+	}
+	userLeague := &entities.UserLeague{
+		Leaderboard: userProfile.Leaderboard,
+		Results:     results,
+	}
+	if tierIndex := l.gc.LeagueTierOf(userProfile.Leaderboard); l.cfg.Enabled && tierIndex >= 0 {
+		cycle := l.currentCycle()
+		userLeague.Tier = l.cfg.Tiers[tierIndex].Name
+		userLeague.Cycle = cycle
+		userLeague.CycleEndsAt = int64(cycle+1) * l.cycleDuration().Milliseconds()
+	}
+	return userLeague, nil
+}
+
+func (l *LeagueService) Purge() error {
+	if err := l.lrr.Purge(); err != nil {
+		return err
+	}
+	if err := l.lclr.Purge(); err != nil {
+		return err
+	}
+	return l.lcr.Purge()
+}
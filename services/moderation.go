@@ -0,0 +1,225 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"time"
+)
+
+const (
+	ModerationActionBan         = "ban"
+	ModerationActionShadowBan   = "shadow_ban"
+	ModerationActionUnban       = "unban"
+	ModerationActionAdjustScore = "adjust_score"
+	ModerationActionAdjustXp    = "adjust_xp"
+)
+
+var ErrUserNotFound = errors.New("user not found")
+
+type ModerationService struct {
+	upr repositories.UserProfileRepository
+	lr  repositories.LeaderboardRepo
+	uxr repositories.UserXpRepository
+	mar repositories.ModerationAuditRepository
+	lms *LeaderboardMembershipService
+	cs  *ClanService
+	ts  *TournamentService
+	gc  *game_config.GameConfig
+}
+
+func NewModerationService(gc *game_config.GameConfig, upr repositories.UserProfileRepository, lr repositories.LeaderboardRepo, uxr repositories.UserXpRepository, mar repositories.ModerationAuditRepository, lms *LeaderboardMembershipService, cs *ClanService, ts *TournamentService) *ModerationService {
+	return &ModerationService{
+		upr: upr,
+		lr:  lr,
+		uxr: uxr,
+		mar: mar,
+		lms: lms,
+		cs:  cs,
+		ts:  ts,
+		gc:  gc,
+	}
+}
+
+func (m *ModerationService) getUserProfile(userId string) (*entities.UserProfile, error) {
+	userProfile, err := m.upr.GetUserProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	if userProfile == nil {
+		return nil, ErrUserNotFound
+	}
+	return userProfile, nil
+}
+
+func (m *ModerationService) audit(userId string, action string, reason string, details string) error {
+	return m.mar.Record(&entities.ModerationAuditEntry{
+		UserId:    userId,
+		Action:    action,
+		Reason:    reason,
+		Details:   details,
+		CreatedAt: time.Now().UnixMilli(),
+	})
+}
+
+// Ban removes the user and their score from all their leaderboards, tournaments and their clan, actions of banned users are rejected from then on
+func (m *ModerationService) Ban(userId string, reason string) error {
+	userProfile, err := m.getUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	leaderboards, err := m.lms.UserLeaderboards(userProfile)
+	if err != nil {
+		return err
+	}
+	if err := m.upr.UpdateStatus(userId, entities.UserStatusBanned); err != nil {
+		return err
+	}
+	for _, leaderboard := range leaderboards {
+		if err := m.lr.RemoveUser(leaderboard, userId); err != nil {
+			return err
+		}
+	}
+	if err := m.lr.RemoveFromGlobal(userId); err != nil {
+		return err
+	}
+	if err := m.cs.RemoveUser(userProfile); err != nil {
+		return err
+	}
+	if err := m.ts.RemoveUser(userId); err != nil {
+		return err
+	}
+	return m.audit(userId, ModerationActionBan, reason, fmt.Sprintf("leaderboards=%v", leaderboards))
+}
+
+// ShadowBan hides the user from everyone but themselves, they keep playing and scoring as usual
+func (m *ModerationService) ShadowBan(userId string, reason string) error {
+	userProfile, err := m.getUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	leaderboards, err := m.lms.UserLeaderboards(userProfile)
+	if err != nil {
+		return err
+	}
+	if err := m.upr.UpdateStatus(userId, entities.UserStatusShadowBanned); err != nil {
+		return err
+	}
+	for _, leaderboard := range leaderboards {
+		if err := m.lr.MoveToShadow(leaderboard, userId); err != nil {
+			return err
+		}
+	}
+	if err := m.lr.MoveToGlobalShadow(userId); err != nil {
+		return err
+	}
+	if err := m.ts.MoveToShadow(userId); err != nil {
+		return err
+	}
+	return m.audit(userId, ModerationActionShadowBan, reason, fmt.Sprintf("leaderboards=%v", leaderboards))
+}
+
+// Unban lifts both bans. Banned users start over with zero score, shadow-banned users keep theirs.
+func (m *ModerationService) Unban(userId string, reason string) error {
+	userProfile, err := m.getUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	if userProfile.Status == entities.UserStatusActive {
+		return nil
+	}
+	leaderboards, err := m.lms.UserLeaderboards(userProfile)
+	if err != nil {
+		return err
+	}
+	for _, leaderboard := range leaderboards {
+		if userProfile.Status == entities.UserStatusBanned {
+			err = m.lr.AddUser(leaderboard, userId)
+		} else {
+			err = m.lr.MoveFromShadow(leaderboard, userId)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if userProfile.Status == entities.UserStatusShadowBanned {
+		if err := m.lr.MoveFromGlobalShadow(userId); err != nil {
+			return err
+		}
+	}
+	if err := m.ts.RestoreUser(userId); err != nil {
+		return err
+	}
+	if err := m.upr.UpdateStatus(userId, entities.UserStatusActive); err != nil {
+		return err
+	}
+	return m.audit(userId, ModerationActionUnban, reason, fmt.Sprintf("previous_status=%s", userProfile.Status))
+}
+
+// AdjustScore changes the user's score on all their leaderboards and their global score, a reset starts them over at zero
+func (m *ModerationService) AdjustScore(userId string, req *entities.AdjustmentRequest) error {
+	userProfile, err := m.getUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	leaderboards, err := m.lms.UserLeaderboards(userProfile)
+	if err != nil {
+		return err
+	}
+	details := fmt.Sprintf("leaderboards=%v delta=%d", leaderboards, req.Delta)
+	if req.Reset {
+		details = fmt.Sprintf("leaderboards=%v reset", leaderboards)
+		err = m.lr.SetUserScores(leaderboards, userId, 0)
+	} else {
+		err = m.lr.AdjustScores(leaderboards, userId, req.Delta)
+	}
+	if err != nil {
+		return err
+	}
+	return m.audit(userId, ModerationActionAdjustScore, req.Reason, details)
+}
+
+// AdjustXp changes the user's xp and moves their level to match, which may also lower it
+func (m *ModerationService) AdjustXp(userId string, req *entities.AdjustmentRequest) error {
+	userProfile, err := m.getUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	details := fmt.Sprintf("delta=%d", req.Delta)
+	xp := 0
+	if req.Reset {
+		details = "reset"
+	} else {
+		if xp, err = m.uxr.IncrementXp(userId, req.Delta); err != nil {
+			return err
+		}
+	}
+	if xp < 0 {
+		xp = 0
+	}
+	if req.Reset || xp == 0 {
+		if err := m.uxr.SetXp(userId, xp); err != nil {
+			return err
+		}
+	}
+
+	newLevel := m.gc.LevelForXp(xp)
+	if newLevel != userProfile.Level {
+		updated, err := m.upr.UpdateLevel(userId, userProfile.Level, newLevel)
+		if err != nil {
+			return err
+		}
+		if !updated {
+			slog.With("userId", userId).Warn("User level update was ignored, race condition")
+		}
+		details += fmt.Sprintf(" level=%d->%d", userProfile.Level, newLevel)
+	}
+	return m.audit(userId, ModerationActionAdjustXp, req.Reason, details)
+}
+
+func (m *ModerationService) GetAudit(userId string) ([]*entities.ModerationAuditEntry, error) {
+	return m.mar.GetUserAudit(userId)
+}
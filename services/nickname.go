@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	NicknameFilterModeReject = "reject"
+	// nicknameSearchOverFetch is how many times the limit is read from the index per page,
+	// hidden users are only filtered out afterwards
+	nicknameSearchOverFetch = 2
+)
+
+var (
+	ErrInvalidNickname   = errors.New("invalid nickname")
+	ErrOffensiveNickname = errors.New("offensive nickname")
+	ErrNicknameTaken     = errors.New("nickname is taken")
+)
+
+type NicknameCooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *NicknameCooldownError) Error() string {
+	return "nickname was changed recently"
+}
+
+// NicknameService keeps nicknames unique ignoring case. Uniqueness is enforced by the user_nickname lookup table,
+// profiles created before it existed are not in there until they are renamed.
+type NicknameService struct {
+	upr    repositories.UserProfileRepository
+	uxr    repositories.UserXpRepository
+	nr     repositories.NicknameRepository
+	nir    repositories.NicknameIndexRepository
+	filter NicknameFilter
+	cfg    game_config.NicknameConfig
+}
+
+func NewNicknameService(gc *game_config.GameConfig, upr repositories.UserProfileRepository, uxr repositories.UserXpRepository, nr repositories.NicknameRepository, nir repositories.NicknameIndexRepository, filter NicknameFilter) *NicknameService {
+	return &NicknameService{
+		upr:    upr,
+		uxr:    uxr,
+		nr:     nr,
+		nir:    nir,
+		filter: filter,
+		cfg:    gc.Nickname,
+	}
+}
+
+// NormalizeNickname returns the form nicknames are compared in
+func NormalizeNickname(nickname string) string {
+	return strings.ToLower(strings.TrimSpace(nickname))
+}
+
+// Validate returns the nickname with surrounding whitespace trimmed, or why it can't be used
+func (n *NicknameService) Validate(nickname string) (string, error) {
+	nickname = strings.TrimSpace(nickname)
+	length := utf8.RuneCountInString(nickname)
+	if length < n.cfg.MinLength || (n.cfg.MaxLength > 0 && length > n.cfg.MaxLength) {
+		return "", ErrInvalidNickname
+	}
+	if n.cfg.FilterMode == NicknameFilterModeReject && n.filter.IsOffensive(nickname) {
+		return "", ErrOffensiveNickname
+	}
+	return nickname, nil
+}
+
+func (n *NicknameService) Claim(nickname string, userId string) error {
+	claimed, err := n.nr.Claim(NormalizeNickname(nickname), userId)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrNicknameTaken
+	}
+	if err := n.nir.Add(NormalizeNickname(nickname), userId); err != nil {
+		// an orphaned claim would keep the nickname taken for good
+		_ = n.nr.Release(NormalizeNickname(nickname), userId)
+		return err
+	}
+	return nil
+}
+
+func (n *NicknameService) Release(nickname string, userId string) error {
+	if err := n.nir.Remove(NormalizeNickname(nickname), userId); err != nil {
+		return err
+	}
+	return n.nr.Release(NormalizeNickname(nickname), userId)
+}
+
+func (n *NicknameService) Purge() error {
+	if err := n.nr.Purge(); err != nil {
+		return err
+	}
+	return n.nir.Purge()
+}
+
+// Lookup finds the user by exact nickname ignoring case. Banned and shadow-banned users are only
+// found with includeHidden, and nicknames are masked for everyone else.
+func (n *NicknameService) Lookup(nickname string, includeHidden bool) (*entities.UserProfile, error) {
+	userId, err := n.nr.GetUserId(NormalizeNickname(nickname))
+	if err != nil {
+		return nil, err
+	}
+	if userId == "" {
+		return nil, nil
+	}
+	userProfiles, err := n.getProfiles([]string{userId}, includeHidden)
+	if err != nil || len(userProfiles) == 0 {
+		return nil, err
+	}
+	return userProfiles[0], nil
+}
+
+// Search returns users whose nickname starts with the prefix, ordered by nickname
+func (n *NicknameService) Search(prefix string, limit int, includeHidden bool) ([]*entities.UserProfile, error) {
+	prefix = NormalizeNickname(prefix)
+	if prefix == "" {
+		return []*entities.UserProfile{}, nil
+	}
+	batch := limit * nicknameSearchOverFetch
+	result := make([]*entities.UserProfile, 0, limit)
+	for offset := 0; len(result) < limit; offset += batch {
+		userIds, err := n.nir.SearchPrefix(prefix, offset, batch)
+		if err != nil {
+			return nil, err
+		}
+		userProfiles, err := n.getProfiles(userIds, includeHidden)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, userProfiles...)
+		if len(userIds) < batch {
+			break
+		}
+	}
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (n *NicknameService) getProfiles(userIds []string, includeHidden bool) ([]*entities.UserProfile, error) {
+	if len(userIds) == 0 {
+		return []*entities.UserProfile{}, nil
+	}
+	userProfiles, err := n.upr.GetManyUserProfiles(userIds)
+	if err != nil {
+		return nil, err
+	}
+	userXps, err := n.uxr.GetManyUsersXp(userIds)
+	if err != nil {
+		return nil, err
+	}
+	userIdToProfile := make(map[string]*entities.UserProfile, len(userProfiles))
+	for _, profile := range userProfiles {
+		userIdToProfile[profile.Id] = profile
+	}
+	result := make([]*entities.UserProfile, 0, len(userIds))
+	for _, userId := range userIds {
+		profile, exists := userIdToProfile[userId]
+		if !exists {
+			continue
+		}
+		if !includeHidden {
+			if profile.Status != entities.UserStatusActive {
+				continue
+			}
+			profile.Nickname = n.filter.Mask(profile.Nickname)
+		}
+		profile.Xp = userXps[userId]
+		result = append(result, profile)
+	}
+	return result, nil
+}
+
+func (n *NicknameService) ChangeNickname(userId string, nickname string) (string, error) {
+	nickname, err := n.Validate(nickname)
+	if err != nil {
+		return "", err
+	}
+	userProfile, err := n.upr.GetUserProfile(userId)
+	if err != nil {
+		return "", err
+	}
+	if userProfile == nil {
+		return "", ErrUserNotFound
+	}
+	if userProfile.NicknameChangedAt > 0 {
+		nextChange := time.UnixMilli(userProfile.NicknameChangedAt).Add(time.Duration(n.cfg.ChangeCooldownHours) * time.Hour)
+		if wait := time.Until(nextChange); wait > 0 {
+			return "", &NicknameCooldownError{RetryAfter: wait}
+		}
+	}
+
+	sameNickname := NormalizeNickname(nickname) == NormalizeNickname(userProfile.Nickname)
+	if !sameNickname {
+		if err := n.Claim(nickname, userId); err != nil {
+			return "", err
+		}
+	}
+	updated, err := n.upr.UpdateNickname(userId, userProfile.Nickname, nickname)
+	if err != nil || !updated {
+		if !sameNickname {
+			_ = n.Release(nickname, userId)
+		}
+		if err == nil {
+			err = ErrNicknameTaken
+		}
+		return "", err
+	}
+	if !sameNickname {
+		if err := n.Release(userProfile.Nickname, userId); err != nil {
+			return "", err
+		}
+	}
+	return nickname, nil
+}
+
+func (n *NicknameService) Mask(nickname string) string {
+	return n.filter.Mask(nickname)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"github.com/skif48/leaderboard-engine/game_config"
+	"strings"
+	"unicode"
+)
+
+// NicknameFilter decides whether a nickname is offensive and how it is displayed.
+// Swap the implementation provided to fx to plug in another source of blocked words.
+type NicknameFilter interface {
+	IsOffensive(nickname string) bool
+	Mask(nickname string) string
+}
+
+// WordListNicknameFilter matches blocked words from GameConfig anywhere in the nickname,
+// ignoring case and the usual digit and symbol substitutions.
+type WordListNicknameFilter struct {
+	blockedWords [][]rune
+}
+
+var leetReplacements = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+}
+
+func NewNicknameFilter(gc *game_config.GameConfig) NicknameFilter {
+	blockedWords := make([][]rune, 0, len(gc.Nickname.BlockedWords))
+	for _, word := range gc.Nickname.BlockedWords {
+		if word = strings.TrimSpace(word); word != "" {
+			blockedWords = append(blockedWords, normalizeForFilter(word))
+		}
+	}
+	return &WordListNicknameFilter{blockedWords: blockedWords}
+}
+
+// normalizeForFilter maps rune to rune, so indexes of matches are valid in the original nickname
+func normalizeForFilter(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		if replacement, ok := leetReplacements[r]; ok {
+			r = replacement
+		}
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+func (w *WordListNicknameFilter) IsOffensive(nickname string) bool {
+	normalized := normalizeForFilter(nickname)
+	for _, word := range w.blockedWords {
+		if indexRunes(normalized, word, 0) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WordListNicknameFilter) Mask(nickname string) string {
+	runes := []rune(nickname)
+	normalized := normalizeForFilter(nickname)
+	masked := false
+	for _, word := range w.blockedWords {
+		for i := indexRunes(normalized, word, 0); i >= 0; i = indexRunes(normalized, word, i+len(word)) {
+			for j := i; j < i+len(word); j++ {
+				runes[j] = '*'
+			}
+			masked = true
+		}
+	}
+	if !masked {
+		return nickname
+	}
+	return string(runes)
+}
+
+func indexRunes(s []rune, sub []rune, from int) int {
+	for i := from; i+len(sub) <= len(s); i++ {
+		match := true
+		for j := range sub {
+			if s[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
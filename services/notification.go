@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"time"
+)
+
+// NotificationService tells players what happened to them while they play. Notifications go to a Kafka topic for
+// push delivery and to the user's live stream, both behind a per-user rate limit so a busy leaderboard doesn't spam.
+type NotificationService struct {
+	ep     *EventPublisher
+	nr     repositories.NotificationRepository
+	rl     repositories.RateLimiterRepository
+	topic  string
+	limit  int
+	window time.Duration
+	game   *game_config.Game
+}
+
+func NewNotificationService(ac *app_config.AppConfig, game *game_config.Game, ep *EventPublisher, nr repositories.NotificationRepository, rl repositories.RateLimiterRepository) *NotificationService {
+	return &NotificationService{
+		ep:     ep,
+		nr:     nr,
+		rl:     rl,
+		topic:  ac.KafkaOvertakenTopic,
+		limit:  ac.OvertakenNotificationLimit,
+		window: ac.OvertakenNotificationWindow,
+		game:   game,
+	}
+}
+
+// NotifyOvertaken notifies every overtaken user that is still under the rate limit
+func (n *NotificationService) NotifyOvertaken(events []*entities.OvertakenEvent) error {
+	var allowed []Event
+	for _, event := range events {
+		ok, _, err := n.rl.Hit("overtaken:"+event.UserId, n.limit, n.window)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			metrics.GetOrCreateCounter(n.game.MetricName(`notifications_total{type="overtaken",status="rate_limited"}`)).Inc()
+			continue
+		}
+		allowed = append(allowed, Event{Key: event.UserId, Payload: event})
+	}
+	if err := n.ep.Publish(n.topic, allowed...); err != nil {
+		return err
+	}
+	for _, event := range allowed {
+		n.push(event.Key, entities.NotificationTypeOvertaken, event.Payload)
+	}
+	metrics.GetOrCreateCounter(n.game.MetricName(`notifications_total{type="overtaken",status="sent"}`)).Add(len(allowed))
+	return nil
+}
+
+// push sends the notification to the user's stream, a user without an open stream simply misses it
+func (n *NotificationService) push(userId string, notificationType string, data any) {
+	payload, err := json.Marshal(&entities.Notification{
+		Type:      notificationType,
+		CreatedAt: time.Now().UnixMilli(),
+		Data:      data,
+	})
+	if err != nil {
+		slog.With("error", err, "userId", userId).Error("Failed to marshal notification")
+		return
+	}
+	if err := n.nr.Publish(userId, payload); err != nil {
+		slog.With("error", err, "userId", userId).Error("Failed to push notification")
+	}
+}
+
+// Subscribe streams the user's notifications as JSON into the channel until the context is done
+func (n *NotificationService) Subscribe(ctx context.Context, userId string, notifications chan<- []byte) error {
+	metrics.GetOrCreateGauge(n.game.MetricName(`notification_streams`), nil).Inc()
+	defer metrics.GetOrCreateGauge(n.game.MetricName(`notification_streams`), nil).Dec()
+	return n.nr.Subscribe(ctx, userId, func(payload []byte) {
+		select {
+		case notifications <- payload:
+		default:
+			metrics.GetOrCreateCounter(n.game.MetricName(`notifications_dropped_total{reason="slow_stream"}`)).Inc()
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrInvalidPurgeTarget       = errors.New("invalid purge target")
+	ErrInvalidPurgeConfirmation = errors.New("unknown or expired purge confirmation token")
+)
+
+// PurgeService wipes data in two steps: a purge is requested for a target and only executed
+// once the returned confirmation token is sent back before it expires.
+type PurgeService struct {
+	upr repositories.UserProfileRepository
+	lr  repositories.LeaderboardRepo
+	uxr repositories.UserXpRepository
+	pcr repositories.PurgeConfirmationRepository
+	lar repositories.LeaderboardAssignmentRepository
+	ns  *NicknameService
+	ls  *LeagueService
+	lms *LeaderboardMembershipService
+	fs  *FriendsService
+	cs  *ClanService
+	ts  *TournamentService
+	ws  *WebhookService
+	as  *AchievementService
+	ass *ActionStatsService
+	rhs *RankHistoryService
+	als *ActionLogService
+	lrs *LeaderboardRegistryService
+	uds *UserDataService
+	ttl time.Duration
+}
+
+func NewPurgeService(ac *app_config.AppConfig, upr repositories.UserProfileRepository, lr repositories.LeaderboardRepo, uxr repositories.UserXpRepository, pcr repositories.PurgeConfirmationRepository, lar repositories.LeaderboardAssignmentRepository, ns *NicknameService, ls *LeagueService, lms *LeaderboardMembershipService, fs *FriendsService, cs *ClanService, ts *TournamentService, ws *WebhookService, as *AchievementService, ass *ActionStatsService, rhs *RankHistoryService, als *ActionLogService, lrs *LeaderboardRegistryService, uds *UserDataService) *PurgeService {
+	return &PurgeService{
+		upr: upr,
+		lr:  lr,
+		uxr: uxr,
+		pcr: pcr,
+		lar: lar,
+		ns:  ns,
+		ls:  ls,
+		lms: lms,
+		fs:  fs,
+		cs:  cs,
+		ts:  ts,
+		ws:  ws,
+		as:  as,
+		ass: ass,
+		rhs: rhs,
+		als: als,
+		lrs: lrs,
+		uds: uds,
+		ttl: ac.PurgeConfirmationTtl,
+	}
+}
+
+func (p *PurgeService) RequestPurge(target *entities.PurgeTarget) (*entities.PurgeConfirmation, error) {
+	switch {
+	case target.Scope == entities.PurgeScopeAll:
+	case target.Scope == entities.PurgeScopeLeaderboard && target.LeaderboardId > 0:
+	case target.Scope == entities.PurgeScopeUser && target.UserId != "":
+	default:
+		return nil, ErrInvalidPurgeTarget
+	}
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	if err := p.pcr.Save(token, target, p.ttl); err != nil {
+		return nil, err
+	}
+	return &entities.PurgeConfirmation{
+		ConfirmationToken: token,
+		ExpiresAt:         time.Now().Add(p.ttl).UnixMilli(),
+		Target:            *target,
+	}, nil
+}
+
+func (p *PurgeService) ConfirmPurge(token string) (*entities.PurgeTarget, error) {
+	target, err := p.pcr.Take(token)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, ErrInvalidPurgeConfirmation
+	}
+	slog.With("scope", target.Scope, "leaderboardId", target.LeaderboardId, "userId", target.UserId).Warn("Purging data")
+	switch target.Scope {
+	case entities.PurgeScopeAll:
+		err = p.purgeAll()
+	case entities.PurgeScopeLeaderboard:
+		err = p.purgeLeaderboard(target.LeaderboardId)
+	case entities.PurgeScopeUser:
+		err = p.purgeUser(target.UserId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+func (p *PurgeService) purgeAll() error {
+	if err := p.upr.Purge(); err != nil {
+		return err
+	}
+	if err := p.ns.Purge(); err != nil {
+		return err
+	}
+	if err := p.lr.Purge(); err != nil {
+		return err
+	}
+	if err := p.lar.Purge(); err != nil {
+		return err
+	}
+	if err := p.ls.Purge(); err != nil {
+		return err
+	}
+	if err := p.lms.Purge(); err != nil {
+		return err
+	}
+	if err := p.fs.Purge(); err != nil {
+		return err
+	}
+	if err := p.cs.Purge(); err != nil {
+		return err
+	}
+	if err := p.ts.Purge(); err != nil {
+		return err
+	}
+	if err := p.ws.PurgeDeliveries(); err != nil {
+		return err
+	}
+	if err := p.as.Purge(); err != nil {
+		return err
+	}
+	if err := p.ass.Purge(); err != nil {
+		return err
+	}
+	if err := p.rhs.Purge(); err != nil {
+		return err
+	}
+	if err := p.als.Purge(); err != nil {
+		return err
+	}
+	if err := p.lrs.Purge(); err != nil {
+		return err
+	}
+	return p.uxr.Purge()
+}
+
+// purgeLeaderboard erases every user whose primary leaderboard it is the same way their own erasure request would,
+// users who are only members of it just lose the membership
+func (p *PurgeService) purgeLeaderboard(leaderboard int) error {
+	userIds, err := p.upr.GetLeaderboardUserIds(leaderboard)
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		if err := p.uds.Erase(userId); err != nil && !errors.Is(err, ErrUserNotFound) {
+			return err
+		}
+	}
+	if err := p.lms.PurgeLeaderboardMemberships(leaderboard); err != nil {
+		return err
+	}
+	return p.lr.PurgeLeaderboard(leaderboard)
+}
+
+func (p *PurgeService) purgeUser(userId string) error {
+	return p.uds.Erase(userId)
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+)
+
+const (
+	rankHistoryDefaultDays = 7
+	rankHistoryMaxDays     = 31
+	rankSnapshotPageSize   = 1000
+	rankSparklineWindow    = 24 * time.Hour
+)
+
+var ErrInvalidHistoryRange = errors.New("invalid history range")
+
+// RankHistoryService snapshots the score and position of every user on every leaderboard periodically and serves
+// the resulting timelines
+type RankHistoryService struct {
+	lr        repositories.LeaderboardRepo
+	rhr       repositories.RankHistoryRepository
+	interval  time.Duration
+	retention time.Duration
+	game      *game_config.Game
+}
+
+func NewRankHistoryService(ac *app_config.AppConfig, game *game_config.Game, lr repositories.LeaderboardRepo, rhr repositories.RankHistoryRepository) *RankHistoryService {
+	return &RankHistoryService{
+		lr:        lr,
+		rhr:       rhr,
+		interval:  ac.RankSnapshotInterval,
+		retention: ac.RankHistoryRetention,
+		game:      game,
+	}
+}
+
+// Snapshot records every visible user of every leaderboard. Snapshots are aligned to the interval and claimed per
+// leaderboard, so instances ticking within the same interval share the work and only one of them takes each
+// leaderboard's snapshot. A leaderboard that fails is released for the next instance ticking within the interval
+// and the others are still taken.
+func (r *RankHistoryService) Snapshot() error {
+	start := time.Now()
+	takenAt := start.Truncate(r.interval).UnixMilli()
+	leaderboards, err := r.lr.GetAllLeaderboardsIds()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	taken, users := 0, 0
+	for _, leaderboard := range leaderboards {
+		claimed, err := r.rhr.ClaimSnapshot(takenAt, leaderboard, r.retention)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		snapshots, err := r.snapshotLeaderboard(leaderboard, takenAt)
+		if err != nil {
+			errs = append(errs, err)
+			if err := r.rhr.ReleaseSnapshot(takenAt, leaderboard); err != nil {
+				slog.With("leaderboard", leaderboard, "error", err).Error("Failed to release rank snapshot")
+			}
+			continue
+		}
+		taken++
+		users += snapshots
+	}
+	metrics.GetOrCreateCounter(r.game.MetricName(`rank_snapshots_total`)).Add(users)
+	if taken > 0 {
+		slog.With("leaderboards", taken, "snapshots", users, "duration", time.Since(start)).Info("Rank snapshot taken")
+	}
+	return errors.Join(errs...)
+}
+
+// snapshotLeaderboard records every visible user of the leaderboard and returns how many there were
+func (r *RankHistoryService) snapshotLeaderboard(leaderboard int, takenAt int64) (int, error) {
+	users := 0
+	for offset := 0; ; offset += rankSnapshotPageSize {
+		scores, err := r.lr.GetStandings(leaderboard, offset, rankSnapshotPageSize)
+		if err != nil {
+			return 0, err
+		}
+		snapshots := make([]*entities.RankSnapshot, 0, len(scores))
+		for _, score := range scores {
+			snapshots = append(snapshots, &entities.RankSnapshot{
+				UserId:      score.UserId,
+				Leaderboard: leaderboard,
+				TakenAt:     takenAt,
+				Score:       score.Score,
+				Position:    score.Position,
+			})
+		}
+		if err := r.rhr.SaveSnapshots(snapshots, r.retention); err != nil {
+			return 0, err
+		}
+		users += len(snapshots)
+		if len(scores) < rankSnapshotPageSize {
+			return users, nil
+		}
+	}
+}
+
+// GetUserHistory returns the user's timeline on every leaderboard within the "2006-01-02" days [from, to],
+// the last 7 days without a range
+func (r *RankHistoryService) GetUserHistory(userId string, from string, to string) (*entities.RankHistory, error) {
+	fromDay, toDay, ok := parseDayRange(from, to, rankHistoryDefaultDays, rankHistoryMaxDays)
+	if !ok {
+		return nil, ErrInvalidHistoryRange
+	}
+	snapshots, err := r.getSnapshots(userId, fromDay, toDay.Add(24*time.Hour-time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	return rankHistory(userId, snapshots), nil
+}
+
+// GetRetainedHistory returns the user's timeline on every leaderboard over the whole retention, for data exports
+func (r *RankHistoryService) GetRetainedHistory(userId string) (*entities.RankHistory, error) {
+	to := time.Now()
+	snapshots, err := r.getSnapshots(userId, to.Add(-r.retention), to)
+	if err != nil {
+		return nil, err
+	}
+	return rankHistory(userId, snapshots), nil
+}
+
+// rankHistory groups the snapshots, oldest first, into a series per leaderboard
+func rankHistory(userId string, snapshots []*entities.RankSnapshot) *entities.RankHistory {
+	history := &entities.RankHistory{UserId: userId, Series: make([]*entities.RankHistorySeries, 0)}
+	series := make(map[int]*entities.RankHistorySeries)
+	for _, snapshot := range snapshots {
+		leaderboardSeries, ok := series[snapshot.Leaderboard]
+		if !ok {
+			leaderboardSeries = &entities.RankHistorySeries{Leaderboard: snapshot.Leaderboard}
+			series[snapshot.Leaderboard] = leaderboardSeries
+			history.Series = append(history.Series, leaderboardSeries)
+		}
+		leaderboardSeries.Points = append(leaderboardSeries.Points, &entities.RankHistoryPoint{
+			TakenAt:  snapshot.TakenAt,
+			Score:    snapshot.Score,
+			Position: snapshot.Position,
+		})
+	}
+	slices.SortFunc(history.Series, func(a, b *entities.RankHistorySeries) int {
+		return cmp.Compare(a.Leaderboard, b.Leaderboard)
+	})
+	return history
+}
+
+// GetRecentPositions returns the positions of the last 24 hours of every listed user on the leaderboard they
+// are listed on, oldest first, keyed by leaderboard, then user. Users are read in batches, every listed user
+// is a partition per day of the window.
+func (r *RankHistoryService) GetRecentPositions(leaderboards map[int][]*entities.LeaderboardScoreFull) (map[int]map[string][]int, error) {
+	to := time.Now()
+	from := to.Add(-rankSparklineWindow)
+	days := historyDays(from, to)
+	type listedUser struct {
+		userId      string
+		leaderboard int
+	}
+	listed := make(map[listedUser]bool)
+	userIdSet := make(map[string]bool)
+	for leaderboard, scores := range leaderboards {
+		for _, score := range scores {
+			listed[listedUser{userId: score.UserId, leaderboard: leaderboard}] = true
+			userIdSet[score.UserId] = true
+		}
+	}
+	userIds := slices.Collect(maps.Keys(userIdSet))
+	snapshots := make([]*entities.RankSnapshot, 0)
+	for batch := range slices.Chunk(userIds, max(1, repositories.RankHistoryMaxPartitionsPerQuery/len(days))) {
+		batchSnapshots, err := r.rhr.GetUsersSnapshots(batch, days, from.UnixMilli(), to.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, batchSnapshots...)
+	}
+	slices.SortStableFunc(snapshots, func(a, b *entities.RankSnapshot) int {
+		return cmp.Compare(a.TakenAt, b.TakenAt)
+	})
+	positions := make(map[int]map[string][]int, len(leaderboards))
+	for leaderboard, scores := range leaderboards {
+		positions[leaderboard] = make(map[string][]int, len(scores))
+	}
+	for _, snapshot := range snapshots {
+		if !listed[listedUser{userId: snapshot.UserId, leaderboard: snapshot.Leaderboard}] {
+			continue
+		}
+		positions[snapshot.Leaderboard][snapshot.UserId] = append(positions[snapshot.Leaderboard][snapshot.UserId], snapshot.Position)
+	}
+	return positions, nil
+}
+
+// getSnapshots reads the day partitions covering [from, to], in chunks of days within the partition limit,
+// partitions come back in no particular order
+func (r *RankHistoryService) getSnapshots(userId string, from time.Time, to time.Time) ([]*entities.RankSnapshot, error) {
+	snapshots := make([]*entities.RankSnapshot, 0)
+	for days := range slices.Chunk(historyDays(from, to), repositories.RankHistoryMaxPartitionsPerQuery) {
+		daySnapshots, err := r.rhr.GetUserSnapshots(userId, days, from.UnixMilli(), to.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, daySnapshots...)
+	}
+	slices.SortStableFunc(snapshots, func(a, b *entities.RankSnapshot) int {
+		return cmp.Compare(a.TakenAt, b.TakenAt)
+	})
+	return snapshots, nil
+}
+
+func historyDays(from time.Time, to time.Time) []string {
+	var days []string
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		days = append(days, day.Format(actionStatsDayLayout))
+	}
+	return days
+}
+
+// EraseUser removes the user's snapshots of every day still within retention
+func (r *RankHistoryService) EraseUser(userId string) error {
+	to := time.Now()
+	return r.rhr.DeleteUserSnapshots(userId, historyDays(to.Add(-r.retention), to))
+}
+
+func (r *RankHistoryService) Purge() error {
+	return r.rhr.Purge()
+}
@@ -0,0 +1,495 @@
+package services
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gocql/gocql"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTournamentNotFound  = errors.New("tournament not found")
+	ErrInvalidTournament   = errors.New("invalid tournament")
+	ErrTournamentClosed    = errors.New("tournament is closed")
+	ErrTournamentForbidden = errors.New("user can't enter tournaments")
+)
+
+// TournamentService runs tournaments: leaderboards of registered users that only count actions timestamped
+// within the tournament's window. Once the window is over, plus a grace period for actions still in flight,
+// the final standings are frozen and the prize table is paid out as events.
+type TournamentService struct {
+	tr         repositories.TournamentRepository
+	lr         repositories.LeaderboardRepo
+	upr        repositories.UserProfileRepository
+	ls         *LeaderboardService
+	ep         *EventPublisher
+	ws         *WebhookService
+	topic      string
+	closeGrace time.Duration
+	lockTtl    time.Duration
+	refresh    time.Duration
+	game       *game_config.Game
+
+	mu          sync.Mutex
+	running     []*entities.Tournament
+	refreshedAt time.Time
+}
+
+func NewTournamentService(ac *app_config.AppConfig, game *game_config.Game, tr repositories.TournamentRepository, lr repositories.LeaderboardRepo, upr repositories.UserProfileRepository, ls *LeaderboardService, ep *EventPublisher, ws *WebhookService) *TournamentService {
+	return &TournamentService{
+		tr:         tr,
+		lr:         lr,
+		upr:        upr,
+		ls:         ls,
+		ep:         ep,
+		ws:         ws,
+		topic:      ac.KafkaTournamentPayoutsTopic,
+		closeGrace: ac.TournamentCloseGrace,
+		lockTtl:    ac.TournamentCloseLockTtl,
+		refresh:    ac.TournamentRegistryRefresh,
+		game:       game,
+	}
+}
+
+func validateTournamentRequest(req *entities.TournamentRequest) error {
+	if strings.TrimSpace(req.Name) == "" || req.StartsAt <= 0 || req.EndsAt <= req.StartsAt {
+		return ErrInvalidTournament
+	}
+	prizes := slices.Clone(req.Prizes)
+	slices.SortFunc(prizes, func(a, b *entities.TournamentPrize) int {
+		return cmp.Compare(a.FromRank, b.FromRank)
+	})
+	for i, prize := range prizes {
+		if prize.FromRank < 1 || prize.ToRank < prize.FromRank || prize.RewardId == "" {
+			return ErrInvalidTournament
+		}
+		if i > 0 && prize.FromRank <= prizes[i-1].ToRank {
+			return ErrInvalidTournament
+		}
+	}
+	return nil
+}
+
+func (t *TournamentService) Create(req *entities.TournamentRequest) (*entities.Tournament, error) {
+	if err := validateTournamentRequest(req); err != nil {
+		return nil, err
+	}
+	id, _ := gocql.RandomUUID()
+	tournament := &entities.Tournament{
+		Id:        id.String(),
+		Name:      strings.TrimSpace(req.Name),
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		Prizes:    req.Prizes,
+		Status:    entities.TournamentStatusOpen,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if tournament.Prizes == nil {
+		tournament.Prizes = []*entities.TournamentPrize{}
+	}
+	if err := t.tr.Create(tournament); err != nil {
+		return nil, err
+	}
+	t.invalidate()
+	return tournament, nil
+}
+
+// Update changes an open tournament, the start can only be moved before the tournament has started
+func (t *TournamentService) Update(tournamentId string, req *entities.TournamentRequest) (*entities.Tournament, error) {
+	if err := validateTournamentRequest(req); err != nil {
+		return nil, err
+	}
+	tournament, err := t.Get(tournamentId)
+	if err != nil {
+		return nil, err
+	}
+	if tournament.Status != entities.TournamentStatusOpen {
+		return nil, ErrTournamentClosed
+	}
+	if req.StartsAt != tournament.StartsAt && time.Now().UnixMilli() >= tournament.StartsAt {
+		return nil, ErrInvalidTournament
+	}
+	tournament.Name = strings.TrimSpace(req.Name)
+	tournament.StartsAt = req.StartsAt
+	tournament.EndsAt = req.EndsAt
+	tournament.Prizes = req.Prizes
+	if tournament.Prizes == nil {
+		tournament.Prizes = []*entities.TournamentPrize{}
+	}
+	updated, err := t.tr.Update(tournament)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrTournamentClosed
+	}
+	t.invalidate()
+	return tournament, nil
+}
+
+// Delete removes the tournament with all its data, payouts already published stay paid
+func (t *TournamentService) Delete(tournamentId string) error {
+	if _, err := t.Get(tournamentId); err != nil {
+		return err
+	}
+	if err := t.lr.DeleteTournamentLeaderboard(tournamentId); err != nil {
+		return err
+	}
+	if err := t.tr.Delete(tournamentId); err != nil {
+		return err
+	}
+	t.invalidate()
+	return nil
+}
+
+func (t *TournamentService) Get(tournamentId string) (*entities.Tournament, error) {
+	if _, err := gocql.ParseUUID(tournamentId); err != nil {
+		return nil, ErrTournamentNotFound
+	}
+	tournament, err := t.tr.Get(tournamentId)
+	if err != nil {
+		return nil, err
+	}
+	if tournament == nil {
+		return nil, ErrTournamentNotFound
+	}
+	return tournament, nil
+}
+
+// GetAll returns all tournaments, the latest to start first
+func (t *TournamentService) GetAll() ([]*entities.Tournament, error) {
+	tournaments, err := t.tr.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(tournaments, func(a, b *entities.Tournament) int {
+		return cmp.Compare(b.StartsAt, a.StartsAt)
+	})
+	return tournaments, nil
+}
+
+// Register enters the user into the tournament, which is possible until it ends
+func (t *TournamentService) Register(userId string, tournamentId string) error {
+	tournament, err := t.Get(tournamentId)
+	if err != nil {
+		return err
+	}
+	if tournament.Status != entities.TournamentStatusOpen || time.Now().UnixMilli() >= tournament.EndsAt {
+		return ErrTournamentClosed
+	}
+	userProfile, err := t.upr.GetUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	if userProfile == nil {
+		return ErrUserNotFound
+	}
+	if userProfile.Status == entities.UserStatusBanned {
+		return ErrTournamentForbidden
+	}
+	err = t.tr.Register(&entities.TournamentRegistration{
+		TournamentId: tournamentId,
+		UserId:       userId,
+		RegisteredAt: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		return err
+	}
+	return t.lr.AddTournamentUser(tournamentId, userId, userProfile.Status == entities.UserStatusShadowBanned)
+}
+
+// Unregister takes the user and their score out of the tournament, final results of closed ones are kept
+func (t *TournamentService) Unregister(userId string, tournamentId string) error {
+	tournament, err := t.Get(tournamentId)
+	if err != nil {
+		return err
+	}
+	if tournament.Status != entities.TournamentStatusOpen {
+		return ErrTournamentClosed
+	}
+	if err := t.tr.Unregister(tournamentId, userId); err != nil {
+		return err
+	}
+	return t.lr.RemoveTournamentUser(tournamentId, userId)
+}
+
+func (t *TournamentService) GetUserRegistrations(userId string) ([]*entities.TournamentRegistration, error) {
+	return t.tr.GetUserRegistrations(userId)
+}
+
+func (t *TournamentService) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running = nil
+}
+
+// getRunning returns open tournaments that may still take actions, cached since every action looks at them
+func (t *TournamentService) getRunning() ([]*entities.Tournament, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.running != nil && time.Since(t.refreshedAt) < t.refresh {
+		return t.running, nil
+	}
+	tournaments, err := t.tr.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	running := make([]*entities.Tournament, 0)
+	for _, tournament := range tournaments {
+		if tournament.Status == entities.TournamentStatusOpen {
+			running = append(running, tournament)
+		}
+	}
+	t.running, t.refreshedAt = running, time.Now()
+	return running, nil
+}
+
+// HandleAction scores the action in every tournament whose window its timestamp falls into,
+// tournaments the user isn't registered for are left untouched
+func (t *TournamentService) HandleAction(userProfile *entities.UserProfile, action *entities.GameAction, score int) error {
+	running, err := t.getRunning()
+	if err != nil {
+		return err
+	}
+	timestamp := int64(action.Timestamp * 1000)
+	shadow := userProfile.Status == entities.UserStatusShadowBanned
+	for _, tournament := range running {
+		if timestamp < tournament.StartsAt || timestamp >= tournament.EndsAt {
+			continue
+		}
+		counted, err := t.lr.UpdateTournamentScore(tournament.Id, userProfile.Id, score, shadow)
+		if err != nil {
+			return err
+		}
+		if counted {
+			metrics.GetOrCreateCounter(t.game.MetricName(`tournament_actions_total`)).Inc()
+		}
+	}
+	return nil
+}
+
+// GetLeaderboard returns the live standings of a running tournament as the user sees them,
+// or the frozen final standings of a closed one
+func (t *TournamentService) GetLeaderboard(userProfile *entities.UserProfile, tournamentId string) (*entities.TournamentLeaderboard, error) {
+	tournament, err := t.Get(tournamentId)
+	if err != nil {
+		return nil, err
+	}
+	tournamentLeaderboard := &entities.TournamentLeaderboard{Tournament: tournament}
+	if tournament.Status == entities.TournamentStatusClosed {
+		return t.withFinalStandings(tournamentLeaderboard, userProfile)
+	}
+	standings, err := t.lr.GetTournamentStandings(tournamentId, 0, repositories.LeaderboardTopSize)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := t.ls.withNicknames(standings)
+	if err != nil {
+		return nil, err
+	}
+	userScore, err := t.lr.GetTournamentUserScore(tournamentId, userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	tournamentLeaderboard.Scores, tournamentLeaderboard.User = t.ls.placeUser(userProfile, scores, userScore)
+	return tournamentLeaderboard, nil
+}
+
+func (t *TournamentService) withFinalStandings(tournamentLeaderboard *entities.TournamentLeaderboard, userProfile *entities.UserProfile) (*entities.TournamentLeaderboard, error) {
+	tournamentId := tournamentLeaderboard.Tournament.Id
+	standings, err := t.tr.GetStandings(tournamentId, repositories.LeaderboardTopSize)
+	if err != nil {
+		return nil, err
+	}
+	scores := make([]*entities.LeaderboardScore, 0, len(standings))
+	for _, standing := range standings {
+		scores = append(scores, &entities.LeaderboardScore{
+			UserId:   standing.UserId,
+			Score:    standing.Score,
+			Position: standing.Position,
+		})
+	}
+	tournamentLeaderboard.Scores, err = t.ls.withNicknames(scores)
+	if err != nil {
+		return nil, err
+	}
+	registrations, err := t.tr.GetUserRegistrations(userProfile.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, registration := range registrations {
+		if registration.TournamentId == tournamentId && registration.Position > 0 {
+			tournamentLeaderboard.User = &entities.LeaderboardScoreFull{
+				LeaderboardScore: entities.LeaderboardScore{
+					UserId:   userProfile.Id,
+					Score:    registration.Score,
+					Position: registration.Position,
+				},
+				Nickname: t.ls.nicknameFilter.Mask(userProfile.Nickname),
+			}
+		}
+	}
+	return tournamentLeaderboard, nil
+}
+
+// CloseEnded closes tournaments past their end and grace period. A close is claimed with a lightweight
+// transaction so only one instance runs it, one that has been running for longer than the lock ttl
+// is assumed dead and taken over.
+func (t *TournamentService) CloseEnded() error {
+	tournaments, err := t.tr.GetAll()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, tournament := range tournaments {
+		var claimed bool
+		switch {
+		case tournament.Status == entities.TournamentStatusOpen && now.UnixMilli() >= tournament.EndsAt+t.closeGrace.Milliseconds():
+			claimed, err = t.tr.ClaimClose(tournament.Id, 0, now.UnixMilli())
+		case tournament.Status == entities.TournamentStatusClosing && now.UnixMilli()-tournament.ClosingAt >= t.lockTtl.Milliseconds():
+			claimed, err = t.tr.ClaimClose(tournament.Id, tournament.ClosingAt, now.UnixMilli())
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		if err := t.close(tournament); err != nil {
+			return err
+		}
+		t.invalidate()
+	}
+	return nil
+}
+
+// close freezes the standings and publishes payouts. It may be run again for the same tournament after a failure,
+// payout events are keyed by user so consumers can deduplicate by tournament and user.
+func (t *TournamentService) close(tournament *entities.Tournament) error {
+	start := time.Now()
+	standings, err := t.lr.GetTournamentStandings(tournament.Id, 0, -1)
+	if err != nil {
+		return err
+	}
+	events := make([]Event, 0)
+	payouts := make([]*entities.TournamentStanding, 0)
+	for _, score := range standings {
+		standing := &entities.TournamentStanding{
+			TournamentId: tournament.Id,
+			Position:     score.Position,
+			UserId:       score.UserId,
+			Score:        score.Score,
+			RewardId:     prizeFor(tournament.Prizes, score.Position),
+		}
+		if err := t.tr.SaveStanding(standing); err != nil {
+			return err
+		}
+		if standing.RewardId != "" {
+			events = append(events, Event{Key: standing.UserId, Payload: standing})
+			payouts = append(payouts, standing)
+		}
+	}
+	if err := t.ep.Publish(t.topic, events...); err != nil {
+		return err
+	}
+	metrics.GetOrCreateCounter(t.game.MetricName(`tournament_payouts_total`)).Add(len(events))
+	if err := t.lr.DeleteTournamentLeaderboard(tournament.Id); err != nil {
+		return err
+	}
+	closedAt := time.Now().UnixMilli()
+	if err := t.tr.MarkClosed(tournament.Id, closedAt); err != nil {
+		return err
+	}
+	tournament.Status, tournament.ClosedAt = entities.TournamentStatusClosed, closedAt
+	t.ws.Emit(entities.WebhookEventTournamentFinished, &entities.TournamentFinishedEvent{
+		Tournament:   tournament,
+		Participants: len(standings),
+		Payouts:      payouts,
+	})
+	slog.With("tournamentId", tournament.Id, "users", len(standings), "payouts", len(events), "duration", time.Since(start)).Info("Tournament closed")
+	return nil
+}
+
+func prizeFor(prizes []*entities.TournamentPrize, position int) string {
+	for _, prize := range prizes {
+		if position >= prize.FromRank && position <= prize.ToRank {
+			return prize.RewardId
+		}
+	}
+	return ""
+}
+
+// forEachOpenRegistration runs fn for every tournament the user is registered for that isn't closed yet
+func (t *TournamentService) forEachOpenRegistration(userId string, fn func(tournamentId string) error) error {
+	registrations, err := t.tr.GetUserRegistrations(userId)
+	if err != nil || len(registrations) == 0 {
+		return err
+	}
+	running, err := t.getRunning()
+	if err != nil {
+		return err
+	}
+	for _, registration := range registrations {
+		isRunning := slices.ContainsFunc(running, func(tournament *entities.Tournament) bool {
+			return tournament.Id == registration.TournamentId
+		})
+		if !isRunning {
+			continue
+		}
+		if err := fn(registration.TournamentId); err != nil {
+			return fmt.Errorf("tournament %s: %w", registration.TournamentId, err)
+		}
+	}
+	return nil
+}
+
+// RemoveUser takes the user out of running tournaments, their registrations are kept
+func (t *TournamentService) RemoveUser(userId string) error {
+	return t.forEachOpenRegistration(userId, func(tournamentId string) error {
+		return t.lr.RemoveTournamentUser(tournamentId, userId)
+	})
+}
+
+func (t *TournamentService) MoveToShadow(userId string) error {
+	return t.forEachOpenRegistration(userId, func(tournamentId string) error {
+		return t.lr.MoveToTournamentShadow(tournamentId, userId)
+	})
+}
+
+// RestoreUser makes the user visible again in running tournaments, with zero score if they were banned
+func (t *TournamentService) RestoreUser(userId string) error {
+	return t.forEachOpenRegistration(userId, func(tournamentId string) error {
+		if err := t.lr.MoveFromTournamentShadow(tournamentId, userId); err != nil {
+			return err
+		}
+		return t.lr.AddTournamentUser(tournamentId, userId, false)
+	})
+}
+
+// EraseUser removes the user from all tournaments, final standings included
+func (t *TournamentService) EraseUser(userId string) error {
+	if err := t.RemoveUser(userId); err != nil {
+		return err
+	}
+	return t.tr.DeleteUserRegistrations(userId)
+}
+
+func (t *TournamentService) Purge() error {
+	if err := t.tr.Purge(); err != nil {
+		return err
+	}
+	t.invalidate()
+	return nil
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"time"
+)
+
+// UserDataService serves data subject requests: erasure and export of everything stored about a user.
+// Every store keeping per-user data has to be covered here.
+type UserDataService struct {
+	upr repositories.UserProfileRepository
+	lr  repositories.LeaderboardRepo
+	uxr repositories.UserXpRepository
+	acr repositories.AntiCheatRepository
+	qr  repositories.QuarantineRepository
+	mar repositories.ModerationAuditRepository
+	lrr repositories.LeagueResultRepository
+	lms *LeaderboardMembershipService
+	fr  repositories.FriendshipRepository
+	cs  *ClanService
+	ts  *TournamentService
+	as  *AchievementService
+	ass *ActionStatsService
+	rhs *RankHistoryService
+	als *ActionLogService
+	ns  *NicknameService
+	gc  *game_config.GameConfig
+}
+
+func NewUserDataService(gc *game_config.GameConfig, upr repositories.UserProfileRepository, lr repositories.LeaderboardRepo, uxr repositories.UserXpRepository, acr repositories.AntiCheatRepository, qr repositories.QuarantineRepository, mar repositories.ModerationAuditRepository, lrr repositories.LeagueResultRepository, lms *LeaderboardMembershipService, fr repositories.FriendshipRepository, cs *ClanService, ts *TournamentService, as *AchievementService, ass *ActionStatsService, rhs *RankHistoryService, als *ActionLogService, ns *NicknameService) *UserDataService {
+	return &UserDataService{
+		upr: upr,
+		lr:  lr,
+		uxr: uxr,
+		acr: acr,
+		qr:  qr,
+		mar: mar,
+		lrr: lrr,
+		lms: lms,
+		fr:  fr,
+		cs:  cs,
+		ts:  ts,
+		as:  as,
+		ass: ass,
+		rhs: rhs,
+		als: als,
+		ns:  ns,
+		gc:  gc,
+	}
+}
+
+// Erase removes the user everywhere. The profile goes last, so a failed erasure can simply be retried.
+func (u *UserDataService) Erase(userId string) error {
+	userProfile, err := u.upr.GetUserProfile(userId)
+	if err != nil {
+		return err
+	}
+	if userProfile == nil {
+		return ErrUserNotFound
+	}
+	if err := u.lms.DeleteUserMemberships(userProfile); err != nil {
+		return err
+	}
+	if err := u.lr.RemoveUser(userProfile.Leaderboard, userId); err != nil {
+		return err
+	}
+	if err := u.lr.RemoveFromGlobal(userId); err != nil {
+		return err
+	}
+	if err := u.uxr.DeleteXp([]string{userId}); err != nil {
+		return err
+	}
+	actions := slices.Collect(maps.Keys(u.gc.ActionsScoreMap))
+	if err := u.acr.DeleteUserState(userId, []string{StageProduce, StageConsume}, actions); err != nil {
+		return err
+	}
+	if err := u.qr.DeleteUserQuarantine(userId); err != nil {
+		return err
+	}
+	if err := u.mar.DeleteUserAudit(userId); err != nil {
+		return err
+	}
+	if err := u.lrr.DeleteUserResults(userId); err != nil {
+		return err
+	}
+	if err := u.fr.DeleteUserFriendships(userId); err != nil {
+		return err
+	}
+	if err := u.cs.RemoveUser(userProfile); err != nil {
+		return err
+	}
+	if err := u.ts.EraseUser(userId); err != nil {
+		return err
+	}
+	if err := u.as.EraseUser(userId); err != nil {
+		return err
+	}
+	if err := u.ass.EraseUser(userId); err != nil {
+		return err
+	}
+	if err := u.rhs.EraseUser(userId); err != nil {
+		return err
+	}
+	if err := u.als.EraseUser(userId); err != nil {
+		return err
+	}
+	if err := u.ns.Release(userProfile.Nickname, userId); err != nil {
+		return err
+	}
+	if err := u.upr.Delete([]string{userId}); err != nil {
+		return err
+	}
+	slog.With("userId", userId).Info("User data erased")
+	return nil
+}
+
+// Export bundles everything stored about the user that the user may see. Moderation and anti-cheat
+// records are left out, they would tell a shadow banned or flagged user about it.
+func (u *UserDataService) Export(userId string) (*entities.UserDataExport, error) {
+	return u.export(userId, false)
+}
+
+// ExportWithModeration is the backoffice export, it adds moderation and anti-cheat records.
+func (u *UserDataService) ExportWithModeration(userId string) (*entities.UserDataExport, error) {
+	return u.export(userId, true)
+}
+
+// withoutModerationOutcomes shows shadow-banned actions as applied, the way the user saw them, and leaves out
+// quarantined ones, which are anti-cheat records
+func withoutModerationOutcomes(actionLog []*entities.ActionLogEntry) []*entities.ActionLogEntry {
+	entries := make([]*entities.ActionLogEntry, 0, len(actionLog))
+	for _, entry := range actionLog {
+		switch entry.Outcome {
+		case entities.ActionOutcomeQuarantined:
+			continue
+		case entities.ActionOutcomeShadow:
+			entry.Outcome = entities.ActionOutcomeApplied
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (u *UserDataService) export(userId string, withModeration bool) (*entities.UserDataExport, error) {
+	userProfile, err := u.upr.GetUserProfile(userId)
+	if err != nil {
+		return nil, err
+	}
+	if userProfile == nil {
+		return nil, ErrUserNotFound
+	}
+	xp, err := u.uxr.GetManyUsersXp([]string{userId})
+	if err != nil {
+		return nil, err
+	}
+	userProfile.Xp = xp[userId]
+	leaderboardScore, err := u.lr.GetUserScore(userProfile.Leaderboard, userId)
+	if err != nil {
+		return nil, err
+	}
+	leaderboardStandings, err := u.lms.GetUserStandings(userId)
+	if err != nil {
+		return nil, err
+	}
+	globalScore, err := u.lr.GetGlobalUserScore(userId)
+	if err != nil {
+		return nil, err
+	}
+	leagueResults, err := u.lrr.GetUserResults(userId, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	friendships, err := u.fr.GetFriends(userId)
+	if err != nil {
+		return nil, err
+	}
+	friendRequests, err := u.fr.GetRequests(userId)
+	if err != nil {
+		return nil, err
+	}
+	clanContribution, err := u.cs.GetUserContribution(userProfile)
+	if err != nil {
+		return nil, err
+	}
+	tournamentRegistrations, err := u.ts.GetUserRegistrations(userId)
+	if err != nil {
+		return nil, err
+	}
+	achievements, err := u.as.GetUnlocked(userId)
+	if err != nil {
+		return nil, err
+	}
+	actionStats, err := u.ass.GetAllStats(userId)
+	if err != nil {
+		return nil, err
+	}
+	rankHistory, err := u.rhs.GetRetainedHistory(userId)
+	if err != nil {
+		return nil, err
+	}
+	actionLog, err := u.als.GetRetainedLog(userId)
+	if err != nil {
+		return nil, err
+	}
+	export := &entities.UserDataExport{
+		ExportedAt:       time.Now().UnixMilli(),
+		Profile:          userProfile,
+		Xp:               userProfile.Xp,
+		LeaderboardScore: leaderboardScore,
+		Leaderboards:     leaderboardStandings,
+		GlobalScore:      globalScore,
+		LeagueResults:    leagueResults,
+		Friendships:      friendships,
+		FriendRequests:   friendRequests,
+		ClanContribution: clanContribution,
+		Tournaments:      tournamentRegistrations,
+		Achievements:     achievements,
+		ActionStats:      actionStats.Total,
+		DailyActionStats: actionStats.Daily,
+		RankHistory:      rankHistory.Series,
+		ActionLog:        actionLog,
+	}
+	if !withModeration {
+		export.ActionLog = withoutModerationOutcomes(actionLog)
+		return export, nil
+	}
+	flagged, err := u.acr.IsFlagged(userId)
+	if err != nil {
+		return nil, err
+	}
+	quarantinedActions, err := u.qr.GetUserQuarantine(userId)
+	if err != nil {
+		return nil, err
+	}
+	moderationAudit, err := u.mar.GetUserAudit(userId)
+	if err != nil {
+		return nil, err
+	}
+	export.Moderation = &entities.UserModerationExport{
+		Status:             userProfile.Status,
+		Flagged:            flagged,
+		QuarantinedActions: quarantinedActions,
+		ModerationAudit:    moderationAudit,
+	}
+	return export, nil
+}
@@ -0,0 +1,406 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gocql/gocql"
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"github.com/skif48/leaderboard-engine/graceful_shutdown"
+	"github.com/skif48/leaderboard-engine/repositories"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	webhookDeliveriesLimit = 100
+	webhookMaxResponseBody = 64 << 10
+)
+
+var webhookEventTypes = []string{
+	entities.WebhookEventLevelUp,
+	entities.WebhookEventEnteredTop10,
+	entities.WebhookEventTournamentFinished,
+}
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrInvalidWebhook          = errors.New("invalid webhook")
+	ErrWebhookQueueFull        = errors.New("webhook delivery queue is full")
+)
+
+// WebhookClient sends webhook requests, tests can swap in the client of an httptest server
+type WebhookClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+func NewWebhookClient(ac *app_config.AppConfig) WebhookClient {
+	return &http.Client{Timeout: ac.WebhookTimeout}
+}
+
+type webhookJob struct {
+	webhook  *entities.Webhook
+	delivery *entities.WebhookDelivery
+	// attempt counts the attempts of this round, a redelivery starts a new one
+	attempt int
+}
+
+// WebhookService calls partners' webhooks for events they registered for. Every payload goes to a delivery log
+// first and is then posted by background workers, retrying with exponential backoff. Workers make one attempt
+// at a time, retries wait on timers and are queued again once due, so a slow or dead partner can't hold up
+// the deliveries of the others. The body is signed with HMAC-SHA256 of "<timestamp>.<body>" using the webhook
+// secret, sent in the X-Webhook-Signature header along with the timestamp in X-Webhook-Timestamp. Deliveries still pending at shutdown or failed for good can be
+// redelivered from backoffice.
+type WebhookService struct {
+	wr          repositories.WebhookRepository
+	client      WebhookClient
+	maxAttempts int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	retention   time.Duration
+	refresh     time.Duration
+	game        *game_config.Game
+
+	queue chan *webhookJob
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	mu          sync.Mutex
+	webhooks    map[string][]*entities.Webhook
+	refreshedAt time.Time
+}
+
+func NewWebhookService(ac *app_config.AppConfig, game *game_config.Game, wr repositories.WebhookRepository, client WebhookClient) *WebhookService {
+	w := &WebhookService{
+		wr:          wr,
+		client:      client,
+		maxAttempts: ac.WebhookMaxAttempts,
+		backoffBase: ac.WebhookBackoffBase,
+		backoffMax:  ac.WebhookBackoffMax,
+		retention:   ac.WebhookDeliveryRetention,
+		refresh:     ac.WebhookRegistryRefresh,
+		queue:       make(chan *webhookJob, ac.WebhookQueueSize),
+		stop:        make(chan struct{}),
+		game:        game,
+	}
+	for range ac.WebhookWorkers {
+		w.wg.Add(1)
+		go w.work()
+	}
+	graceful_shutdown.AddOutputShutdownFunc(func() {
+		close(w.stop)
+		w.wg.Wait()
+		slog.Info("Webhook workers stopped")
+	})
+	return w
+}
+
+func (w *WebhookService) Register(req *entities.WebhookRequest) (*entities.Webhook, error) {
+	if !slices.Contains(webhookEventTypes, req.EventType) {
+		return nil, ErrInvalidWebhook
+	}
+	parsed, err := url.Parse(req.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, ErrInvalidWebhook
+	}
+	secret := req.Secret
+	if secret == "" {
+		secretBytes := make([]byte, 32)
+		if _, err := rand.Read(secretBytes); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(secretBytes)
+	}
+	id, _ := gocql.RandomUUID()
+	webhook := &entities.Webhook{
+		Id:        id.String(),
+		EventType: req.EventType,
+		Url:       req.Url,
+		Secret:    secret,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if err := w.wr.Create(webhook); err != nil {
+		return nil, err
+	}
+	w.invalidate()
+	return webhook, nil
+}
+
+func (w *WebhookService) Get(webhookId string) (*entities.Webhook, error) {
+	if _, err := gocql.ParseUUID(webhookId); err != nil {
+		return nil, ErrWebhookNotFound
+	}
+	webhook, err := w.wr.Get(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if webhook == nil {
+		return nil, ErrWebhookNotFound
+	}
+	return webhook, nil
+}
+
+func (w *WebhookService) GetAll() ([]*entities.Webhook, error) {
+	return w.wr.GetAll()
+}
+
+func (w *WebhookService) Delete(webhookId string) error {
+	if _, err := w.Get(webhookId); err != nil {
+		return err
+	}
+	if err := w.wr.Delete(webhookId); err != nil {
+		return err
+	}
+	w.invalidate()
+	return nil
+}
+
+// GetDeliveries returns the latest deliveries of the webhook, only those with the given status if there is one
+func (w *WebhookService) GetDeliveries(webhookId string, status string) ([]*entities.WebhookDelivery, error) {
+	if _, err := w.Get(webhookId); err != nil {
+		return nil, err
+	}
+	deliveries, err := w.wr.GetDeliveries(webhookId, webhookDeliveriesLimit)
+	if err != nil {
+		return nil, err
+	}
+	if status == "" {
+		return deliveries, nil
+	}
+	return slices.DeleteFunc(deliveries, func(delivery *entities.WebhookDelivery) bool {
+		return delivery.Status != status
+	}), nil
+}
+
+// Redeliver sends the logged payload again, with a fresh signature and a new round of attempts
+func (w *WebhookService) Redeliver(webhookId string, deliveryId string) (*entities.WebhookDelivery, error) {
+	webhook, err := w.Get(webhookId)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gocql.ParseUUID(deliveryId); err != nil {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	delivery, err := w.wr.GetDelivery(webhookId, deliveryId)
+	if err != nil {
+		return nil, err
+	}
+	if delivery == nil {
+		return nil, ErrWebhookDeliveryNotFound
+	}
+	delivery.Status = entities.WebhookDeliveryPending
+	delivery.UpdatedAt = time.Now().UnixMilli()
+	if err := w.wr.SaveDelivery(delivery, w.retention); err != nil {
+		return nil, err
+	}
+	if !w.enqueue(&webhookJob{webhook: webhook, delivery: delivery}) {
+		return nil, ErrWebhookQueueFull
+	}
+	return delivery, nil
+}
+
+func (w *WebhookService) invalidate() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.webhooks = nil
+}
+
+// getWebhooks returns the webhooks registered for the event type, cached since events are frequent
+func (w *WebhookService) getWebhooks(eventType string) ([]*entities.Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.webhooks == nil || time.Since(w.refreshedAt) >= w.refresh {
+		webhooks, err := w.wr.GetAll()
+		if err != nil {
+			return nil, err
+		}
+		w.webhooks = make(map[string][]*entities.Webhook)
+		for _, webhook := range webhooks {
+			w.webhooks[webhook.EventType] = append(w.webhooks[webhook.EventType], webhook)
+		}
+		w.refreshedAt = time.Now()
+	}
+	return w.webhooks[eventType], nil
+}
+
+// Subscribed tells whether anyone is registered for the event type, so events costly to detect can be skipped
+func (w *WebhookService) Subscribed(eventType string) bool {
+	webhooks, err := w.getWebhooks(eventType)
+	if err != nil {
+		slog.With("error", err).Error("Failed to get webhooks")
+		return false
+	}
+	return len(webhooks) > 0
+}
+
+// Emit logs the event for every webhook registered for its type and queues the deliveries. Failures are only
+// logged, webhooks must never fail the flow that raised the event.
+func (w *WebhookService) Emit(eventType string, data any) {
+	webhooks, err := w.getWebhooks(eventType)
+	if err != nil {
+		slog.With("error", err, "eventType", eventType).Error("Failed to get webhooks")
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+	eventId, _ := gocql.RandomUUID()
+	now := time.Now().UnixMilli()
+	body, err := json.Marshal(&entities.WebhookPayload{
+		Id:        eventId.String(),
+		EventType: eventType,
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		slog.With("error", err, "eventType", eventType).Error("Failed to marshal webhook payload")
+		return
+	}
+	for _, webhook := range webhooks {
+		delivery := &entities.WebhookDelivery{
+			WebhookId: webhook.Id,
+			Id:        gocql.TimeUUID().String(),
+			EventType: eventType,
+			Payload:   string(body),
+			Status:    entities.WebhookDeliveryPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := w.wr.SaveDelivery(delivery, w.retention); err != nil {
+			slog.With("error", err, "webhookId", webhook.Id).Error("Failed to log webhook delivery")
+			continue
+		}
+		if !w.enqueue(&webhookJob{webhook: webhook, delivery: delivery}) {
+			delivery.Status = entities.WebhookDeliveryFailed
+			delivery.LastError = ErrWebhookQueueFull.Error()
+			w.finish(delivery)
+		}
+	}
+}
+
+func (w *WebhookService) enqueue(job *webhookJob) bool {
+	select {
+	case w.queue <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *WebhookService) work() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case job := <-w.queue:
+			w.deliver(job)
+		}
+	}
+}
+
+// deliver makes one attempt and schedules the next one if the delivery is still pending
+func (w *WebhookService) deliver(job *webhookJob) {
+	delivery := job.delivery
+	job.attempt++
+	statusCode, err := w.send(job.webhook, delivery)
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = entities.WebhookDeliveryDelivered
+	case job.attempt >= w.maxAttempts:
+		delivery.Status = entities.WebhookDeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+	}
+	w.finish(delivery)
+	if delivery.Status == entities.WebhookDeliveryPending {
+		w.retryLater(job, w.backoff(job.attempt))
+	}
+}
+
+// retryLater queues the job again after the wait. A full queue postpones the retry rather than failing it,
+// a shutdown leaves the delivery pending.
+func (w *WebhookService) retryLater(job *webhookJob, wait time.Duration) {
+	time.AfterFunc(wait, func() {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		if !w.enqueue(job) {
+			w.retryLater(job, w.backoff(job.attempt))
+		}
+	})
+}
+
+func (w *WebhookService) backoff(attempt int) time.Duration {
+	backoff := w.backoffBase << (attempt - 1)
+	if backoff <= 0 || backoff > w.backoffMax {
+		return w.backoffMax
+	}
+	return backoff
+}
+
+// finish records the outcome of an attempt
+func (w *WebhookService) finish(delivery *entities.WebhookDelivery) {
+	delivery.UpdatedAt = time.Now().UnixMilli()
+	if delivery.Status != entities.WebhookDeliveryPending {
+		metrics.GetOrCreateCounter(w.game.MetricName(fmt.Sprintf(`webhook_deliveries_total{event_type=%q,status=%q}`, delivery.EventType, delivery.Status))).Inc()
+	}
+	if err := w.wr.SaveDelivery(delivery, w.retention); err != nil {
+		slog.With("error", err, "webhookId", delivery.WebhookId, "deliveryId", delivery.Id).Error("Failed to log webhook delivery")
+	}
+}
+
+func (w *WebhookService) send(webhook *entities.Webhook, delivery *entities.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery", delivery.Id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, webhookMaxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func signWebhookPayload(secret string, timestamp string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PurgeDeliveries clears the delivery log, registered webhooks are configuration and survive purges
+func (w *WebhookService) PurgeDeliveries() error {
+	return w.wr.PurgeDeliveries()
+}
@@ -0,0 +1,285 @@
+package services
+
+import (
+	"github.com/skif48/leaderboard-engine/app_config"
+	"github.com/skif48/leaderboard-engine/entities"
+	"github.com/skif48/leaderboard-engine/game_config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookRepositoryMemory keeps webhooks and copies of their deliveries in memory
+type webhookRepositoryMemory struct {
+	mu         sync.Mutex
+	webhooks   map[string]*entities.Webhook
+	deliveries map[string]entities.WebhookDelivery
+}
+
+func newWebhookRepositoryMemory() *webhookRepositoryMemory {
+	return &webhookRepositoryMemory{
+		webhooks:   make(map[string]*entities.Webhook),
+		deliveries: make(map[string]entities.WebhookDelivery),
+	}
+}
+
+func (m *webhookRepositoryMemory) Create(webhook *entities.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.webhooks[webhook.Id] = webhook
+	return nil
+}
+
+func (m *webhookRepositoryMemory) Get(webhookId string) (*entities.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.webhooks[webhookId], nil
+}
+
+func (m *webhookRepositoryMemory) GetAll() ([]*entities.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhooks := make([]*entities.Webhook, 0, len(m.webhooks))
+	for _, webhook := range m.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (m *webhookRepositoryMemory) Delete(webhookId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.webhooks, webhookId)
+	return nil
+}
+
+func (m *webhookRepositoryMemory) SaveDelivery(delivery *entities.WebhookDelivery, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries[delivery.Id] = *delivery
+	return nil
+}
+
+func (m *webhookRepositoryMemory) GetDelivery(webhookId string, deliveryId string) (*entities.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery, ok := m.deliveries[deliveryId]
+	if !ok || delivery.WebhookId != webhookId {
+		return nil, nil
+	}
+	return &delivery, nil
+}
+
+func (m *webhookRepositoryMemory) GetDeliveries(webhookId string, limit int) ([]*entities.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deliveries := make([]*entities.WebhookDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookId == webhookId && len(deliveries) < limit {
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *webhookRepositoryMemory) PurgeDeliveries() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries = make(map[string]entities.WebhookDelivery)
+	return nil
+}
+
+func newTestWebhookService(t *testing.T, workers int, maxAttempts int, backoffBase time.Duration) (*WebhookService, *webhookRepositoryMemory) {
+	t.Helper()
+	ac := &app_config.AppConfig{
+		WebhookWorkers:           workers,
+		WebhookQueueSize:         100,
+		WebhookMaxAttempts:       maxAttempts,
+		WebhookBackoffBase:       backoffBase,
+		WebhookBackoffMax:        time.Hour,
+		WebhookDeliveryRetention: time.Hour,
+		WebhookRegistryRefresh:   time.Hour,
+	}
+	wr := newWebhookRepositoryMemory()
+	w := NewWebhookService(ac, &game_config.Game{Id: "default", Default: true}, wr, http.DefaultClient)
+	t.Cleanup(func() {
+		close(w.stop)
+		w.wg.Wait()
+	})
+	return w, wr
+}
+
+func registerTestWebhook(t *testing.T, w *WebhookService, url string) *entities.Webhook {
+	t.Helper()
+	webhook, err := w.Register(&entities.WebhookRequest{EventType: entities.WebhookEventLevelUp, Url: url, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+// waitForDelivery waits until the webhook has a delivery with the status and returns it
+func waitForDelivery(t *testing.T, wr *webhookRepositoryMemory, webhookId string, status string) *entities.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		deliveries, _ := wr.GetDeliveries(webhookId, webhookDeliveriesLimit)
+		for _, delivery := range deliveries {
+			if delivery.Status == status {
+				return delivery
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no %s delivery of webhook %s", status, webhookId)
+	return nil
+}
+
+func TestWebhookSignsPayload(t *testing.T) {
+	w, wr := newTestWebhookService(t, 1, 1, time.Millisecond)
+	type received struct {
+		header http.Header
+		body   string
+	}
+	requests := make(chan received, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header.Clone(), body: string(body)}
+	}))
+	defer server.Close()
+	webhook := registerTestWebhook(t, w, server.URL)
+
+	w.Emit(entities.WebhookEventLevelUp, &entities.LevelUpEvent{UserId: "user", PreviousLevel: 1, Level: 2})
+
+	req := <-requests
+	timestamp := req.header.Get("X-Webhook-Timestamp")
+	if timestamp == "" {
+		t.Fatal("missing X-Webhook-Timestamp")
+	}
+	if got, want := req.header.Get("X-Webhook-Signature"), "sha256="+signWebhookPayload("secret", timestamp, req.body); got != want {
+		t.Fatalf("signature %q, want %q", got, want)
+	}
+	if got := req.header.Get("X-Webhook-Event"); got != entities.WebhookEventLevelUp {
+		t.Fatalf("event %q, want %q", got, entities.WebhookEventLevelUp)
+	}
+	delivery := waitForDelivery(t, wr, webhook.Id, entities.WebhookDeliveryDelivered)
+	if req.header.Get("X-Webhook-Delivery") != delivery.Id || req.body != delivery.Payload {
+		t.Fatal("request doesn't match the logged delivery")
+	}
+	if delivery.Attempts != 1 || delivery.LastStatusCode != http.StatusOK {
+		t.Fatalf("attempts %d with status %d, want 1 with 200", delivery.Attempts, delivery.LastStatusCode)
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	const backoffBase = 20 * time.Millisecond
+	w, wr := newTestWebhookService(t, 1, 5, backoffBase)
+	var mu sync.Mutex
+	attemptTimes := make([]time.Time, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attemptTimes = append(attemptTimes, time.Now())
+		if len(attemptTimes) < 3 {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	webhook := registerTestWebhook(t, w, server.URL)
+
+	w.Emit(entities.WebhookEventLevelUp, &entities.LevelUpEvent{UserId: "user"})
+
+	delivery := waitForDelivery(t, wr, webhook.Id, entities.WebhookDeliveryDelivered)
+	if delivery.Attempts != 3 {
+		t.Fatalf("attempts %d, want 3", delivery.Attempts)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(attemptTimes); i++ {
+		if wait, want := attemptTimes[i].Sub(attemptTimes[i-1]), backoffBase<<(i-1); wait < want {
+			t.Fatalf("retry %d after %s, want at least %s", i, wait, want)
+		}
+	}
+}
+
+func TestWebhookFailsAfterMaxAttempts(t *testing.T) {
+	w, wr := newTestWebhookService(t, 1, 3, time.Millisecond)
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		rw.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	webhook := registerTestWebhook(t, w, server.URL)
+
+	w.Emit(entities.WebhookEventLevelUp, &entities.LevelUpEvent{UserId: "user"})
+
+	delivery := waitForDelivery(t, wr, webhook.Id, entities.WebhookDeliveryFailed)
+	if delivery.Attempts != 3 || attempts.Load() != 3 {
+		t.Fatalf("attempts %d logged and %d received, want 3", delivery.Attempts, attempts.Load())
+	}
+	if delivery.LastStatusCode != http.StatusBadGateway || delivery.LastError == "" {
+		t.Fatalf("last status %d with error %q, want 502 with an error", delivery.LastStatusCode, delivery.LastError)
+	}
+}
+
+func TestWebhookRetryDoesNotHoldWorker(t *testing.T) {
+	// a single worker and a backoff longer than the test, a worker waiting it out would never get to the other webhook
+	w, wr := newTestWebhookService(t, 1, 5, time.Hour)
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	failingWebhook := registerTestWebhook(t, w, failing.URL)
+	healthyWebhook := registerTestWebhook(t, w, healthy.URL)
+
+	w.Emit(entities.WebhookEventLevelUp, &entities.LevelUpEvent{UserId: "user"})
+	w.Emit(entities.WebhookEventLevelUp, &entities.LevelUpEvent{UserId: "user"})
+
+	waitForDelivery(t, wr, healthyWebhook.Id, entities.WebhookDeliveryDelivered)
+	delivery := waitForDelivery(t, wr, failingWebhook.Id, entities.WebhookDeliveryPending)
+	if delivery.LastStatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("last status %d, want 503", delivery.LastStatusCode)
+	}
+}
+
+func TestWebhookRedeliver(t *testing.T) {
+	w, wr := newTestWebhookService(t, 1, 1, time.Millisecond)
+	var healthy atomic.Bool
+	var signatures sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Webhook-Signature") == "sha256="+signWebhookPayload("secret", r.Header.Get("X-Webhook-Timestamp"), string(body)) {
+			signatures.Store(r.Header.Get("X-Webhook-Delivery"), true)
+		}
+		if !healthy.Load() {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	webhook := registerTestWebhook(t, w, server.URL)
+
+	w.Emit(entities.WebhookEventLevelUp, &entities.LevelUpEvent{UserId: "user"})
+	failed := waitForDelivery(t, wr, webhook.Id, entities.WebhookDeliveryFailed)
+
+	healthy.Store(true)
+	if _, err := w.Redeliver(webhook.Id, failed.Id); err != nil {
+		t.Fatal(err)
+	}
+	delivered := waitForDelivery(t, wr, webhook.Id, entities.WebhookDeliveryDelivered)
+	if delivered.Id != failed.Id || delivered.Payload != failed.Payload {
+		t.Fatal("redelivery isn't the failed delivery")
+	}
+	if delivered.Attempts != 2 {
+		t.Fatalf("attempts %d, want 2", delivered.Attempts)
+	}
+	if _, signed := signatures.Load(delivered.Id); !signed {
+		t.Fatal("redelivery isn't signed")
+	}
+}